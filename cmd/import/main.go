@@ -0,0 +1,80 @@
+// Command import loads a CSV, JSON or NDJSON catalog file into a store.
+//
+//	go run ./cmd/import -store 3 -file products.csv -dry-run
+//
+// It validates the whole file first and prints the report; without
+// -dry-run a valid file is then applied in batches.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/blanc42/ecms/pkg/catalog"
+	"github.com/blanc42/ecms/pkg/initializers"
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/models"
+)
+
+func main() {
+	storeID := flag.Uint("store", 0, "ID of the store to import into")
+	path := flag.String("file", "", "catalog file to import")
+	format := flag.String("format", "", "csv, json, ndjson or xlsx (default: from the file extension)")
+	dryRun := flag.Bool("dry-run", false, "only validate the file")
+	batchSize := flag.Int("batch", 100, "products per transaction")
+	flag.Parse()
+
+	if *storeID == 0 || *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = catalog.FormatFromName(*path)
+	}
+
+	initializers.LoadEnvVariables()
+	initializers.ConnectToDB()
+
+	var store models.Store
+	if err := initializers.DB.First(&store, *storeID).Error; err != nil {
+		log.Fatalf("store %d not found", *storeID)
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	rows, rowErrors, err := catalog.Parse(file, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	plan, report, err := catalog.Validate(initializers.DB, store.ID, rows, rowErrors)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encoded, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(encoded))
+
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+	if *dryRun {
+		return
+	}
+
+	actor := inventory.Movement{ActorType: models.ActorSystem}
+	err = catalog.Apply(context.Background(), initializers.DB, plan, *batchSize, actor, func(processed int) {
+		fmt.Printf("imported %d/%d rows\n", processed, report.Rows)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
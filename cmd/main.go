@@ -1,17 +1,60 @@
 package main
 
 import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/categories"
+	"github.com/blanc42/ecms/pkg/collections"
+	"github.com/blanc42/ecms/pkg/feeds"
+	"github.com/blanc42/ecms/pkg/handlers"
 	"github.com/blanc42/ecms/pkg/initializers"
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/jobs"
+	"github.com/blanc42/ecms/pkg/media"
+	"github.com/blanc42/ecms/pkg/notifications"
 	"github.com/blanc42/ecms/pkg/routes"
+	"github.com/blanc42/ecms/pkg/search"
+	"github.com/blanc42/ecms/pkg/slugs"
 	"github.com/gin-gonic/gin"
 )
 
 func init() {
 	initializers.LoadEnvVariables()
 	initializers.ConnectToDB()
+
+	if err := categories.EnsureSchema(initializers.DB); err != nil {
+		log.Printf("categories: failed to set up paths: %v", err)
+	} else if ids, err := categories.Unpathed(initializers.DB); err != nil {
+		log.Printf("categories: failed to check paths: %v", err)
+	} else if len(ids) > 0 {
+		log.Printf("categories: %d categories are caught in loops of parents and have no path, move them out of the loop: %v", len(ids), ids)
+	}
+	if err := search.EnsureSchema(initializers.DB); err != nil {
+		log.Printf("search: failed to set up schema: %v", err)
+	}
+	if err := slugs.EnsureSchema(initializers.DB); err != nil {
+		log.Printf("slugs: failed to set up slugs: %v", err)
+	}
+	if failed, err := jobs.FailInterrupted(initializers.DB); err != nil {
+		log.Printf("jobs: failed to fail interrupted jobs: %v", err)
+	} else if failed > 0 {
+		log.Printf("jobs: failed %d jobs interrupted by a restart", failed)
+	}
 }
 
 func main() {
+	go inventory.SweepReservations(context.Background(), initializers.DB, time.Minute)
+	go notifications.NewDispatcher(initializers.DB, initializers.NotificationChannels()...).Run(context.Background(), 30*time.Second)
+	go media.SweepOrphans(context.Background(), initializers.DB, initializers.BlobStore(), time.Hour)
+	go media.SweepCache(context.Background(), initializers.DB, initializers.ImageCache(), time.Hour,
+		initializers.Int64Env("IMAGE_CACHE_MAX_BYTES", 1<<30))
+	go feeds.SweepStale(context.Background(), initializers.DB, initializers.BlobStore(),
+		initializers.DurationEnv("FEED_REFRESH_INTERVAL", 5*time.Minute), initializers.DurationEnv("FEED_MAX_AGE", 6*time.Hour))
+	go handlers.NewPaymentHandler(initializers.DB, initializers.PaymentProviders()...).SweepRefunds(context.Background(), time.Minute)
+	go collections.SweepRules(context.Background(), initializers.DB, initializers.DurationEnv("COLLECTION_REFRESH_INTERVAL", time.Hour))
+
 	r := gin.Default()
 	routes.SetupRouter(r)
 	r.Run(":8080")
@@ -0,0 +1,387 @@
+// Package attributes checks descriptive product attributes, like material
+// or warranty, against a store's attribute library. Categories take their
+// attributes from the attribute sets assigned to them or to any of their
+// ancestors, and product values must fit the attribute's type.
+package attributes
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/categories"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/search"
+	"gorm.io/gorm"
+)
+
+// Attribute types
+const (
+	TypeText    = "text"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeEnum    = "enum"
+	TypeDate    = "date"
+)
+
+var ErrInUse = errors.New("attribute type cannot change while products have values for it")
+
+// codePattern keeps codes usable in query parameters
+var codePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Error is a problem with an attribute definition or value meant to be
+// shown to the client
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("attribute %q: %s", e.Code, e.Message)
+}
+
+// Check validates an attribute definition
+func Check(attribute models.Attribute) error {
+	if !codePattern.MatchString(attribute.Code) {
+		return &Error{attribute.Code, "code may only contain lowercase letters, digits and underscores"}
+	}
+	switch attribute.Type {
+	case TypeText, TypeNumber, TypeBoolean, TypeDate:
+		if len(attribute.Options) > 0 {
+			return &Error{attribute.Code, "only enum attributes have options"}
+		}
+	case TypeEnum:
+		if len(attribute.Options) == 0 {
+			return &Error{attribute.Code, "enum attributes need options"}
+		}
+		seen := make(map[string]bool)
+		for _, option := range attribute.Options {
+			if strings.TrimSpace(option) == "" || seen[option] {
+				return &Error{attribute.Code, "options must be distinct and not empty"}
+			}
+			seen[option] = true
+		}
+	default:
+		return &Error{attribute.Code, "type must be one of text, number, boolean, enum or date"}
+	}
+	if attribute.Unit != "" && attribute.Type != TypeNumber {
+		return &Error{attribute.Code, "only number attributes have a unit"}
+	}
+	return nil
+}
+
+// CheckChange makes sure updating an attribute keeps existing product
+// values valid: the type stays while there are values and enum options
+// in use stay
+func CheckChange(tx *gorm.DB, old, updated models.Attribute) error {
+	values := tx.Model(&models.ProductAttributeValue{}).Where("attribute_id = ?", old.ID)
+
+	if old.Type != updated.Type {
+		var count int64
+		if err := values.Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrInUse
+		}
+		return nil
+	}
+
+	if updated.Type == TypeEnum {
+		var used []string
+		if err := values.Distinct("text").Pluck("text", &used).Error; err != nil {
+			return err
+		}
+		for _, option := range used {
+			if !slices.Contains(updated.Options, option) {
+				return &Error{updated.Code, fmt.Sprintf("option %q is still used by products", option)}
+			}
+		}
+	}
+	return nil
+}
+
+// Assigned is an attribute products of a category take
+type Assigned struct {
+	models.Attribute
+	Required bool `json:"required"`
+	// AttributeSetID is the set the attribute came from
+	AttributeSetID uint `json:"attribute_set_id"`
+}
+
+// setAttribute is an attribute of a set assigned to CategoryID
+type setAttribute struct {
+	CategoryID uint
+	models.AttributeSetAttribute
+}
+
+// ForCategory lists the attributes of the sets assigned to category and
+// its ancestors, nearest sets first. An attribute in several sets is
+// listed once and required if any set requires it.
+func ForCategory(tx *gorm.DB, category models.Category) ([]Assigned, error) {
+	assigned := []Assigned{}
+	path := categories.PathIDs(category.Path)
+	if len(path) == 0 {
+		path = []uint{category.ID}
+	}
+
+	var rows []setAttribute
+	err := tx.Table("category_attribute_sets").
+		Select("category_attribute_sets.category_id, attribute_set_attributes.*").
+		Joins("JOIN attribute_sets ON attribute_sets.id = category_attribute_sets.attribute_set_id AND attribute_sets.deleted_at IS NULL").
+		Joins("JOIN attribute_set_attributes ON attribute_set_attributes.attribute_set_id = attribute_sets.id AND attribute_set_attributes.deleted_at IS NULL").
+		Where("category_attribute_sets.category_id IN ?", path).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return assigned, nil
+	}
+
+	depth := make(map[uint]int, len(path))
+	for i, id := range path {
+		depth[id] = i
+	}
+	slices.SortStableFunc(rows, func(a, b setAttribute) int {
+		if depth[a.CategoryID] != depth[b.CategoryID] {
+			return depth[b.CategoryID] - depth[a.CategoryID]
+		}
+		if a.AttributeSetID != b.AttributeSetID {
+			return int(a.AttributeSetID) - int(b.AttributeSetID)
+		}
+		return a.Position - b.Position
+	})
+
+	var ids []uint
+	for _, row := range rows {
+		ids = append(ids, row.AttributeID)
+	}
+	var attributes []models.Attribute
+	if err := tx.Where("id IN ?", ids).Find(&attributes).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Attribute, len(attributes))
+	for _, attribute := range attributes {
+		byID[attribute.ID] = attribute
+	}
+
+	index := make(map[uint]int)
+	for _, row := range rows {
+		attribute, ok := byID[row.AttributeID]
+		if !ok {
+			continue
+		}
+		if i, ok := index[attribute.ID]; ok {
+			assigned[i].Required = assigned[i].Required || row.Required
+			continue
+		}
+		index[attribute.ID] = len(assigned)
+		assigned = append(assigned, Assigned{Attribute: attribute, Required: row.Required, AttributeSetID: row.AttributeSetID})
+	}
+	return assigned, nil
+}
+
+// Parse checks a value decoded from JSON against an attribute and puts it
+// in the column of the attribute's type
+func Parse(attribute models.Attribute, raw any) (models.ProductAttributeValue, error) {
+	value := models.ProductAttributeValue{AttributeID: attribute.ID}
+	switch attribute.Type {
+	case TypeText, TypeEnum:
+		s, ok := raw.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return value, &Error{attribute.Code, "must be a non-empty string"}
+		}
+		if attribute.Type == TypeEnum && !slices.Contains(attribute.Options, s) {
+			return value, &Error{attribute.Code, fmt.Sprintf("must be one of %s", strings.Join(attribute.Options, ", "))}
+		}
+		value.Text = &s
+	case TypeNumber:
+		f, ok := raw.(float64)
+		if !ok {
+			return value, &Error{attribute.Code, "must be a number"}
+		}
+		value.Number = &f
+	case TypeBoolean:
+		b, ok := raw.(bool)
+		if !ok {
+			return value, &Error{attribute.Code, "must be true or false"}
+		}
+		value.Bool = &b
+	case TypeDate:
+		s, _ := raw.(string)
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return value, &Error{attribute.Code, "must be a YYYY-MM-DD date"}
+		}
+		value.Date = &t
+	}
+	return value, nil
+}
+
+// Set writes attribute values of a product, keyed by attribute code. A
+// null value removes the attribute's value. Only attributes of the
+// product's category can be set, and the required ones must end up with a
+// value. Values of attributes the category doesn't have, left from a
+// category the product was in before, are dropped.
+func Set(tx *gorm.DB, product models.Product, values map[string]any) error {
+	assigned, err := Prune(tx, product)
+	if err != nil {
+		return err
+	}
+	byCode := make(map[string]Assigned, len(assigned))
+	for _, attribute := range assigned {
+		byCode[attribute.Code] = attribute
+	}
+
+	for code, raw := range values {
+		attribute, ok := byCode[code]
+		if !ok {
+			return &Error{code, "is not an attribute of the product's category"}
+		}
+
+		existing := tx.Unscoped().Where("product_id = ? AND attribute_id = ?", product.ID, attribute.ID)
+		if err := existing.Delete(&models.ProductAttributeValue{}).Error; err != nil {
+			return err
+		}
+		if raw == nil {
+			continue
+		}
+		value, err := Parse(attribute.Attribute, raw)
+		if err != nil {
+			return err
+		}
+		value.ProductID = product.ID
+		if err := tx.Create(&value).Error; err != nil {
+			return err
+		}
+	}
+
+	var set []uint
+	if err := tx.Model(&models.ProductAttributeValue{}).Where("product_id = ?", product.ID).Pluck("attribute_id", &set).Error; err != nil {
+		return err
+	}
+	for _, attribute := range assigned {
+		if attribute.Required && !slices.Contains(set, attribute.ID) {
+			return &Error{attribute.Code, "is required"}
+		}
+	}
+	return nil
+}
+
+// Prune drops a product's values of attributes its category doesn't have
+// and returns the category's attributes
+func Prune(tx *gorm.DB, product models.Product) ([]Assigned, error) {
+	var category models.Category
+	if err := tx.First(&category, product.CategoryID).Error; err != nil {
+		return nil, err
+	}
+	assigned, err := ForCategory(tx, category)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(assigned))
+	for i, attribute := range assigned {
+		ids[i] = attribute.ID
+	}
+	stale := tx.Unscoped().Where("product_id = ?", product.ID)
+	if len(ids) > 0 {
+		stale = stale.Where("attribute_id NOT IN ?", ids)
+	}
+	if err := stale.Delete(&models.ProductAttributeValue{}).Error; err != nil {
+		return nil, err
+	}
+	return assigned, nil
+}
+
+// ParseFilters reads attribute filters from a search query string:
+//
+//	?attr.material=cotton,linen&attr.waterproof=true&attr.weight[lte]=2
+//
+// Only the store's filterable attributes can be filtered by. Text and enum
+// attributes match any of a comma separated list, number and date
+// attributes also take gte and lte.
+func ParseFilters(tx *gorm.DB, storeID uint, query url.Values) ([]search.AttributeCondition, error) {
+	var conditions []search.AttributeCondition
+	var attributes map[string]models.Attribute
+
+	for param, raws := range query {
+		if !strings.HasPrefix(param, "attr.") {
+			continue
+		}
+		if attributes == nil {
+			var filterable []models.Attribute
+			if err := tx.Where("store_id = ? AND filterable", storeID).Find(&filterable).Error; err != nil {
+				return nil, err
+			}
+			attributes = make(map[string]models.Attribute, len(filterable))
+			for _, attribute := range filterable {
+				attributes[attribute.Code] = attribute
+			}
+		}
+
+		code, op := strings.TrimPrefix(param, "attr."), "eq"
+		if i := strings.IndexByte(code, '['); i >= 0 && strings.HasSuffix(code, "]") {
+			code, op = code[:i], code[i+1:len(code)-1]
+		}
+		attribute, ok := attributes[code]
+		if !ok {
+			return nil, &Error{code, "is not a filterable attribute"}
+		}
+
+		for _, raw := range raws {
+			condition, err := parseCondition(attribute, op, raw)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions, nil
+}
+
+func parseCondition(attribute models.Attribute, op, raw string) (search.AttributeCondition, error) {
+	condition := search.AttributeCondition{AttributeID: attribute.ID, Column: search.ValueColumn(attribute.Type), Op: op}
+	switch attribute.Type {
+	case TypeText, TypeEnum:
+		if op != "eq" {
+			return condition, &Error{attribute.Code, "can only be matched against values"}
+		}
+		condition.Op, condition.Value = "in", strings.Split(raw, ",")
+		return condition, nil
+	case TypeBoolean:
+		b, err := strconv.ParseBool(raw)
+		if op != "eq" || err != nil {
+			return condition, &Error{attribute.Code, "can only be matched against true or false"}
+		}
+		condition.Value = b
+		return condition, nil
+	}
+
+	if op != "eq" && op != "gte" && op != "lte" {
+		return condition, &Error{attribute.Code, fmt.Sprintf("operator %q is not supported, use eq, gte or lte", op)}
+	}
+	if attribute.Type == TypeDate {
+		t, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return condition, &Error{attribute.Code, fmt.Sprintf("%q is not a YYYY-MM-DD date", raw)}
+		}
+		condition.Value = t
+		return condition, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return condition, &Error{attribute.Code, fmt.Sprintf("%q is not a number", raw)}
+	}
+	condition.Value = f
+	return condition, nil
+}
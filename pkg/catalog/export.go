@@ -0,0 +1,324 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/xlsx"
+	"gorm.io/gorm"
+)
+
+// ExportTypes maps export formats to their file extension and content type
+var ExportTypes = map[string][2]string{
+	FormatCSV:    {".csv", "text/csv"},
+	FormatNDJSON: {".ndjson", "application/x-ndjson"},
+	FormatXLSX:   {".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+}
+
+// ExportFilter narrows an export down. A category includes its
+// subcategories.
+type ExportFilter struct {
+	CategoryID *uint `json:"category_id,omitempty"`
+	Archived   *bool `json:"archived,omitempty"`
+	Featured   *bool `json:"featured,omitempty"`
+}
+
+const exportBatchSize = 500
+
+// CountExport returns the number of rows an export will have
+func CountExport(db *gorm.DB, storeID uint, filter ExportFilter) (int64, error) {
+	categories, err := loadCategories(db, storeID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = exportQuery(db, storeID, filter, categories).Model(&models.ProductItem{}).Count(&count).Error
+	return count, err
+}
+
+// Export streams a store's catalog to w, one row per SKU in the columns
+// the importer reads. Categories are written as "Parent > Child" paths so
+// they resolve on import. XLSX workbooks also get sheets listing the
+// store's categories and variants for reference; only the Products sheet
+// is imported.
+func Export(ctx context.Context, db *gorm.DB, storeID uint, filter ExportFilter, format string, w io.Writer, progress func(processed int)) (int, error) {
+	categories, err := loadCategories(db, storeID)
+	if err != nil {
+		return 0, err
+	}
+
+	var out rowWriter
+	switch format {
+	case FormatCSV:
+		out = newCSVWriter(w)
+	case FormatNDJSON:
+		out = &ndjsonWriter{enc: json.NewEncoder(w)}
+	case FormatXLSX:
+		out = &xlsxWriter{w: xlsx.NewWriter(w)}
+	default:
+		return 0, fmt.Errorf("unknown format %q", format)
+	}
+	if err := out.Header(); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	var items []models.ProductItem
+	result := exportQuery(db, storeID, filter, categories).Preload("Product").
+		FindInBatches(&items, exportBatchSize, func(tx *gorm.DB, batch int) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for _, item := range items {
+				if err := out.Write(exportRow(item, categories)); err != nil {
+					return err
+				}
+			}
+			written += len(items)
+			if progress != nil {
+				progress(written)
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return written, result.Error
+	}
+
+	if x, ok := out.(*xlsxWriter); ok {
+		if err := writeReferenceSheets(db, storeID, categories, x.w); err != nil {
+			return written, err
+		}
+	}
+
+	return written, out.Close()
+}
+
+func exportQuery(db *gorm.DB, storeID uint, filter ExportFilter, categories *categoryIndex) *gorm.DB {
+	query := db.Joins("JOIN products ON products.id = product_items.product_id AND products.deleted_at IS NULL").
+		Where("products.store_id = ?", storeID)
+	if filter.CategoryID != nil {
+		query = query.Where("products.category_id IN ?", categories.withDescendants(*filter.CategoryID))
+	}
+	if filter.Archived != nil {
+		query = query.Where("products.is_archived = ?", *filter.Archived)
+	}
+	if filter.Featured != nil {
+		query = query.Where("products.is_featured = ?", *filter.Featured)
+	}
+	return query
+}
+
+// exportRow lays out a SKU in Columns order. Empty optional values are nil.
+func exportRow(item models.ProductItem, categories *categoryIndex) []any {
+	product := item.Product
+
+	var releaseDate any
+	if item.ReleaseDate != nil {
+		releaseDate = item.ReleaseDate.UTC().Format(time.RFC3339)
+	}
+
+	return []any{
+		product.Name,
+		product.Description,
+		categories.path(product.CategoryID),
+		product.IsFeatured,
+		product.IsArchived,
+		product.HasVariants,
+		item.SKU,
+		item.Price,
+		item.DiscountedPrice,
+		item.Quantity,
+		item.WeightGrams,
+		optionalInt(item.ReorderThreshold),
+		item.StockPolicy,
+		optionalInt(item.BackorderLimit),
+		releaseDate,
+	}
+}
+
+func optionalInt(v *int) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func writeReferenceSheets(db *gorm.DB, storeID uint, categories *categoryIndex, w *xlsx.Writer) error {
+	if err := w.AddSheet("Categories"); err != nil {
+		return err
+	}
+	if err := w.WriteRow("id", "name", "path", "description", "parent_id"); err != nil {
+		return err
+	}
+	for _, category := range categories.sorted() {
+		var parentID any
+		if category.ParentCategoryID != nil {
+			parentID = *category.ParentCategoryID
+		}
+		if err := w.WriteRow(category.ID, category.Name, categories.path(category.ID), category.Description, parentID); err != nil {
+			return err
+		}
+	}
+
+	var variants []models.Variant
+	if err := db.Joins("JOIN categories ON categories.id = variants.category_id AND categories.deleted_at IS NULL").
+		Where("categories.store_id = ?", storeID).Preload("Options").Order("variants.id").Find(&variants).Error; err != nil {
+		return err
+	}
+
+	if err := w.AddSheet("Variants"); err != nil {
+		return err
+	}
+	if err := w.WriteRow("category", "variant", "option", "description"); err != nil {
+		return err
+	}
+	for _, variant := range variants {
+		for _, option := range variant.Options {
+			if err := w.WriteRow(categories.path(variant.CategoryID), variant.Name, option.Value, option.Description); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// path names a category with its ancestors, e.g. "Clothing > Shirts"
+func (idx *categoryIndex) path(id uint) string {
+	var names []string
+	seen := make(map[uint]bool)
+	for category, ok := idx.byID[id]; ok && !seen[category.ID]; category, ok = idx.parent(category) {
+		seen[category.ID] = true
+		names = append([]string{category.Name}, names...)
+	}
+	return strings.Join(names, " > ")
+}
+
+func (idx *categoryIndex) parent(category models.Category) (models.Category, bool) {
+	if category.ParentCategoryID == nil {
+		return models.Category{}, false
+	}
+	parent, ok := idx.byID[*category.ParentCategoryID]
+	return parent, ok
+}
+
+// withDescendants returns a category and every category below it
+func (idx *categoryIndex) withDescendants(id uint) []uint {
+	children := make(map[uint][]uint)
+	for _, category := range idx.byID {
+		if category.ParentCategoryID != nil {
+			children[*category.ParentCategoryID] = append(children[*category.ParentCategoryID], category.ID)
+		}
+	}
+
+	ids := []uint{id}
+	seen := map[uint]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids
+}
+
+func (idx *categoryIndex) sorted() []models.Category {
+	categories := make([]models.Category, 0, len(idx.byID))
+	for _, category := range idx.byID {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].ID < categories[j].ID })
+	return categories
+}
+
+type rowWriter interface {
+	Header() error
+	Write(values []any) error
+	Close() error
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Header() error {
+	return c.w.Write(Columns)
+}
+
+func (c *csvWriter) Write(values []any) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			record[i] = v
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Header() error {
+	return nil
+}
+
+func (n *ndjsonWriter) Write(values []any) error {
+	object := make(map[string]any, len(values))
+	for i, value := range values {
+		object[Columns[i]] = value
+	}
+	return n.enc.Encode(object)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type xlsxWriter struct {
+	w *xlsx.Writer
+}
+
+func (x *xlsxWriter) Header() error {
+	if err := x.w.AddSheet("Products"); err != nil {
+		return err
+	}
+	header := make([]any, len(Columns))
+	for i, column := range Columns {
+		header[i] = column
+	}
+	return x.w.WriteRow(header...)
+}
+
+func (x *xlsxWriter) Write(values []any) error {
+	return x.w.WriteRow(values...)
+}
+
+func (x *xlsxWriter) Close() error {
+	return x.w.Close()
+}
@@ -0,0 +1,423 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blanc42/ecms/pkg/attributes"
+	"github.com/blanc42/ecms/pkg/collections"
+	"github.com/blanc42/ecms/pkg/feeds"
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/search"
+	"github.com/blanc42/ecms/pkg/slugs"
+	"gorm.io/gorm"
+)
+
+// ProductPlan is one product of an import and the SKUs it gets. Rows are
+// grouped into products by product_name; an existing product is matched
+// through its SKUs first and its name second.
+type ProductPlan struct {
+	ProductID  uint
+	CategoryID uint
+	Rows       []Row
+	ItemIDs    map[string]uint
+}
+
+// Plan is a validated import, ready to apply
+type Plan struct {
+	StoreID  uint
+	Products []ProductPlan
+}
+
+// Report summarises what an import does, or would do on a dry run
+type Report struct {
+	Rows            int        `json:"rows"`
+	Products        int        `json:"products"`
+	ProductsCreated int        `json:"products_created"`
+	ProductsUpdated int        `json:"products_updated"`
+	ItemsCreated    int        `json:"items_created"`
+	ItemsUpdated    int        `json:"items_updated"`
+	Errors          []RowError `json:"errors"`
+}
+
+// Validate checks parsed rows against the store's catalog: category names
+// must resolve, SKUs must be unique in the file and not belong to another
+// store, and the rows of a product must agree on which product they are.
+// Errors from parsing are carried into the report. The plan is only
+// usable when the report has no errors.
+func Validate(db *gorm.DB, storeID uint, rows []Row, parseErrors []RowError) (*Plan, Report, error) {
+	report := Report{Rows: len(rows), Errors: append([]RowError{}, parseErrors...)}
+	fail := func(row Row, field, message string) {
+		report.Errors = append(report.Errors, RowError{Row: row.Row, SKU: row.SKU, Field: field, Message: message})
+	}
+
+	categories, err := loadCategories(db, storeID)
+	if err != nil {
+		return nil, report, err
+	}
+
+	var skus []string
+	seen := make(map[string]int)
+	for _, row := range rows {
+		if row.SKU == "" {
+			continue
+		}
+		if first, ok := seen[row.SKU]; ok {
+			fail(row, "sku", fmt.Sprintf("is repeated from row %d", first))
+			continue
+		}
+		seen[row.SKU] = row.Row
+		skus = append(skus, row.SKU)
+	}
+
+	existing, err := loadItems(db, skus)
+	if err != nil {
+		return nil, report, err
+	}
+
+	// Group rows into products, keeping the file's order
+	var names []string
+	groups := make(map[string][]Row)
+	for _, row := range rows {
+		if row.ProductName == "" {
+			continue
+		}
+		if _, ok := groups[row.ProductName]; !ok {
+			names = append(names, row.ProductName)
+		}
+		groups[row.ProductName] = append(groups[row.ProductName], row)
+	}
+
+	byName, err := loadProductsByName(db, storeID, names)
+	if err != nil {
+		return nil, report, err
+	}
+
+	plan := &Plan{StoreID: storeID}
+	for _, name := range names {
+		group := groups[name]
+		product := ProductPlan{Rows: group, ItemIDs: make(map[string]uint)}
+
+		matched := make(map[uint]bool)
+		for _, row := range group {
+			item, ok := existing[row.SKU]
+			switch {
+			case !ok:
+			case item.StoreID != storeID:
+				fail(row, "sku", "is already used by another store")
+			case item.Deleted:
+				fail(row, "sku", "belongs to a deleted product")
+			default:
+				product.ItemIDs[row.SKU] = item.ID
+				matched[item.ProductID] = true
+			}
+		}
+
+		switch {
+		case len(matched) > 1:
+			fail(group[0], "product_name", "SKUs of this product belong to different existing products")
+			continue
+		case len(matched) == 1:
+			for id := range matched {
+				product.ProductID = id
+			}
+		case len(byName[name]) > 1:
+			fail(group[0], "product_name", "matches several products, use the SKUs of one of them")
+			continue
+		case len(byName[name]) == 1:
+			product.ProductID = byName[name][0]
+		}
+
+		for _, row := range group {
+			if row.Category == "" {
+				continue
+			}
+			id, err := categories.resolve(row.Category)
+			if err != "" {
+				fail(row, "category", err)
+				continue
+			}
+			if product.CategoryID != 0 && product.CategoryID != id {
+				fail(row, "category", "differs from earlier rows of the same product")
+				continue
+			}
+			product.CategoryID = id
+		}
+		if product.ProductID == 0 && product.CategoryID == 0 {
+			fail(group[0], "category", "is required for new products")
+		}
+
+		plan.Products = append(plan.Products, product)
+		if product.ProductID == 0 {
+			report.ProductsCreated++
+		} else {
+			report.ProductsUpdated++
+		}
+		report.ItemsUpdated += len(product.ItemIDs)
+		report.ItemsCreated += len(group) - len(product.ItemIDs)
+	}
+	report.Products = len(plan.Products)
+
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	return plan, report, nil
+}
+
+// Apply writes a validated plan, batchSize products per transaction, and
+// reports the rows done after each batch. Stock changes go through the
+// ledger, attributed to actor.
+func Apply(ctx context.Context, db *gorm.DB, plan *Plan, batchSize int, actor inventory.Movement, progress func(processed int)) error {
+	processed := 0
+	for start := 0; start < len(plan.Products); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := min(start+batchSize, len(plan.Products))
+		batch := plan.Products[start:end]
+		err := db.Transaction(func(tx *gorm.DB) error {
+			ids := make([]uint, len(batch))
+			for i := range batch {
+				if err := applyProduct(tx, plan.StoreID, &batch[i], actor); err != nil {
+					return fmt.Errorf("row %d: %w", batch[i].Rows[0].Row, err)
+				}
+				ids[i] = batch[i].ProductID
+			}
+			if err := feeds.Touch(tx, plan.StoreID); err != nil {
+				return err
+			}
+			if err := collections.RefreshProducts(tx, plan.StoreID, ids...); err != nil {
+				return err
+			}
+			return search.IndexProducts(tx, ids...)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, product := range batch {
+			processed += len(product.Rows)
+		}
+		if progress != nil {
+			progress(processed)
+		}
+	}
+	return nil
+}
+
+func applyProduct(tx *gorm.DB, storeID uint, plan *ProductPlan, actor inventory.Movement) error {
+	first := plan.Rows[0]
+
+	var product models.Product
+	if plan.ProductID != 0 {
+		if err := tx.First(&product, plan.ProductID).Error; err != nil {
+			return err
+		}
+	}
+	product.StoreID = storeID
+	product.Name = first.ProductName
+	recategorized := product.ID != 0 && plan.CategoryID != 0 && plan.CategoryID != product.CategoryID
+	if plan.CategoryID != 0 {
+		product.CategoryID = plan.CategoryID
+	}
+	if first.Present["description"] {
+		product.Description = first.Description
+	}
+	if first.Present["is_featured"] {
+		product.IsFeatured = first.IsFeatured
+	}
+	if first.Present["is_archived"] {
+		product.IsArchived = first.IsArchived
+	}
+	if first.Present["has_variants"] {
+		product.HasVariants = first.HasVariants
+	}
+	if product.Slug == "" {
+		slug, err := slugs.Assign(tx, slugs.Product, storeID, product.ID, "", "", product.Name)
+		if err != nil {
+			return err
+		}
+		product.Slug = slug
+	}
+	if err := tx.Omit("Items", "Images").Save(&product).Error; err != nil {
+		return err
+	}
+	plan.ProductID = product.ID
+
+	// Files carry no attributes, a moved product only loses the ones its
+	// new category doesn't have
+	if recategorized {
+		if _, err := attributes.Prune(tx, product); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range plan.Rows {
+		var item models.ProductItem
+		itemID, exists := plan.ItemIDs[row.SKU]
+		if exists {
+			if err := tx.First(&item, itemID).Error; err != nil {
+				return err
+			}
+		} else {
+			item.StockPolicy = models.StockPolicyDeny
+		}
+
+		item.ProductID = product.ID
+		item.SKU = row.SKU
+		item.Price = row.Price
+		if row.Present["discounted_price"] {
+			item.DiscountedPrice = row.DiscountedPrice
+		}
+		if row.Present["weight_grams"] {
+			item.WeightGrams = row.WeightGrams
+		}
+		if row.Present["reorder_threshold"] {
+			item.ReorderThreshold = row.ReorderThreshold
+		}
+		if row.Present["stock_policy"] {
+			item.StockPolicy = row.StockPolicy
+		}
+		if row.Present["backorder_limit"] {
+			item.BackorderLimit = row.BackorderLimit
+		}
+		if row.Present["release_date"] {
+			item.ReleaseDate = row.ReleaseDate
+		}
+		// The quantity of existing SKUs only changes through the ledger below
+		omit := []string{"StockLevels"}
+		if exists {
+			omit = append(omit, "quantity")
+		}
+		if err := tx.Omit(omit...).Save(&item).Error; err != nil {
+			return err
+		}
+
+		m := actor
+		m.Reason = "Catalog import"
+		switch {
+		case exists && row.Present["quantity"]:
+			if _, err := inventory.SetQuantity(tx, item.ID, row.Quantity, m); err != nil {
+				return err
+			}
+		case !exists && row.Quantity > 0:
+			m.ProductItemID = item.ID
+			m.Type = models.MovementReceipt
+			m.Quantity = row.Quantity
+			if _, err := inventory.Record(tx, m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type existingItem struct {
+	ID        uint
+	SKU       string
+	ProductID uint
+	StoreID   uint
+	Deleted   bool
+}
+
+// loadItems finds the SKUs that already exist, deleted ones included since
+// their SKUs stay taken
+func loadItems(db *gorm.DB, skus []string) (map[string]existingItem, error) {
+	items := make(map[string]existingItem, len(skus))
+	for start := 0; start < len(skus); start += 1000 {
+		end := min(start+1000, len(skus))
+
+		var rows []existingItem
+		err := db.Table("product_items").
+			Select("product_items.id, product_items.sku, product_items.product_id, products.store_id, (product_items.deleted_at IS NOT NULL OR products.deleted_at IS NOT NULL) AS deleted").
+			Joins("JOIN products ON products.id = product_items.product_id").
+			Where("product_items.sku IN ?", skus[start:end]).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			items[row.SKU] = row
+		}
+	}
+	return items, nil
+}
+
+func loadProductsByName(db *gorm.DB, storeID uint, names []string) (map[string][]uint, error) {
+	byName := make(map[string][]uint)
+	for start := 0; start < len(names); start += 1000 {
+		end := min(start+1000, len(names))
+
+		var products []models.Product
+		if err := db.Select("id", "name").Where("store_id = ? AND name IN ?", storeID, names[start:end]).Order("id").Find(&products).Error; err != nil {
+			return nil, err
+		}
+		for _, product := range products {
+			byName[product.Name] = append(byName[product.Name], product.ID)
+		}
+	}
+	return byName, nil
+}
+
+// categoryIndex resolves category names, or "Parent > Child" paths when a
+// name alone is ambiguous
+type categoryIndex struct {
+	byID   map[uint]models.Category
+	byName map[string][]models.Category
+}
+
+func loadCategories(db *gorm.DB, storeID uint) (*categoryIndex, error) {
+	var categories []models.Category
+	if err := db.Where("store_id = ?", storeID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	index := &categoryIndex{byID: make(map[uint]models.Category), byName: make(map[string][]models.Category)}
+	for _, category := range categories {
+		index.byID[category.ID] = category
+		key := strings.ToLower(category.Name)
+		index.byName[key] = append(index.byName[key], category)
+	}
+	return index, nil
+}
+
+func (idx *categoryIndex) resolve(value string) (uint, string) {
+	parts := strings.Split(value, ">")
+	for i := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(parts[i]))
+	}
+
+	var matches []models.Category
+	for _, candidate := range idx.byName[parts[len(parts)-1]] {
+		if idx.pathMatches(candidate, parts[:len(parts)-1]) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Sprintf("%q does not exist", value)
+	case 1:
+		return matches[0].ID, ""
+	}
+	return 0, fmt.Sprintf("%q matches several categories, use a path like \"Parent > Child\"", value)
+}
+
+// pathMatches checks that a category's closest ancestors are named
+// parents, innermost last
+func (idx *categoryIndex) pathMatches(category models.Category, parents []string) bool {
+	for i := len(parents) - 1; i >= 0; i-- {
+		if category.ParentCategoryID == nil {
+			return false
+		}
+		parent, ok := idx.byID[*category.ParentCategoryID]
+		if !ok || strings.ToLower(parent.Name) != parents[i] {
+			return false
+		}
+		category = parent
+	}
+	return true
+}
@@ -0,0 +1,396 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/xlsx"
+)
+
+// File formats
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatXLSX   = "xlsx"
+)
+
+// Columns of a catalog file, one row per SKU. Product columns repeat on
+// every row of the product. Imports and exports share them so an export
+// can be edited and imported back.
+var Columns = []string{
+	"product_name",
+	"description",
+	"category",
+	"is_featured",
+	"is_archived",
+	"has_variants",
+	"sku",
+	"price",
+	"discounted_price",
+	"quantity",
+	"weight_grams",
+	"reorder_threshold",
+	"stock_policy",
+	"backorder_limit",
+	"release_date",
+}
+
+var requiredColumns = []string{"product_name", "sku", "price"}
+
+// RowError is a problem with one row of a file. Row counts data rows from
+// 1, whatever the format.
+type RowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("row %d: %s: %s", e.Row, e.Field, e.Message)
+	}
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// Row is one SKU of a catalog file. Present holds the columns the row
+// carries a value for, so updates leave the other fields of existing
+// records alone. A blank cell or null counts as no value: it would
+// otherwise zero stock or prices an export left empty.
+type Row struct {
+	Row              int
+	Present          map[string]bool
+	ProductName      string
+	Description      string
+	Category         string
+	IsFeatured       bool
+	IsArchived       bool
+	HasVariants      bool
+	SKU              string
+	Price            float64
+	DiscountedPrice  float64
+	Quantity         int
+	WeightGrams      int
+	ReorderThreshold *int
+	StockPolicy      string
+	BackorderLimit   *int
+	ReleaseDate      *time.Time
+}
+
+// Parse reads a catalog file into rows. Field-level problems are returned
+// as row errors so the whole file can be reported on at once; the error is
+// only set when the file can't be read at all.
+func Parse(r io.Reader, format string) ([]Row, []RowError, error) {
+	var records []map[string]string
+	var err error
+	switch format {
+	case FormatCSV:
+		records, err = readCSV(r)
+	case FormatJSON:
+		records, err = readJSON(r)
+	case FormatNDJSON:
+		records, err = readNDJSON(r)
+	case FormatXLSX:
+		records, err = readXLSX(r)
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []Row
+	var rowErrors []RowError
+	for i, record := range records {
+		row, errs := parseRow(i+1, record)
+		rows = append(rows, row)
+		rowErrors = append(rowErrors, errs...)
+	}
+	return rows, rowErrors, nil
+}
+
+// FormatFromName guesses a file's format from its extension
+func FormatFromName(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".json":
+		return FormatJSON
+	case ".xlsx":
+		return FormatXLSX
+	}
+	return ""
+}
+
+func readCSV(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := readHeader(header); err != nil {
+		return nil, err
+	}
+
+	var records []map[string]string
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, tableRecord(header, fields))
+	}
+}
+
+// readXLSX reads the Products sheet an export writes, or the first sheet of
+// a workbook made elsewhere
+func readXLSX(r io.Reader) ([]map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	workbook, err := xlsx.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	sheet := workbook.Sheets()[0]
+	if slices.Contains(workbook.Sheets(), "Products") {
+		sheet = "Products"
+	}
+	table, err := workbook.ReadSheet(sheet)
+	if err != nil {
+		return nil, err
+	}
+	if len(table) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	header := table[0]
+	for len(header) > 0 && strings.TrimSpace(header[len(header)-1]) == "" {
+		header = header[:len(header)-1]
+	}
+	if err := readHeader(header); err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, 0, len(table)-1)
+	for _, fields := range table[1:] {
+		records = append(records, tableRecord(header, fields))
+	}
+	return records, nil
+}
+
+// readHeader normalizes a header row in place and checks its columns
+func readHeader(header []string) error {
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+	return checkColumns(header)
+}
+
+func tableRecord(header, fields []string) map[string]string {
+	record := make(map[string]string, len(header))
+	for i, column := range header {
+		if i < len(fields) {
+			record[column] = strings.TrimSpace(fields[i])
+		}
+	}
+	return record
+}
+
+func readJSON(r io.Reader) ([]map[string]string, error) {
+	var objects []map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&objects); err != nil {
+		return nil, fmt.Errorf("file must be a JSON array of objects: %w", err)
+	}
+	return jsonRecords(objects)
+}
+
+func readNDJSON(r io.Reader) ([]map[string]string, error) {
+	var objects []map[string]json.RawMessage
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(text, &object); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		objects = append(objects, object)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return jsonRecords(objects)
+}
+
+// jsonRecords flattens JSON values to the strings a CSV cell would hold
+func jsonRecords(objects []map[string]json.RawMessage) ([]map[string]string, error) {
+	records := make([]map[string]string, 0, len(objects))
+	for i, object := range objects {
+		var keys []string
+		for key := range object {
+			keys = append(keys, key)
+		}
+		if err := checkColumns(keys); err != nil {
+			return nil, fmt.Errorf("object %d: %w", i+1, err)
+		}
+
+		record := make(map[string]string, len(object))
+		for key, raw := range object {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				record[key] = strings.TrimSpace(s)
+			} else if string(raw) == "null" {
+				record[key] = ""
+			} else {
+				record[key] = string(raw)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// checkColumns rejects unknown columns, which are usually typos that would
+// otherwise be silently ignored
+func checkColumns(columns []string) error {
+	known := make(map[string]bool, len(Columns))
+	for _, column := range Columns {
+		known[column] = true
+	}
+	for _, column := range columns {
+		if !known[column] {
+			return fmt.Errorf("unknown column %q", column)
+		}
+	}
+	return nil
+}
+
+func parseRow(n int, record map[string]string) (Row, []RowError) {
+	row := Row{Row: n, Present: make(map[string]bool, len(record))}
+	for column, value := range record {
+		row.Present[column] = value != ""
+	}
+
+	var errs []RowError
+	fail := func(field, message string) {
+		errs = append(errs, RowError{Row: n, SKU: record["sku"], Field: field, Message: message})
+	}
+
+	for _, column := range requiredColumns {
+		if record[column] == "" {
+			fail(column, "is required")
+		}
+	}
+
+	row.ProductName = record["product_name"]
+	row.Description = record["description"]
+	row.Category = record["category"]
+	row.SKU = record["sku"]
+	row.StockPolicy = record["stock_policy"]
+
+	boolField := func(column string, dst *bool) {
+		if v := record[column]; v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				fail(column, "must be true or false")
+			}
+			*dst = b
+		}
+	}
+	intField := func(column string, dst *int) bool {
+		if v := record[column]; v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 {
+				fail(column, "must be a whole number of at least 0")
+				return false
+			}
+			*dst = i
+			return true
+		}
+		return false
+	}
+	optionalIntField := func(column string) *int {
+		var i int
+		if intField(column, &i) {
+			return &i
+		}
+		return nil
+	}
+
+	boolField("is_featured", &row.IsFeatured)
+	boolField("is_archived", &row.IsArchived)
+	boolField("has_variants", &row.HasVariants)
+	intField("quantity", &row.Quantity)
+	intField("weight_grams", &row.WeightGrams)
+	row.ReorderThreshold = optionalIntField("reorder_threshold")
+	row.BackorderLimit = optionalIntField("backorder_limit")
+
+	if v := record["price"]; v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil || price <= 0 {
+			fail("price", "must be a number greater than 0")
+		}
+		row.Price = price
+	}
+	if v := record["discounted_price"]; v != "" {
+		price, err := strconv.ParseFloat(v, 64)
+		if err != nil || price < 0 {
+			fail("discounted_price", "must be a number of at least 0")
+		}
+		row.DiscountedPrice = price
+	}
+
+	switch row.StockPolicy {
+	case "", models.StockPolicyDeny, models.StockPolicyBackorder, models.StockPolicyPreorder:
+	default:
+		fail("stock_policy", "must be deny, backorder or preorder")
+	}
+
+	if v := record["release_date"]; v != "" {
+		date, err := parseDate(v)
+		if err != nil {
+			fail("release_date", "must be a date like 2006-01-02 or an RFC 3339 time")
+		} else {
+			row.ReleaseDate = &date
+		}
+	}
+	if row.StockPolicy == models.StockPolicyPreorder && row.ReleaseDate == nil {
+		fail("release_date", "is required for pre-orders")
+	}
+
+	return row, errs
+}
+
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
@@ -0,0 +1,378 @@
+// Package categories keeps a store's category tree consistent: parents
+// stay within the store, nothing is moved under itself, siblings keep a
+// display order and deleting a category decides what happens to what
+// hangs below it.
+//
+// Every category stores its materialized path, the IDs from the root down
+// to itself like "/1/5/12/", and its depth. A subtree is then a prefix
+// match on the path and the ancestors are in the path itself.
+package categories
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/search"
+	"github.com/blanc42/ecms/pkg/slugs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrParentNotFound = errors.New("parent category not found")
+	ErrOtherStore     = errors.New("parent category belongs to another store")
+	ErrCycle          = errors.New("a category cannot be placed under itself or its subcategories")
+	ErrNotEmpty       = errors.New("category has subcategories or products")
+	ErrNoGrandparent  = errors.New("a top-level category has no parent to move its products to")
+	ErrParentLoop     = errors.New("parent category is caught in a loop of parents, move it out of the loop first")
+)
+
+// What deleting a category does with its subcategories and products
+const (
+	// DeleteRefuse only deletes empty categories
+	DeleteRefuse = "refuse"
+	// DeleteCascade deletes the whole subtree and its products
+	DeleteCascade = "cascade"
+	// DeleteReparent hands subcategories and products to the parent
+	DeleteReparent = "reparent"
+)
+
+// EnsureSchema creates the index behind prefix matches on paths and fills
+// in the paths of categories that have none yet
+func EnsureSchema(db *gorm.DB) error {
+	if err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_categories_path ON categories (path text_pattern_ops)`).Error; err != nil {
+		return err
+	}
+	return fillPaths(db)
+}
+
+// Unpathed returns the categories EnsureSchema couldn't give a path: their
+// parents loop back on themselves, so no root leads to them. Moving one to
+// the top level, or under a category outside the loop, repairs the loop.
+func Unpathed(db *gorm.DB) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.Category{}).Where("path = ''").Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// fillPaths walks down from the roots to every category without a path
+func fillPaths(db *gorm.DB) error {
+	return db.Exec(`
+		WITH RECURSIVE tree AS (
+			SELECT id, '/' || id || '/' AS path, 0 AS depth, ARRAY[id] AS seen
+			FROM categories
+			WHERE parent_category_id IS NULL
+
+			UNION ALL
+
+			SELECT c.id, t.path || c.id || '/', t.depth + 1, t.seen || c.id
+			FROM categories c
+			JOIN tree t ON c.parent_category_id = t.id
+			WHERE NOT c.id = ANY(t.seen)
+		)
+		UPDATE categories SET path = tree.path, depth = tree.depth
+		FROM tree
+		WHERE categories.id = tree.id AND categories.path = ''
+	`).Error
+}
+
+// CheckParent makes sure parentID can be the parent of category: it has to
+// exist in the same store and must not be the category or one of its
+// descendants. It returns the parent, nil for the top level.
+func CheckParent(tx *gorm.DB, category models.Category, parentID *uint) (*models.Category, error) {
+	if parentID == nil {
+		return nil, nil
+	}
+
+	var parent models.Category
+	if err := tx.First(&parent, *parentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrParentNotFound
+		}
+		return nil, err
+	}
+	if parent.StoreID != category.StoreID {
+		return nil, ErrOtherStore
+	}
+	if parent.Path == "" {
+		return nil, ErrParentLoop
+	}
+	if category.ID != 0 && (parent.ID == category.ID || category.Path != "" && strings.HasPrefix(parent.Path, category.Path)) {
+		return nil, ErrCycle
+	}
+	return &parent, nil
+}
+
+// Create adds a category after its siblings
+func Create(tx *gorm.DB, category *models.Category) error {
+	parent, err := CheckParent(tx, *category, category.ParentCategoryID)
+	if err != nil {
+		return err
+	}
+	if category.Position, err = NextPosition(tx, category.StoreID, category.ParentCategoryID); err != nil {
+		return err
+	}
+	if err := tx.Create(category).Error; err != nil {
+		return err
+	}
+
+	// The path ends in the category's own ID, known only now
+	category.Path, category.Depth = pathUnder(parent, category.ID)
+	return tx.Model(category).Updates(map[string]any{"path": category.Path, "depth": category.Depth}).Error
+}
+
+// PathIDs parses a path into the IDs it is made of, root first
+func PathIDs(path string) []uint {
+	var ids []uint
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// Ancestors loads the categories above category, root first
+func Ancestors(tx *gorm.DB, category models.Category) ([]models.Category, error) {
+	ancestors := []models.Category{}
+	ids := PathIDs(category.Path)
+	if len(ids) < 2 {
+		return ancestors, nil
+	}
+	err := tx.Where("id IN ?", ids[:len(ids)-1]).Order("depth").Find(&ancestors).Error
+	return ancestors, err
+}
+
+// Subtree selects a category and everything below it. A category without
+// a path, see Unpathed, selects only itself.
+func Subtree(tx *gorm.DB, category models.Category) *gorm.DB {
+	if category.Path == "" {
+		return tx.Where("categories.id = ?", category.ID)
+	}
+	return tx.Where("categories.store_id = ? AND categories.path LIKE ?", category.StoreID, category.Path+"%")
+}
+
+// SubtreeJoin pairs every category, aliased ancestor, with itself and each
+// live category below it, aliased category, in SQL. Like Subtree, a
+// category without a path pairs only with itself.
+const SubtreeJoin = `categories ancestor JOIN categories category ON category.store_id = ancestor.store_id AND category.deleted_at IS NULL
+	AND (category.id = ancestor.id OR ancestor.path <> '' AND category.path LIKE ancestor.path || '%')`
+
+// Descendants returns the IDs of a category and everything below it
+func Descendants(tx *gorm.DB, category models.Category) ([]uint, error) {
+	var ids []uint
+	err := Subtree(tx.Model(&models.Category{}), category).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// ProductCounts counts the products of a store's categories: direct holds
+// those in the category itself, total adds those of all its subcategories
+func ProductCounts(tx *gorm.DB, storeID uint) (direct, total map[uint]int64, err error) {
+	type count struct {
+		CategoryID uint
+		Count      int64
+	}
+
+	var directCounts []count
+	err = tx.Model(&models.Product{}).Select("category_id, count(*) AS count").
+		Where("store_id = ?", storeID).Group("category_id").Scan(&directCounts).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var totalCounts []count
+	err = tx.Raw(`
+		SELECT ancestor.id AS category_id, count(*) AS count
+		FROM `+SubtreeJoin+`
+		JOIN products ON products.category_id = category.id AND products.deleted_at IS NULL
+		WHERE ancestor.store_id = ? AND ancestor.deleted_at IS NULL
+		GROUP BY ancestor.id
+	`, storeID).Scan(&totalCounts).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	direct, total = make(map[uint]int64), make(map[uint]int64)
+	for _, c := range directCounts {
+		direct[c.CategoryID] = c.Count
+	}
+	for _, c := range totalCounts {
+		total[c.CategoryID] = c.Count
+	}
+	return direct, total, nil
+}
+
+func pathUnder(parent *models.Category, id uint) (string, int) {
+	if parent == nil {
+		return fmt.Sprintf("/%d/", id), 0
+	}
+	return fmt.Sprintf("%s%d/", parent.Path, id), parent.Depth + 1
+}
+
+// NextPosition is the position after the last child of parentID
+func NextPosition(tx *gorm.DB, storeID uint, parentID *uint) (int, error) {
+	var position int
+	err := siblings(tx, storeID, parentID).Model(&models.Category{}).
+		Select("coalesce(max(position) + 1, 0)").Row().Scan(&position)
+	return position, err
+}
+
+// Move places a category under parentID, nil for the top level, at
+// position among its new siblings. Without a position it goes last.
+// Siblings are renumbered so positions stay 0, 1, 2...
+func Move(tx *gorm.DB, category *models.Category, parentID *uint, position *int) error {
+	parent, err := CheckParent(tx, *category, parentID)
+	if err != nil {
+		return err
+	}
+
+	var others []models.Category
+	err = siblings(tx, category.StoreID, parentID).Where("id <> ?", category.ID).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Order("position, id").Find(&others).Error
+	if err != nil {
+		return err
+	}
+
+	at := len(others)
+	if position != nil {
+		at = min(max(*position, 0), len(others))
+	}
+
+	category.ParentCategoryID = parentID
+	ordered := append(others[:at:at], append([]models.Category{*category}, others[at:]...)...)
+	for i, sibling := range ordered {
+		if sibling.ID == category.ID {
+			category.Position = i
+			if err := tx.Model(&models.Category{}).Where("id = ?", category.ID).
+				Updates(map[string]any{"parent_category_id": parentID, "position": i}).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if sibling.Position != i {
+			if err := tx.Model(&models.Category{}).Where("id = ?", sibling.ID).Update("position", i).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	// Moving a category moves its whole subtree
+	path, depth := pathUnder(parent, category.ID)
+	if category.Path == "" {
+		// The category was caught in a loop of parents, now broken, so its
+		// subtree can be reached from a root again
+		if err := fillPaths(tx); err != nil {
+			return err
+		}
+		category.Path, category.Depth = path, depth
+		return nil
+	}
+	if path != category.Path {
+		err := tx.Exec(`UPDATE categories SET path = ? || substr(path, ?), depth = depth + ? WHERE store_id = ? AND path LIKE ?`,
+			path, len(category.Path)+1, depth-category.Depth, category.StoreID, category.Path+"%").Error
+		if err != nil {
+			return err
+		}
+		category.Path, category.Depth = path, depth
+	}
+	return nil
+}
+
+// Delete deletes a category the way mode says. The category's variants go
+// with it in every mode. Collections match on categories, so taking
+// cascaded products out of them is up to the caller.
+func Delete(tx *gorm.DB, category models.Category, mode string) error {
+	switch mode {
+	case DeleteCascade:
+		ids, err := Descendants(tx, category)
+		if err != nil {
+			return err
+		}
+		products := tx.Model(&models.Product{}).Select("id").Where("category_id IN ?", ids)
+		if err := tx.Where("product_id IN (?)", products).Delete(&models.ProductItem{}).Error; err != nil {
+			return err
+		}
+		if err := slugs.DeleteRedirects(tx, slugs.Product, products); err != nil {
+			return err
+		}
+		if err := tx.Where("category_id IN ?", ids).Delete(&models.Product{}).Error; err != nil {
+			return err
+		}
+		return deleteCategories(tx, ids)
+
+	case DeleteReparent:
+		var products int64
+		if err := tx.Model(&models.Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+			return err
+		}
+		if products > 0 {
+			if category.ParentCategoryID == nil {
+				return ErrNoGrandparent
+			}
+			if err := tx.Model(&models.Product{}).Where("category_id = ?", category.ID).
+				Update("category_id", *category.ParentCategoryID).Error; err != nil {
+				return err
+			}
+			// The products now match on their new category's name
+			if err := search.IndexCategory(tx, *category.ParentCategoryID); err != nil {
+				return err
+			}
+		}
+
+		var children []models.Category
+		if err := tx.Where("parent_category_id = ?", category.ID).Order("position, id").Find(&children).Error; err != nil {
+			return err
+		}
+		for i := range children {
+			if err := Move(tx, &children[i], category.ParentCategoryID, nil); err != nil {
+				return err
+			}
+		}
+		return deleteCategories(tx, []uint{category.ID})
+
+	default:
+		var children, products int64
+		if err := tx.Model(&models.Category{}).Where("parent_category_id = ?", category.ID).Count(&children).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Product{}).Where("category_id = ?", category.ID).Count(&products).Error; err != nil {
+			return err
+		}
+		if children > 0 || products > 0 {
+			return ErrNotEmpty
+		}
+		return deleteCategories(tx, []uint{category.ID})
+	}
+}
+
+func deleteCategories(tx *gorm.DB, ids []uint) error {
+	if err := deleteVariantOverrides(tx, ids); err != nil {
+		return err
+	}
+	if err := slugs.DeleteRedirects(tx, slugs.Category, ids); err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM category_attribute_sets WHERE category_id IN ?", ids).Error; err != nil {
+		return err
+	}
+	variants := tx.Model(&models.Variant{}).Select("id").Where("category_id IN ?", ids)
+	if err := tx.Where("variant_id IN (?)", variants).Delete(&models.VariantOption{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("category_id IN ?", ids).Delete(&models.Variant{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", ids).Delete(&models.Category{}).Error
+}
+
+func siblings(tx *gorm.DB, storeID uint, parentID *uint) *gorm.DB {
+	query := tx.Where("store_id = ?", storeID)
+	if parentID == nil {
+		return query.Where("parent_category_id IS NULL")
+	}
+	return query.Where("parent_category_id = ?", *parentID)
+}
@@ -0,0 +1,180 @@
+package categories
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotInherited  = errors.New("variant is not inherited from a parent category")
+	ErrUnknownOption = errors.New("option does not belong to the variant")
+)
+
+// ResolvedVariant is a variant as a category sees it, with the options
+// left after overrides
+type ResolvedVariant struct {
+	models.Variant
+	// InheritedFrom is the ancestor defining the variant, unset for the
+	// category's own variants
+	InheritedFrom *uint `json:"inherited_from,omitempty"`
+}
+
+// ResolveVariants works out the variants products in category can use:
+// its own and those of every ancestor. Options a category added to an
+// inherited variant apply to its subtree, and the override nearest to the
+// category decides which inherited variants and options are hidden.
+func ResolveVariants(tx *gorm.DB, category models.Category) ([]ResolvedVariant, error) {
+	resolved := []ResolvedVariant{}
+	path := PathIDs(category.Path)
+	if len(path) == 0 {
+		path = []uint{category.ID}
+	}
+
+	var variants []models.Variant
+	if err := tx.Where("category_id IN ?", path).Order("weight, id").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	if len(variants) == 0 {
+		return resolved, nil
+	}
+	variantIDs := make([]uint, len(variants))
+	for i, variant := range variants {
+		variantIDs[i] = variant.ID
+	}
+
+	var options []models.VariantOption
+	err := tx.Where("variant_id IN ? AND (category_id IS NULL OR category_id IN ?)", variantIDs, path).
+		Order("weight, id").Find(&options).Error
+	if err != nil {
+		return nil, err
+	}
+	var overrides []models.VariantOverride
+	if err := tx.Where("variant_id IN ? AND category_id IN ?", variantIDs, path).Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+
+	// Positions on the path tell what lies below a variant's category;
+	// overrides and options left behind by a move don't apply
+	depth := make(map[uint]int, len(path))
+	for i, id := range path {
+		depth[id] = i
+	}
+	nearest := make(map[uint]models.VariantOverride)
+	for _, override := range overrides {
+		current, ok := nearest[override.VariantID]
+		if !ok || depth[override.CategoryID] > depth[current.CategoryID] {
+			nearest[override.VariantID] = override
+		}
+	}
+
+	for _, variant := range variants {
+		defined := depth[variant.CategoryID]
+		override, overridden := nearest[variant.ID]
+		overridden = overridden && depth[override.CategoryID] > defined
+		if overridden && override.Hidden {
+			continue
+		}
+
+		variant.Options = []models.VariantOption{}
+		for _, option := range options {
+			if option.VariantID != variant.ID {
+				continue
+			}
+			if option.CategoryID != nil && depth[*option.CategoryID] <= defined {
+				continue
+			}
+			if overridden && slices.Contains(override.HiddenOptionIDs, option.ID) {
+				continue
+			}
+			variant.Options = append(variant.Options, option)
+		}
+
+		rv := ResolvedVariant{Variant: variant}
+		if variant.CategoryID != category.ID {
+			from := variant.CategoryID
+			rv.InheritedFrom = &from
+		}
+		resolved = append(resolved, rv)
+	}
+	return resolved, nil
+}
+
+// inherited makes sure category inherits variant from an ancestor
+func inherited(category models.Category, variant models.Variant) error {
+	path := PathIDs(category.Path)
+	if len(path) < 2 || !slices.Contains(path[:len(path)-1], variant.CategoryID) {
+		return ErrNotInherited
+	}
+	return nil
+}
+
+// SetOverride hides an inherited variant or some of its options for
+// category and its subtree, replacing an earlier override
+func SetOverride(tx *gorm.DB, category models.Category, variant models.Variant, hidden bool, hiddenOptionIDs []uint) (*models.VariantOverride, error) {
+	if err := inherited(category, variant); err != nil {
+		return nil, err
+	}
+
+	if hiddenOptionIDs == nil {
+		hiddenOptionIDs = []uint{}
+	}
+	if len(hiddenOptionIDs) > 0 {
+		var known int64
+		err := tx.Model(&models.VariantOption{}).
+			Where("id IN ? AND variant_id = ? AND (category_id IS NULL OR category_id IN ?)", hiddenOptionIDs, variant.ID, PathIDs(category.Path)).
+			Distinct("id").Count(&known).Error
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(hiddenOptionIDs)
+		if int(known) != len(slices.Compact(hiddenOptionIDs)) {
+			return nil, ErrUnknownOption
+		}
+	}
+
+	override := models.VariantOverride{CategoryID: category.ID, VariantID: variant.ID, Hidden: hidden, HiddenOptionIDs: hiddenOptionIDs}
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "category_id"}, {Name: "variant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"hidden", "hidden_option_ids", "updated_at", "deleted_at"}),
+	}).Create(&override).Error
+	if err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+// RemoveOverride lets category see an inherited variant the way its
+// parent does again
+func RemoveOverride(tx *gorm.DB, category models.Category, variant models.Variant) error {
+	return tx.Unscoped().Where("category_id = ? AND variant_id = ?", category.ID, variant.ID).
+		Delete(&models.VariantOverride{}).Error
+}
+
+// AddOption adds an option to a variant for category's subtree. Options
+// added to a category's own variant are the variant's for everyone.
+func AddOption(tx *gorm.DB, category models.Category, variant models.Variant, option *models.VariantOption) error {
+	option.VariantID = variant.ID
+	option.CategoryID = nil
+	if variant.CategoryID != category.ID {
+		if err := inherited(category, variant); err != nil {
+			return err
+		}
+		option.CategoryID = &category.ID
+	}
+	return tx.Create(option).Error
+}
+
+// deleteVariantOverrides removes what categories changed about variants,
+// and what was changed about variants of those categories
+func deleteVariantOverrides(tx *gorm.DB, ids []uint) error {
+	if err := tx.Where("category_id IN ?", ids).Delete(&models.VariantOption{}).Error; err != nil {
+		return err
+	}
+	variants := tx.Model(&models.Variant{}).Select("id").Where("category_id IN ?", ids)
+	return tx.Unscoped().Where("category_id IN ? OR variant_id IN (?)", ids, variants).
+		Delete(&models.VariantOverride{}).Error
+}
@@ -0,0 +1,314 @@
+// Package collections keeps the products of a store's collections. Manual
+// collections hold what an admin put in them, in that order. Automatic
+// collections are filled from their rules: the product is in a category
+// or below it, sells for less than a price, has a tag or was created in
+// the last days.
+//
+// Membership of automatic collections is stored like that of manual ones.
+// Whatever changes products re-evaluates them with RefreshProducts, a
+// changed category tree or collection with Refresh, and rules relative to
+// now are kept current by SweepRules.
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/categories"
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Rule types
+const (
+	RuleCategory      = "category_in"
+	RulePriceBelow    = "price_below"
+	RuleTag           = "tag_equals"
+	RuleCreatedWithin = "created_within_days"
+)
+
+// MaxRules caps the rules of a collection
+const MaxRules = 20
+
+// itemPrice is what a product's SKU sells for
+const itemPrice = "CASE WHEN product_items.discounted_price > 0 THEN product_items.discounted_price ELSE product_items.price END"
+
+// Error is a collection that doesn't make sense
+type Error struct {
+	// Rule is the position of the offending rule, -1 for the collection
+	Rule    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Rule < 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("rule %d: %s", e.Rule, e.Message)
+}
+
+// Check validates a collection and tidies its rules: tags are normalized
+// and category IDs deduplicated. Categories must be of the collection's
+// store.
+func Check(tx *gorm.DB, collection *models.Collection) error {
+	switch collection.Type {
+	case models.CollectionManual:
+		if len(collection.Rules) > 0 || collection.MatchAny {
+			return &Error{-1, "manual collections have no rules"}
+		}
+		return nil
+	case models.CollectionAutomatic:
+		if len(collection.Rules) == 0 {
+			return &Error{-1, "automatic collections need at least one rule"}
+		}
+		if len(collection.Rules) > MaxRules {
+			return &Error{-1, fmt.Sprintf("a collection has at most %d rules", MaxRules)}
+		}
+	default:
+		return &Error{-1, "type must be manual or automatic"}
+	}
+
+	for i := range collection.Rules {
+		rule := &collection.Rules[i]
+		switch rule.Type {
+		case RuleCategory:
+			if len(rule.CategoryIDs) == 0 {
+				return &Error{i, "category_ids is required"}
+			}
+			slices.Sort(rule.CategoryIDs)
+			rule.CategoryIDs = slices.Compact(rule.CategoryIDs)
+			var count int64
+			err := tx.Model(&models.Category{}).Where("store_id = ? AND id IN ?", collection.StoreID, rule.CategoryIDs).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if int(count) != len(rule.CategoryIDs) {
+				return &Error{i, "category_ids must be categories of the store"}
+			}
+		case RulePriceBelow:
+			if rule.Price <= 0 {
+				return &Error{i, "price must be greater than 0"}
+			}
+		case RuleTag:
+			tags := Tags([]string{rule.Tag})
+			if len(tags) == 0 {
+				return &Error{i, "tag is required"}
+			}
+			rule.Tag = tags[0]
+		case RuleCreatedWithin:
+			if rule.Days <= 0 {
+				return &Error{i, "days must be greater than 0"}
+			}
+		default:
+			return &Error{i, "type must be one of category_in, price_below, tag_equals or created_within_days"}
+		}
+	}
+	return nil
+}
+
+// Tags normalizes product tags: trimmed, lowercase, without blanks and
+// duplicates, in the order given
+func Tags(tags []string) []string {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// matching selects the IDs of the store's products an automatic
+// collection's rules match
+func matching(tx *gorm.DB, collection models.Collection) *gorm.DB {
+	conditions := make([]string, 0, len(collection.Rules))
+	var vars []any
+	for _, rule := range collection.Rules {
+		switch rule.Type {
+		case RuleCategory:
+			conditions = append(conditions, "products.category_id IN (SELECT category.id FROM "+categories.SubtreeJoin+" WHERE ancestor.id IN ?)")
+			vars = append(vars, rule.CategoryIDs)
+		case RulePriceBelow:
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM product_items WHERE product_items.product_id = products.id AND product_items.deleted_at IS NULL AND "+itemPrice+" < ?)")
+			vars = append(vars, rule.Price)
+		case RuleTag:
+			tag, _ := json.Marshal([]string{rule.Tag})
+			conditions = append(conditions, "products.tags @> ?::jsonb")
+			vars = append(vars, string(tag))
+		case RuleCreatedWithin:
+			conditions = append(conditions, "products.created_at >= ?")
+			vars = append(vars, time.Now().AddDate(0, 0, -rule.Days))
+		}
+	}
+
+	join := " AND "
+	if collection.MatchAny {
+		join = " OR "
+	}
+	query := tx.Model(&models.Product{}).Select("products.id").Where("products.store_id = ?", collection.StoreID)
+	if len(conditions) == 0 {
+		return query.Where("FALSE")
+	}
+	return query.Where("("+strings.Join(conditions, join)+")", vars...)
+}
+
+// refresh brings an automatic collection's membership in line with its
+// rules, only for productIDs when given
+func refresh(tx *gorm.DB, collection models.Collection, productIDs []uint) error {
+	matches := func() *gorm.DB {
+		query := matching(tx, collection)
+		if productIDs != nil {
+			query = query.Where("products.id IN ?", productIDs)
+		}
+		return query
+	}
+
+	stale := tx.Unscoped().Where("collection_id = ? AND product_id NOT IN (?)", collection.ID, matches())
+	if productIDs != nil {
+		stale = stale.Where("product_id IN ?", productIDs)
+	}
+	if err := stale.Delete(&models.CollectionProduct{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Exec(`INSERT INTO collection_products (created_at, updated_at, collection_id, product_id, position)
+		SELECT NOW(), NOW(), ?, id, 0 FROM (?) AS matched
+		ON CONFLICT (collection_id, product_id) DO NOTHING`, collection.ID, matches()).Error
+}
+
+// Refresh re-evaluates an automatic collection. Manual ones are left as
+// they are.
+func Refresh(tx *gorm.DB, collection models.Collection) error {
+	if collection.Type != models.CollectionAutomatic {
+		return nil
+	}
+	return refresh(tx, collection, nil)
+}
+
+// RefreshStore re-evaluates every automatic collection of a store, e.g.
+// after the category tree changed
+func RefreshStore(tx *gorm.DB, storeID uint) error {
+	var automatic []models.Collection
+	if err := tx.Where("store_id = ? AND type = ?", storeID, models.CollectionAutomatic).Find(&automatic).Error; err != nil {
+		return err
+	}
+	for _, collection := range automatic {
+		if err := refresh(tx, collection, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefreshProducts re-evaluates which automatic collections of a store
+// created or changed products belong to
+func RefreshProducts(tx *gorm.DB, storeID uint, productIDs ...uint) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	var automatic []models.Collection
+	if err := tx.Where("store_id = ? AND type = ?", storeID, models.CollectionAutomatic).Find(&automatic).Error; err != nil {
+		return err
+	}
+	for _, collection := range automatic {
+		if err := refresh(tx, collection, productIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveProducts takes deleted products out of every collection. ids is a
+// list of IDs or a query selecting them.
+func RemoveProducts(tx *gorm.DB, ids any) error {
+	return tx.Unscoped().Where("product_id IN (?)", ids).Delete(&models.CollectionProduct{}).Error
+}
+
+// SetProducts replaces the products of a manual collection, in the order
+// given. Products must be of the collection's store.
+func SetProducts(tx *gorm.DB, collection models.Collection, productIDs []uint) error {
+	if collection.Type != models.CollectionManual {
+		return &Error{-1, "products of automatic collections come from their rules"}
+	}
+	seen := make(map[uint]bool, len(productIDs))
+	for _, id := range productIDs {
+		if seen[id] {
+			return &Error{-1, "product_ids must not repeat a product"}
+		}
+		seen[id] = true
+	}
+	if len(productIDs) > 0 {
+		var count int64
+		if err := tx.Model(&models.Product{}).Where("store_id = ? AND id IN ?", collection.StoreID, productIDs).Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count) != len(productIDs) {
+			return &Error{-1, "product_ids must be products of the store"}
+		}
+	}
+
+	if err := tx.Unscoped().Where("collection_id = ?", collection.ID).Delete(&models.CollectionProduct{}).Error; err != nil {
+		return err
+	}
+	if len(productIDs) == 0 {
+		return nil
+	}
+	members := make([]models.CollectionProduct, len(productIDs))
+	for position, id := range productIDs {
+		members[position] = models.CollectionProduct{CollectionID: collection.ID, ProductID: id, Position: position}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+// Delete deletes a collection and its memberships
+func Delete(tx *gorm.DB, collection models.Collection) error {
+	if err := tx.Unscoped().Where("collection_id = ?", collection.ID).Delete(&models.CollectionProduct{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&collection).Error
+}
+
+// RefreshTimed re-evaluates the automatic collections with rules relative
+// to now, products age out of "created in the last days" without changing
+func RefreshTimed(db *gorm.DB) (int, error) {
+	rule, _ := json.Marshal([]models.CollectionRule{{Type: RuleCreatedWithin}})
+	var timed []models.Collection
+	err := db.Where("type = ? AND rules @> ?::jsonb", models.CollectionAutomatic, string(rule)).Find(&timed).Error
+	if err != nil {
+		return 0, err
+	}
+
+	refreshed := 0
+	for _, collection := range timed {
+		if err := db.Transaction(func(tx *gorm.DB) error { return refresh(tx, collection, nil) }); err != nil {
+			log.Printf("collections: failed to refresh collection %d: %v", collection.ID, err)
+			continue
+		}
+		refreshed++
+	}
+	return refreshed, nil
+}
+
+// SweepRules runs RefreshTimed every interval until ctx is done
+func SweepRules(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := RefreshTimed(db); err != nil {
+				log.Printf("collections: failed to look for timed collections: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package feeds builds the documents search engines and shopping ads read
+// from a store: its XML sitemap and its Google Merchant Center product
+// feed. Feeds are generated into blob storage and served from there.
+//
+// Catalog changes, and stock running out or coming back, only mark a
+// store's feeds stale. A sweep regenerates stale feeds once the catalog
+// has been quiet for a while, and refreshes feeds that got old so stock
+// availability doesn't drift. Serving a feed never generates one.
+package feeds
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Names feeds are served under
+const (
+	Sitemap  = "sitemap.xml"
+	Merchant = "merchant.xml"
+)
+
+var (
+	ErrNoStorefrontURL = errors.New("the store needs a storefront_url before feeds can be generated")
+	ErrNotFound        = errors.New("feed not found")
+	ErrNotGenerated    = errors.New("feeds have not been generated yet")
+)
+
+// file is a generated document before it is stored
+type file struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// Touch marks a store's feeds stale. Call it in the transaction changing
+// the catalog.
+func Touch(tx *gorm.DB, storeID uint) error {
+	state := models.FeedState{StoreID: storeID, ChangedAt: time.Now()}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "store_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"changed_at", "updated_at"}),
+	}).Create(&state).Error
+}
+
+// TouchProduct marks the feeds of a product's store stale
+func TouchProduct(tx *gorm.DB, productID uint) error {
+	var storeID uint
+	if err := tx.Model(&models.Product{}).Where("id = ?", productID).Select("store_id").Scan(&storeID).Error; err != nil {
+		return err
+	}
+	return Touch(tx, storeID)
+}
+
+// Generate builds all feeds of a store and replaces the stored ones
+func Generate(ctx context.Context, db *gorm.DB, blobs storage.BlobStore, storeID uint) ([]models.FeedFile, error) {
+	started := time.Now()
+
+	var store models.Store
+	if err := db.First(&store, storeID).Error; err != nil {
+		return nil, err
+	}
+	if store.StorefrontURL == "" {
+		return nil, ErrNoStorefrontURL
+	}
+	base := strings.TrimSuffix(store.StorefrontURL, "/")
+
+	files, err := buildSitemaps(db, store, base)
+	if err != nil {
+		return nil, err
+	}
+	merchant, err := buildMerchantFeed(db, store, base)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, merchant)
+
+	var old []models.FeedFile
+	if err := db.Where("store_id = ?", storeID).Find(&old).Error; err != nil {
+		return nil, err
+	}
+
+	// New blobs go up before rows point at them, so readers never miss one
+	stored := make([]models.FeedFile, 0, len(files))
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		etag := hex.EncodeToString(sum[:16])
+		feed := models.FeedFile{
+			StoreID:     storeID,
+			Name:        f.name,
+			BlobKey:     fmt.Sprintf("feeds/%d/%s/%s", storeID, etag, f.name),
+			ContentType: f.contentType,
+			ETag:        etag,
+			SizeBytes:   int64(len(f.data)),
+			GeneratedAt: started,
+		}
+		if err := blobs.Put(ctx, feed.BlobKey, bytes.NewReader(f.data), feed.SizeBytes, f.contentType); err != nil {
+			return nil, err
+		}
+		stored = append(stored, feed)
+	}
+
+	names := make([]string, len(stored))
+	for i, feed := range stored {
+		names[i] = feed.Name
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for i := range stored {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "store_id"}, {Name: "name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"blob_key", "content_type", "etag", "size_bytes", "generated_at", "updated_at", "deleted_at"}),
+			}).Create(&stored[i]).Error
+			if err != nil {
+				return err
+			}
+		}
+		// Sitemap parts a smaller catalog no longer needs
+		if err := tx.Unscoped().Where("store_id = ? AND name NOT IN ?", storeID, names).Delete(&models.FeedFile{}).Error; err != nil {
+			return err
+		}
+		state := models.FeedState{StoreID: storeID, ChangedAt: started, GeneratedAt: &started}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "store_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"generated_at", "updated_at"}),
+		}).Create(&state).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]bool, len(stored))
+	for _, feed := range stored {
+		current[feed.BlobKey] = true
+	}
+	for _, feed := range old {
+		if !current[feed.BlobKey] {
+			if err := blobs.Delete(ctx, feed.BlobKey); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("feeds: failed to delete old feed blob %s: %v", feed.BlobKey, err)
+			}
+		}
+	}
+	return stored, nil
+}
+
+// Open returns a stored feed and its content. Feeds are only generated by
+// the sweep or on an admin's request, a store without any yet gets
+// ErrNotGenerated.
+func Open(ctx context.Context, db *gorm.DB, blobs storage.BlobStore, storeID uint, name string) (*models.FeedFile, io.ReadCloser, error) {
+	var feed models.FeedFile
+	err := db.Where("store_id = ? AND name = ?", storeID, name).Limit(1).Find(&feed).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if feed.ID == 0 {
+		var count int64
+		if err := db.Model(&models.FeedFile{}).Where("store_id = ?", storeID).Count(&count).Error; err != nil {
+			return nil, nil, err
+		}
+		if count == 0 {
+			return nil, nil, ErrNotGenerated
+		}
+		return nil, nil, ErrNotFound
+	}
+
+	content, err := blobs.Get(ctx, feed.BlobKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &feed, content, nil
+}
+
+// RefreshStale regenerates the feeds of stores whose catalog changed and
+// has been quiet for quiet, or whose feeds are older than maxAge. Stores
+// with a storefront that never had feeds get their first ones.
+func RefreshStale(ctx context.Context, db *gorm.DB, blobs storage.BlobStore, quiet, maxAge time.Duration) (int, error) {
+	now := time.Now()
+	var storeIDs []uint
+	err := db.Model(&models.Store{}).
+		Joins("LEFT JOIN feed_states ON feed_states.store_id = stores.id").
+		Where("stores.storefront_url <> ''").
+		Where("feed_states.id IS NULL OR ((feed_states.generated_at IS NULL OR feed_states.changed_at > feed_states.generated_at) AND feed_states.changed_at <= ?) OR feed_states.generated_at <= ?",
+			now.Add(-quiet), now.Add(-maxAge)).
+		Pluck("stores.id", &storeIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	refreshed := 0
+	for _, storeID := range storeIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := Generate(ctx, db, blobs, storeID); err != nil {
+			log.Printf("feeds: failed to regenerate feeds of store %d: %v", storeID, err)
+			continue
+		}
+		refreshed++
+	}
+	return refreshed, nil
+}
+
+// SweepStale runs RefreshStale every interval until ctx is done
+func SweepStale(ctx context.Context, db *gorm.DB, blobs storage.BlobStore, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := RefreshStale(ctx, db, blobs, interval, maxAge); err != nil {
+				log.Printf("feeds: failed to look for stale feeds: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// currency prices are listed in, the one checkout charges in
+const currency = "INR"
+
+// Google caps these fields
+const (
+	maxTitleLength       = 150
+	maxDescriptionLength = 5000
+	maxAdditionalImages  = 10
+)
+
+type merchantRSS struct {
+	XMLName xml.Name        `xml:"rss"`
+	Version string          `xml:"version,attr"`
+	Xmlns   string          `xml:"xmlns:g,attr"`
+	Channel merchantChannel `xml:"channel"`
+}
+
+type merchantChannel struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	Description string         `xml:"description"`
+	Items       []merchantItem `xml:"item"`
+}
+
+// merchantItem is one SKU. Variants of a product share an item group.
+type merchantItem struct {
+	ID                   string   `xml:"g:id"`
+	ItemGroupID          string   `xml:"g:item_group_id,omitempty"`
+	Title                string   `xml:"g:title"`
+	Description          string   `xml:"g:description"`
+	Link                 string   `xml:"g:link"`
+	ImageLink            string   `xml:"g:image_link,omitempty"`
+	AdditionalImageLinks []string `xml:"g:additional_image_link,omitempty"`
+	Availability         string   `xml:"g:availability"`
+	AvailabilityDate     string   `xml:"g:availability_date,omitempty"`
+	Price                string   `xml:"g:price"`
+	SalePrice            string   `xml:"g:sale_price,omitempty"`
+	Condition            string   `xml:"g:condition"`
+	ProductType          string   `xml:"g:product_type,omitempty"`
+	ShippingWeight       string   `xml:"g:shipping_weight,omitempty"`
+	IdentifierExists     string   `xml:"g:identifier_exists"`
+}
+
+// buildMerchantFeed lists every SKU of the store's visible products as a
+// Google Merchant Center RSS item
+func buildMerchantFeed(db *gorm.DB, store models.Store, base string) (file, error) {
+	feed := merchantRSS{
+		Version: "2.0",
+		Xmlns:   "http://base.google.com/ns/1.0",
+		Channel: merchantChannel{Title: store.Name, Link: base + "/", Description: store.Description},
+	}
+	if feed.Channel.Description == "" {
+		feed.Channel.Description = store.Name
+	}
+
+	var products []models.Product
+	err := db.Where("store_id = ? AND is_archived = ? AND slug <> ''", store.ID, false).
+		Preload("Items").Preload("Images", func(db *gorm.DB) *gorm.DB { return db.Order("position, id") }).
+		Preload("Category").
+		FindInBatches(&products, 500, func(tx *gorm.DB, batch int) error {
+			var items []*models.ProductItem
+			for p := range products {
+				for i := range products[p].Items {
+					items = append(items, &products[p].Items[i])
+				}
+			}
+			if err := inventory.FillAvailable(db, items); err != nil {
+				return err
+			}
+			for _, product := range products {
+				feed.Channel.Items = append(feed.Channel.Items, merchantItems(product, base)...)
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return file{}, err
+	}
+
+	data, err := encodeXML(feed)
+	if err != nil {
+		return file{}, err
+	}
+	return file{name: Merchant, contentType: "application/rss+xml", data: data}, nil
+}
+
+func merchantItems(product models.Product, base string) []merchantItem {
+	description := product.Description
+	if description == "" {
+		description = product.Name
+	}
+
+	var images []string
+	for _, image := range product.Images {
+		images = append(images, absoluteURL(base, image.ImageURL))
+	}
+
+	var items []merchantItem
+	for _, sku := range product.Items {
+		item := merchantItem{
+			ID:               sku.SKU,
+			Title:            truncate(product.Name, maxTitleLength),
+			Description:      truncate(description, maxDescriptionLength),
+			Link:             productURL(base, product.Slug),
+			Availability:     availability(sku),
+			Price:            price(sku.Price),
+			Condition:        "new",
+			IdentifierExists: "no",
+		}
+		if len(product.Items) > 1 {
+			item.ItemGroupID = fmt.Sprint(product.ID)
+		}
+		if len(images) > 0 {
+			item.ImageLink = images[0]
+			item.AdditionalImageLinks = images[1:min(len(images), maxAdditionalImages+1)]
+		}
+		if item.Availability == "preorder" && sku.ReleaseDate != nil {
+			item.AvailabilityDate = sku.ReleaseDate.UTC().Format(time.RFC3339)
+		}
+		if sku.DiscountedPrice > 0 && sku.DiscountedPrice < sku.Price {
+			item.SalePrice = price(sku.DiscountedPrice)
+		}
+		if product.Category != nil {
+			item.ProductType = product.Category.Name
+		}
+		if sku.WeightGrams > 0 {
+			item.ShippingWeight = fmt.Sprintf("%d g", sku.WeightGrams)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// availability says whether a SKU can be bought now. Sold out SKUs may
+// still take orders under their stock policy.
+func availability(sku models.ProductItem) string {
+	if sku.AvailableQuantity > 0 {
+		return "in_stock"
+	}
+	switch sku.StockPolicy {
+	case models.StockPolicyPreorder:
+		return "preorder"
+	case models.StockPolicyBackorder:
+		return "backorder"
+	}
+	return "out_of_stock"
+}
+
+func price(amount float64) string {
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+// absoluteURL resolves image URLs served from this host, like local
+// media, against the storefront
+func absoluteURL(base, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || u.IsAbs() {
+		return ref
+	}
+	return base + "/" + strings.TrimPrefix(ref, "/")
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
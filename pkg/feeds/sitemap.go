@@ -0,0 +1,115 @@
+package feeds
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// MaxSitemapURLs is the most URLs one sitemap may list. Bigger stores get
+// a sitemap index pointing at numbered parts.
+const MaxSitemapURLs = 50000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// buildSitemaps lists the storefront's home page, categories and visible
+// products. Parts of a split sitemap are linked as base/sitemap-N.xml, the
+// storefront is expected to serve them from the feeds endpoint like
+// sitemap.xml itself.
+func buildSitemaps(db *gorm.DB, store models.Store, base string) ([]file, error) {
+	urls := []sitemapURL{{Loc: base + "/"}}
+
+	var pages []struct {
+		Slug      string
+		UpdatedAt time.Time
+	}
+	err := db.Model(&models.Category{}).Select("slug, updated_at").
+		Where("store_id = ? AND slug <> ''", store.ID).Order("path").Scan(&pages).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		urls = append(urls, sitemapURL{Loc: base + "/categories/" + url.PathEscape(page.Slug), LastMod: lastMod(page.UpdatedAt)})
+	}
+
+	pages = nil
+	err = db.Model(&models.Product{}).Select("slug, updated_at").
+		Where("store_id = ? AND slug <> '' AND is_archived = ?", store.ID, false).Order("id").Scan(&pages).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		urls = append(urls, sitemapURL{Loc: productURL(base, page.Slug), LastMod: lastMod(page.UpdatedAt)})
+	}
+
+	if len(urls) <= MaxSitemapURLs {
+		data, err := encodeXML(urlSet{Xmlns: sitemapNamespace, URLs: urls})
+		if err != nil {
+			return nil, err
+		}
+		return []file{{name: Sitemap, contentType: "application/xml", data: data}}, nil
+	}
+
+	var files []file
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	now := lastMod(time.Now())
+	for part := 1; len(urls) > 0; part++ {
+		n := min(len(urls), MaxSitemapURLs)
+		data, err := encodeXML(urlSet{Xmlns: sitemapNamespace, URLs: urls[:n]})
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("sitemap-%d.xml", part)
+		files = append(files, file{name: name, contentType: "application/xml", data: data})
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: base + "/" + name, LastMod: now})
+		urls = urls[n:]
+	}
+
+	data, err := encodeXML(index)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, file{name: Sitemap, contentType: "application/xml", data: data}), nil
+}
+
+func productURL(base, slug string) string {
+	return base + "/products/" + url.PathEscape(slug)
+}
+
+func lastMod(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func encodeXML(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
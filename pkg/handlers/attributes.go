@@ -0,0 +1,547 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/attributes"
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errDuplicateCode = errors.New("an attribute with this code already exists")
+
+type AttributeHandler struct {
+	DB *gorm.DB
+}
+
+func NewAttributeHandler(db *gorm.DB) *AttributeHandler {
+	return &AttributeHandler{DB: db}
+}
+
+type AttributeInput struct {
+	Code       string   `json:"code" binding:"required,max=100"`
+	Name       string   `json:"name" binding:"required,max=255"`
+	Type       string   `json:"type" binding:"required,oneof=text number boolean enum date"`
+	Unit       string   `json:"unit" binding:"max=50"`
+	Options    []string `json:"options"`
+	Filterable bool     `json:"filterable"`
+}
+
+type AttributeSetItemInput struct {
+	AttributeID uint `json:"attribute_id" binding:"required"`
+	Required    bool `json:"required"`
+}
+
+// AttributeSetInput lists a set's attributes in display order
+type AttributeSetInput struct {
+	Name       string                  `json:"name" binding:"required,max=255"`
+	Attributes []AttributeSetItemInput `json:"attributes" binding:"dive"`
+}
+
+type CategoryAttributeSetsInput struct {
+	AttributeSetIDs []uint `json:"attribute_set_ids" binding:"required"`
+}
+
+// ProductAttributesInput sets values by attribute code, null removes one
+type ProductAttributesInput struct {
+	Values map[string]any `json:"values" binding:"required"`
+}
+
+var attributeListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"code":       {Column: "attributes.code", Kind: listing.String},
+		"type":       {Column: "attributes.type", Kind: listing.String},
+		"filterable": {Column: "attributes.filterable", Kind: listing.Bool},
+	},
+	Sorts: map[string]string{
+		"id":   "attributes.id",
+		"name": "attributes.name",
+		"code": "attributes.code",
+	},
+	DefaultSort: "name",
+	Key:         "attributes.id",
+}
+
+var attributeSetListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"name": {Column: "attribute_sets.name", Kind: listing.String},
+	},
+	Sorts: map[string]string{
+		"id":   "attribute_sets.id",
+		"name": "attribute_sets.name",
+	},
+	DefaultSort: "name",
+	Key:         "attribute_sets.id",
+}
+
+func (h *AttributeHandler) ListAttributes(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query, ok := parseList(c, attributeListSpec)
+	if !ok {
+		return
+	}
+
+	var list []models.Attribute
+	page, err := query.Find(h.DB.Where("store_id = ?", store.ID), &list)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attributes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list, "meta": page, "error": nil})
+}
+
+func (h *AttributeHandler) CreateAttribute(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input AttributeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attribute := models.Attribute{StoreID: store.ID}
+	input.apply(&attribute)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := attributes.Check(attribute); err != nil {
+			return err
+		}
+		if err := checkAttributeCode(tx, attribute); err != nil {
+			return err
+		}
+		return tx.Create(&attribute).Error
+	})
+	if err != nil {
+		attributeError(c, err, "Failed to create attribute")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": attribute, "error": nil})
+}
+
+func (h *AttributeHandler) GetAttribute(c *gin.Context) {
+	attribute, ok := getStoreAttribute(c, h.DB)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attribute, "error": nil})
+}
+
+func (h *AttributeHandler) UpdateAttribute(c *gin.Context) {
+	attribute, ok := getStoreAttribute(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input AttributeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated := *attribute
+	input.apply(&updated)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := attributes.Check(updated); err != nil {
+			return err
+		}
+		if err := checkAttributeCode(tx, updated); err != nil {
+			return err
+		}
+		if err := attributes.CheckChange(tx, *attribute, updated); err != nil {
+			return err
+		}
+		return tx.Save(&updated).Error
+	})
+	if err != nil {
+		attributeError(c, err, "Failed to update attribute")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": updated, "error": nil})
+}
+
+// DeleteAttribute deletes an attribute along with its product values and
+// its place in attribute sets
+func (h *AttributeHandler) DeleteAttribute(c *gin.Context) {
+	attribute, ok := getStoreAttribute(c, h.DB)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("attribute_id = ?", attribute.ID).Delete(&models.ProductAttributeValue{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("attribute_id = ?", attribute.ID).Delete(&models.AttributeSetAttribute{}).Error; err != nil {
+			return err
+		}
+		// Deleted for good, the code can be used again
+		return tx.Unscoped().Delete(attribute).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attribute"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attribute deleted successfully"})
+}
+
+func (h *AttributeHandler) ListAttributeSets(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query, ok := parseList(c, attributeSetListSpec)
+	if !ok {
+		return
+	}
+
+	var sets []models.AttributeSet
+	page, err := query.Find(h.DB.Where("store_id = ?", store.ID), &sets, func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Attributes", orderSetAttributes).Preload("Attributes.Attribute")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attribute sets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sets, "meta": page, "error": nil})
+}
+
+func (h *AttributeHandler) CreateAttributeSet(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input AttributeSetInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	set := models.AttributeSet{StoreID: store.ID, Name: input.Name}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&set).Error; err != nil {
+			return err
+		}
+		return replaceSetAttributes(tx, set, input.Attributes)
+	})
+	if err != nil {
+		attributeError(c, err, "Failed to create attribute set")
+		return
+	}
+
+	h.respondWithSet(c, http.StatusCreated, set.ID)
+}
+
+func (h *AttributeHandler) GetAttributeSet(c *gin.Context) {
+	set, ok := getStoreAttributeSet(c, h.DB)
+	if !ok {
+		return
+	}
+
+	h.respondWithSet(c, http.StatusOK, set.ID)
+}
+
+// UpdateAttributeSet renames a set and replaces its attributes
+func (h *AttributeHandler) UpdateAttributeSet(c *gin.Context) {
+	set, ok := getStoreAttributeSet(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input AttributeSetInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(set).Update("name", input.Name).Error; err != nil {
+			return err
+		}
+		return replaceSetAttributes(tx, *set, input.Attributes)
+	})
+	if err != nil {
+		attributeError(c, err, "Failed to update attribute set")
+		return
+	}
+
+	h.respondWithSet(c, http.StatusOK, set.ID)
+}
+
+func (h *AttributeHandler) DeleteAttributeSet(c *gin.Context) {
+	set, ok := getStoreAttributeSet(c, h.DB)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM category_attribute_sets WHERE attribute_set_id = ?", set.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("attribute_set_id = ?", set.ID).Delete(&models.AttributeSetAttribute{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(set).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attribute set"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attribute set deleted successfully"})
+}
+
+// SetCategoryAttributeSets replaces the attribute sets assigned to a
+// category. Subcategories take them too.
+func (h *AttributeHandler) SetCategoryAttributeSets(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input CategoryAttributeSetsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sets := []models.AttributeSet{}
+	if len(input.AttributeSetIDs) > 0 {
+		if err := h.DB.Where("store_id = ? AND id IN ?", category.StoreID, input.AttributeSetIDs).Find(&sets).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attribute sets"})
+			return
+		}
+	}
+	if len(sets) != len(uniqueIDs(input.AttributeSetIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Attribute set not found"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM category_attribute_sets WHERE category_id = ?", category.ID).Error; err != nil {
+			return err
+		}
+		for _, set := range sets {
+			if err := tx.Exec("INSERT INTO category_attribute_sets (category_id, attribute_set_id) VALUES (?, ?)", category.ID, set.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign attribute sets"})
+		return
+	}
+
+	h.respondWithCategoryAttributes(c, *category)
+}
+
+// GetCategoryAttributes lists the attributes products of a category take,
+// from its own attribute sets and those of its ancestors
+func (h *AttributeHandler) GetCategoryAttributes(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	h.respondWithCategoryAttributes(c, *category)
+}
+
+func (h *AttributeHandler) GetProductAttributes(c *gin.Context) {
+	product, ok := getStoreProduct(c, h.DB)
+	if !ok {
+		return
+	}
+
+	h.respondWithProductAttributes(c, product.ID)
+}
+
+// SetProductAttributes writes attribute values of a product, leaving
+// attributes missing from the input as they are
+func (h *AttributeHandler) SetProductAttributes(c *gin.Context) {
+	product, ok := getStoreProduct(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input ProductAttributesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return attributes.Set(tx, *product, input.Values)
+	})
+	if err != nil {
+		attributeError(c, err, "Failed to save product attributes")
+		return
+	}
+
+	h.respondWithProductAttributes(c, product.ID)
+}
+
+func (h *AttributeHandler) respondWithSet(c *gin.Context, status int, id uint) {
+	var set models.AttributeSet
+	err := h.DB.Preload("Attributes", orderSetAttributes).Preload("Attributes.Attribute").First(&set, id).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attribute set"})
+		return
+	}
+
+	c.JSON(status, gin.H{"data": set, "error": nil})
+}
+
+func (h *AttributeHandler) respondWithCategoryAttributes(c *gin.Context, category models.Category) {
+	assigned, err := attributes.ForCategory(h.DB, category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category attributes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": assigned, "error": nil})
+}
+
+func (h *AttributeHandler) respondWithProductAttributes(c *gin.Context, productID uint) {
+	values := []models.ProductAttributeValue{}
+	if err := h.DB.Where("product_id = ?", productID).Preload("Attribute").Order("id").Find(&values).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product attributes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": values, "error": nil})
+}
+
+func (input AttributeInput) apply(attribute *models.Attribute) {
+	attribute.Code = input.Code
+	attribute.Name = input.Name
+	attribute.Type = input.Type
+	attribute.Unit = input.Unit
+	attribute.Options = input.Options
+	attribute.Filterable = input.Filterable
+}
+
+// replaceSetAttributes makes items the attributes of set, in that order
+func replaceSetAttributes(tx *gorm.DB, set models.AttributeSet, items []AttributeSetItemInput) error {
+	if err := tx.Unscoped().Where("attribute_set_id = ?", set.ID).Delete(&models.AttributeSetAttribute{}).Error; err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		ids[i] = item.AttributeID
+	}
+	if len(uniqueIDs(ids)) != len(ids) {
+		return &attributes.Error{Message: "an attribute is listed twice"}
+	}
+	var found int64
+	if err := tx.Model(&models.Attribute{}).Where("store_id = ? AND id IN ?", set.StoreID, ids).Count(&found).Error; err != nil {
+		return err
+	}
+	if int(found) != len(ids) {
+		return &attributes.Error{Message: "attribute not found"}
+	}
+
+	for i, item := range items {
+		member := models.AttributeSetAttribute{AttributeSetID: set.ID, AttributeID: item.AttributeID, Required: item.Required, Position: i}
+		if err := tx.Create(&member).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAttributeCode makes sure no other attribute of the store has the
+// code. Attributes soft deleted before deletes were made final still hold
+// their code in idx_attribute_code and are cleared out.
+func checkAttributeCode(tx *gorm.DB, attribute models.Attribute) error {
+	if err := tx.Unscoped().Where("store_id = ? AND code = ? AND deleted_at IS NOT NULL", attribute.StoreID, attribute.Code).
+		Delete(&models.Attribute{}).Error; err != nil {
+		return err
+	}
+
+	var count int64
+	err := tx.Model(&models.Attribute{}).
+		Where("store_id = ? AND code = ? AND id <> ?", attribute.StoreID, attribute.Code, attribute.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errDuplicateCode
+	}
+	return nil
+}
+
+func orderSetAttributes(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	unique := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	return unique
+}
+
+// getStoreAttribute loads the attribute_id attribute of an owned store
+func getStoreAttribute(c *gin.Context, db *gorm.DB) (*models.Attribute, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var attribute models.Attribute
+	if err := db.Where("store_id = ?", store.ID).First(&attribute, c.Param("attribute_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attribute not found"})
+		return nil, false
+	}
+	return &attribute, true
+}
+
+// getStoreAttributeSet loads the attribute_set_id set of an owned store
+func getStoreAttributeSet(c *gin.Context, db *gorm.DB) (*models.AttributeSet, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var set models.AttributeSet
+	if err := db.Where("store_id = ?", store.ID).First(&set, c.Param("attribute_set_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attribute set not found"})
+		return nil, false
+	}
+	return &set, true
+}
+
+func attributeError(c *gin.Context, err error, fallback string) {
+	var invalid *attributes.Error
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, attributes.ErrInUse), errors.Is(err, errDuplicateCode):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CartHandler struct {
+	DB *gorm.DB
+}
+
+func NewCartHandler(db *gorm.DB) *CartHandler {
+	return &CartHandler{DB: db}
+}
+
+type CartItemInput struct {
+	ProductItemID uint `json:"product_item_id" binding:"required"`
+	Quantity      int  `json:"quantity" binding:"required,gt=0"`
+}
+
+type UpdateCartItemInput struct {
+	Quantity int `json:"quantity" binding:"required,gt=0"`
+}
+
+// GetCart returns the customer's cart, starting an empty one if needed
+func (h *CartHandler) GetCart(c *gin.Context) {
+	cart, ok := h.getCart(c)
+	if !ok {
+		return
+	}
+
+	h.respond(c, http.StatusOK, cart.ID)
+}
+
+// AddCartItem puts a SKU in the cart, adding to the line already there
+func (h *CartHandler) AddCartItem(c *gin.Context) {
+	cart, ok := h.getCart(c)
+	if !ok {
+		return
+	}
+
+	var input CartItemInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var item models.CartItem
+	err := h.DB.Where("cart_id = ? AND product_item_id = ?", cart.ID, input.ProductItemID).First(&item).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart item"})
+		return
+	}
+	item.CartID = cart.ID
+	item.ProductItemID = input.ProductItemID
+	item.Quantity += input.Quantity
+
+	if !h.checkSellable(c, input.ProductItemID, item.Quantity) {
+		return
+	}
+
+	if err := h.DB.Save(&item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add cart item"})
+		return
+	}
+
+	h.respond(c, http.StatusOK, cart.ID)
+}
+
+func (h *CartHandler) UpdateCartItem(c *gin.Context) {
+	cart, ok := h.getCart(c)
+	if !ok {
+		return
+	}
+
+	var input UpdateCartItemInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var item models.CartItem
+	if err := h.DB.Where("cart_id = ?", cart.ID).First(&item, c.Param("item_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	if !h.checkSellable(c, item.ProductItemID, input.Quantity) {
+		return
+	}
+
+	if err := h.DB.Model(&item).Update("quantity", input.Quantity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
+		return
+	}
+
+	h.respond(c, http.StatusOK, cart.ID)
+}
+
+func (h *CartHandler) DeleteCartItem(c *gin.Context) {
+	cart, ok := h.getCart(c)
+	if !ok {
+		return
+	}
+
+	result := h.DB.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}, c.Param("item_id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete cart item"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cart item deleted successfully"})
+}
+
+// checkSellable makes sure the store can sell quantity units of the SKU,
+// from stock or through its backorder or pre-order policy
+func (h *CartHandler) checkSellable(c *gin.Context, productItemID uint, quantity int) bool {
+	var sku models.ProductItem
+	err := h.DB.Joins("JOIN products ON products.id = product_items.product_id AND products.deleted_at IS NULL").
+		Where("products.store_id = ? AND products.is_archived = ?", c.Param("store_id"), false).
+		First(&sku, productItemID).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product item not found"})
+		return false
+	}
+
+	if err := inventory.FillAvailable(h.DB, []*models.ProductItem{&sku}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+		return false
+	}
+	outstanding, err := inventory.Backordered(h.DB, []uint{sku.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+		return false
+	}
+
+	if _, _, _, err := inventory.Split(sku, sku.AvailableQuantity, outstanding[sku.ID], quantity, time.Now()); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock for " + sku.SKU})
+		return false
+	}
+	return true
+}
+
+func (h *CartHandler) getCart(c *gin.Context) (*models.Cart, bool) {
+	customerID := c.MustGet("customer_id").(uint)
+
+	var cart models.Cart
+	if err := h.DB.Where(models.Cart{CustomerID: customerID}).Order("id desc").FirstOrCreate(&cart).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return nil, false
+	}
+
+	return &cart, true
+}
+
+func (h *CartHandler) respond(c *gin.Context, status int, cartID uint) {
+	var cart models.Cart
+	if err := h.DB.Preload("CartItems", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("CartItems.ProductItem").First(&cart, cartID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+		return
+	}
+
+	skus := make([]*models.ProductItem, 0, len(cart.CartItems))
+	for _, item := range cart.CartItems {
+		if item.ProductItem != nil {
+			skus = append(skus, item.ProductItem)
+		}
+	}
+	if err := inventory.FillAvailable(h.DB, skus); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock"})
+		return
+	}
+
+	c.JSON(status, gin.H{"data": cart, "error": nil})
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/catalog"
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/jobs"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const importBatchSize = 100
+
+type CatalogHandler struct {
+	DB             *gorm.DB
+	Store          storage.BlobStore
+	MaxUploadBytes int64
+}
+
+func NewCatalogHandler(db *gorm.DB, store storage.BlobStore, maxUploadBytes int64) *CatalogHandler {
+	return &CatalogHandler{DB: db, Store: store, MaxUploadBytes: maxUploadBytes}
+}
+
+type ExportInput struct {
+	Format     string `json:"format" binding:"required,oneof=csv ndjson xlsx"`
+	CategoryID *uint  `json:"category_id"`
+	Archived   *bool  `json:"archived"`
+	Featured   *bool  `json:"featured"`
+}
+
+// ExportResult is stored on a finished export job
+type ExportResult struct {
+	Rows        int    `json:"rows"`
+	FileKey     string `json:"file_key"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+}
+
+// Import takes a CSV, JSON, NDJSON or XLSX catalog file as the multipart "file"
+// field and validates all of it first. With dry_run=true, or when any row
+// is invalid, the validation report is all that comes back. Otherwise the
+// import runs as a background job whose progress can be polled.
+func (h *CatalogHandler) Import(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.MaxUploadBytes)
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A catalog file is required"})
+		return
+	}
+	defer file.Close()
+
+	format := c.PostForm("format")
+	if format == "" {
+		format = catalog.FormatFromName(header.Filename)
+	}
+
+	rows, rowErrors, err := catalog.Parse(file, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file has no rows"})
+		return
+	}
+
+	plan, report, err := catalog.Validate(h.DB, store.ID, rows, rowErrors)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate import"})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.PostForm("dry_run"))
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{"data": report, "error": nil})
+		return
+	}
+	if len(report.Errors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"data": report, "error": "The file has invalid rows"})
+		return
+	}
+
+	adminID := c.MustGet("admin_id").(uint)
+	job := models.Job{
+		StoreID: store.ID,
+		AdminID: &adminID,
+		Type:    models.JobCatalogImport,
+		Total:   report.Rows,
+	}
+
+	actor := inventory.Movement{ActorType: models.ActorAdmin, ActorID: &adminID}
+	err = jobs.Start(h.DB, &job, func(ctx context.Context, progress jobs.Progress) (any, error) {
+		if err := catalog.Apply(ctx, h.DB, plan, importBatchSize, actor, progress); err != nil {
+			return nil, err
+		}
+		return report, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start import"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": gin.H{"job": job, "report": report}, "error": nil})
+}
+
+// Export starts a background job that writes the store's catalog, narrowed
+// down by the filters, to a file in the blob store. Once the job completes
+// the file can be downloaded.
+func (h *CatalogHandler) Export(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input ExportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := catalog.ExportFilter{CategoryID: input.CategoryID, Archived: input.Archived, Featured: input.Featured}
+	if filter.CategoryID != nil {
+		var category models.Category
+		if err := h.DB.Where("store_id = ?", store.ID).First(&category, *filter.CategoryID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	total, err := catalog.CountExport(h.DB, store.ID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
+		return
+	}
+
+	adminID := c.MustGet("admin_id").(uint)
+	job := models.Job{
+		StoreID: store.ID,
+		AdminID: &adminID,
+		Type:    models.JobCatalogExport,
+		Total:   int(total),
+	}
+
+	types := catalog.ExportTypes[input.Format]
+	err = jobs.Start(h.DB, &job, func(ctx context.Context, progress jobs.Progress) (any, error) {
+		// Stream to a temporary file first, blob stores want the size up front
+		tmp, err := os.CreateTemp("", "catalog-export-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		rows, err := catalog.Export(ctx, h.DB, store.ID, filter, input.Format, tmp, progress)
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := tmp.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		result := ExportResult{
+			Rows:        rows,
+			FileKey:     fmt.Sprintf("exports/%d/%s-%d%s", store.ID, time.Now().Format("20060102-150405"), job.ID, types[0]),
+			FileName:    fmt.Sprintf("catalog-%s%s", time.Now().Format("2006-01-02"), types[0]),
+			ContentType: types[1],
+		}
+		if err := h.Store.Put(ctx, result.FileKey, tmp, size, result.ContentType); err != nil {
+			return nil, err
+		}
+		return result, nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": job, "error": nil})
+}
+
+// DownloadExport serves the file of a completed export job
+func (h *CatalogHandler) DownloadExport(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var job models.Job
+	if err := h.DB.Where("store_id = ? AND type = ?", store.ID, models.JobCatalogExport).First(&job, c.Param("job_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return
+	}
+	if job.Status != models.JobCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is not ready"})
+		return
+	}
+
+	var result ExportResult
+	if err := json.Unmarshal([]byte(job.Result), &result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Export has no file"})
+		return
+	}
+
+	file, err := h.Store.Get(c.Request.Context(), result.FileKey)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export file not found"})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, result.FileName))
+	c.DataFromReader(http.StatusOK, -1, result.ContentType, file, nil)
+}
@@ -1,19 +1,18 @@
-/*
-- check if the parent category exists when creating a category and other checks on it
-  - this will be taken care by the fk constraint
-  - but it's not working when we are creating the category with id 1 and parent_category_id = 1 (self referencing)
-
-- check if the store exists
-- check if the admin is the owner of the store
-*/
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
+	"github.com/blanc42/ecms/pkg/categories"
+	"github.com/blanc42/ecms/pkg/collections"
+	"github.com/blanc42/ecms/pkg/feeds"
 	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/search"
+	"github.com/blanc42/ecms/pkg/slugs"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -31,6 +30,31 @@ type CreateCategoryInput struct {
 	Description      string `json:"description"`
 	StoreID          uint   `json:"store_id" binding:"required"`
 	ParentCategoryID *uint  `json:"parent_category_id,omitempty"`
+	SEOInput
+}
+
+// UpdateCategoryInput takes the fields of CreateCategoryInput. A
+// parent_category_id that is given moves the category, null to the top
+// level; left out, the category stays where it is.
+type UpdateCategoryInput struct {
+	CreateCategoryInput
+	parentGiven bool
+}
+
+func (input *UpdateCategoryInput) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	_, input.parentGiven = fields["parent_category_id"]
+	return json.Unmarshal(data, &input.CreateCategoryInput)
+}
+
+// MoveCategoryInput places a category. A null parent moves it to the top
+// level; without a position it goes after its new siblings.
+type MoveCategoryInput struct {
+	ParentCategoryID *uint `json:"parent_category_id"`
+	Position         *int  `json:"position" binding:"omitempty,min=0"`
 }
 
 func (h *CategoryHandler) CreateCategory(c *gin.Context) {
@@ -58,10 +82,23 @@ func (h *CategoryHandler) CreateCategory(c *gin.Context) {
 		Description:      input.Description,
 		StoreID:          input.StoreID,
 		ParentCategoryID: input.ParentCategoryID,
+		SEOTitle:         input.SEOTitle,
+		MetaDescription:  input.MetaDescription,
+		CanonicalURL:     input.CanonicalURL,
 	}
 
-	if err := h.DB.Create(&category).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if category.Slug, err = slugs.Assign(tx, slugs.Category, category.StoreID, 0, "", input.Slug, category.Name); err != nil {
+			return err
+		}
+		if err := categories.Create(tx, &category); err != nil {
+			return err
+		}
+		return feeds.Touch(tx, category.StoreID)
+	})
+	if err != nil {
+		categoryError(c, err, "Failed to create category")
 		fmt.Println(err)
 		return
 	}
@@ -70,11 +107,8 @@ func (h *CategoryHandler) CreateCategory(c *gin.Context) {
 }
 
 func (h *CategoryHandler) GetCategory(c *gin.Context) {
-	categoryID := c.Param("category_id")
-	var category models.Category
-
-	if err := h.DB.First(&category, categoryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
 		return
 	}
 
@@ -82,41 +116,134 @@ func (h *CategoryHandler) GetCategory(c *gin.Context) {
 }
 
 func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
-	categoryID := c.Param("category_id")
-	var category models.Category
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
 
-	if err := h.DB.First(&category, categoryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+	var input UpdateCategoryInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var input CreateCategoryInput
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// A new parent goes through the same checks as a move
+		if input.parentGiven && !sameParent(input.ParentCategoryID, category.ParentCategoryID) {
+			if err := categories.Move(tx, category, input.ParentCategoryID, nil); err != nil {
+				return err
+			}
+			// Collections matching on the new parent's subtree now hold its products
+			if err := collections.RefreshStore(tx, category.StoreID); err != nil {
+				return err
+			}
+		}
+		name := category.Name
+		if input.Name != "" {
+			name = input.Name
+		}
+		slug, err := slugs.Assign(tx, slugs.Category, category.StoreID, category.ID, category.Slug, input.Slug, name)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(category).Updates(models.Category{
+			Name:            input.Name,
+			Description:     input.Description,
+			Slug:            slug,
+			SEOTitle:        input.SEOTitle,
+			MetaDescription: input.MetaDescription,
+			CanonicalURL:    input.CanonicalURL,
+		}).Error; err != nil {
+			return err
+		}
+		if err := feeds.Touch(tx, category.StoreID); err != nil {
+			return err
+		}
+		// Product search matches on the category name
+		return search.IndexCategory(tx, category.ID)
+	})
+	if err != nil {
+		categoryError(c, err, "Failed to update category")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": category, "error": nil})
+}
+
+// MoveCategory re-parents a category and/or changes its place among its
+// siblings
+func (h *CategoryHandler) MoveCategory(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input MoveCategoryInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	h.DB.Model(&category).Updates(models.Category{
-		Name:             input.Name,
-		Description:      input.Description,
-		ParentCategoryID: input.ParentCategoryID,
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := categories.Move(tx, category, input.ParentCategoryID, input.Position); err != nil {
+			return err
+		}
+		if err := collections.RefreshStore(tx, category.StoreID); err != nil {
+			return err
+		}
+		return feeds.Touch(tx, category.StoreID)
 	})
+	if err != nil {
+		categoryError(c, err, "Failed to move category")
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"data": category, "error": nil})
 }
 
+// DeleteCategory takes a mode of refuse (the default), cascade or
+// reparent, see the categories package
 func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
-	categoryID := c.Param("category_id")
-	var category models.Category
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
 
-	if err := h.DB.First(&category, categoryID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+	mode := c.DefaultQuery("mode", categories.DeleteRefuse)
+	switch mode {
+	case categories.DeleteRefuse, categories.DeleteCascade, categories.DeleteReparent:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be refuse, cascade or reparent"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// Cascaded products leave manual collections too
+		if mode == categories.DeleteCascade {
+			ids, err := categories.Descendants(tx, *category)
+			if err != nil {
+				return err
+			}
+			if err := collections.RemoveProducts(tx, tx.Model(&models.Product{}).Select("id").Where("category_id IN ?", ids)); err != nil {
+				return err
+			}
+		}
+		if err := categories.Delete(tx, *category, mode); err != nil {
+			return err
+		}
+		if err := collections.RefreshStore(tx, category.StoreID); err != nil {
+			return err
+		}
+		return feeds.Touch(tx, category.StoreID)
+	})
+	if err != nil {
+		categoryError(c, err, "Failed to delete category")
 		return
 	}
 
-	h.DB.Delete(&category)
 	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
 }
+
 func (h *CategoryHandler) GetAllCategories(c *gin.Context) {
 	storeID, err := strconv.ParseUint(c.Param("store_id"), 10, 32)
 	if err != nil {
@@ -127,46 +254,130 @@ func (h *CategoryHandler) GetAllCategories(c *gin.Context) {
 
 	level, _ := strconv.Atoi(c.DefaultQuery("level", "-1"))
 
-	var categories []models.Category
-
-	// Use a CTE to fetch the entire category tree in a single query
-	query := h.DB.Raw(`
-		WITH RECURSIVE category_tree AS (
-			SELECT *, 0 AS level
-			FROM categories
-			WHERE store_id = ? AND parent_category_id IS NULL
-			
-			UNION ALL
-			
-			SELECT c.*, ct.level + 1
-			FROM categories c
-			JOIN category_tree ct ON c.parent_category_id = ct.id
-			WHERE c.store_id = ?
-		)
-		SELECT *
-		FROM category_tree
-		WHERE ? = -1 OR level <= ?
-		ORDER BY id
-	`, storeID, storeID, level, level)
-
-	if err := query.Scan(&categories).Error; err != nil {
+	// Paths and depths make this a plain query, children come after their
+	// parents ordered by depth
+	query := h.DB.Where("store_id = ?", storeID)
+	if level >= 0 {
+		query = query.Where("depth <= ?", level)
+	}
+
+	var flat []models.Category
+	if err := query.Order("depth, position, id").Find(&flat).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	direct, total, err := categories.ProductCounts(h.DB, uint(storeID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
+		return
+	}
+	for i := range flat {
+		productCount, totalCount := direct[flat[i].ID], total[flat[i].ID]
+		flat[i].ProductCount, flat[i].TotalProductCount = &productCount, &totalCount
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": buildTree(flat), "error": nil})
+}
+
+// GetSubtree returns a category with the categories below it, as deep as
+// the optional depth param
+func (h *CategoryHandler) GetSubtree(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query := categories.Subtree(h.DB, *category)
+	if value, ok := c.GetQuery("depth"); ok {
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be a non-negative integer"})
+			return
+		}
+		query = query.Where("depth <= ?", category.Depth+depth)
+	}
+
+	var flat []models.Category
+	if err := query.Order("depth, position, id").Find(&flat).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
 		return
 	}
 
-	// Convert flat structure to tree
+	c.JSON(http.StatusOK, gin.H{"data": buildTree(flat), "error": nil})
+}
+
+// GetAncestors returns the breadcrumbs of a category: its ancestors from
+// the root down, then the category itself
+func (h *CategoryHandler) GetAncestors(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	ancestors, err := categories.Ancestors(h.DB, *category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": append(ancestors, *category), "error": nil})
+}
+
+// buildTree nests categories ordered parents first. Those whose parent
+// isn't among them are the roots.
+func buildTree(flat []models.Category) []*models.Category {
 	categoryMap := make(map[uint]*models.Category)
-	var result []*models.Category
+	result := []*models.Category{}
 
-	for i := range categories {
-		categoryMap[categories[i].ID] = &categories[i]
-		if categories[i].ParentCategoryID == nil {
-			result = append(result, &categories[i])
+	for i := range flat {
+		categoryMap[flat[i].ID] = &flat[i]
+		if flat[i].ParentCategoryID == nil {
+			result = append(result, &flat[i])
+		} else if parent, ok := categoryMap[*flat[i].ParentCategoryID]; ok {
+			parent.Subcategories = append(parent.Subcategories, &flat[i])
 		} else {
-			parent := categoryMap[*categories[i].ParentCategoryID]
-			parent.Subcategories = append(parent.Subcategories, &categories[i])
+			result = append(result, &flat[i])
 		}
 	}
+	return result
+}
 
-	c.JSON(http.StatusOK, gin.H{"data": result, "error": nil})
+// getStoreCategory loads the category named by the category_id param from
+// the admin's store. It writes the error response itself.
+func getStoreCategory(c *gin.Context, db *gorm.DB) (*models.Category, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var category models.Category
+	if err := db.Where("store_id = ?", store.ID).First(&category, c.Param("category_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return nil, false
+	}
+	return &category, true
+}
+
+// sameParent compares parent IDs, nil being the top level
+func sameParent(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// categoryError answers with the status a tree rule violation deserves
+func categoryError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, categories.ErrParentNotFound), errors.Is(err, categories.ErrOtherStore), errors.Is(err, categories.ErrCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, slugs.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, categories.ErrNotEmpty), errors.Is(err, categories.ErrNoGrandparent), errors.Is(err, categories.ErrParentLoop),
+		errors.Is(err, slugs.ErrTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
 }
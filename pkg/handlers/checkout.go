@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errNotPending = errors.New("order is not pending")
+
+type CheckoutHandler struct {
+	DB             *gorm.DB
+	ReservationTTL time.Duration
+}
+
+func NewCheckoutHandler(db *gorm.DB, reservationTTL time.Duration) *CheckoutHandler {
+	return &CheckoutHandler{DB: db, ReservationTTL: reservationTTL}
+}
+
+type StartCheckoutInput struct {
+	CartID uint `json:"cart_id" binding:"required"`
+}
+
+// StartCheckout turns the customer's cart into a pending order and holds
+// its stock until the reservation expires. Lines the stock can't cover are
+// backordered or pre-ordered when the SKU's policy allows it.
+func (h *CheckoutHandler) StartCheckout(c *gin.Context) {
+	customerID := c.MustGet("customer_id").(uint)
+
+	var input StartCheckoutInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var cart models.Cart
+	if err := h.DB.Where("customer_id = ?", customerID).Preload("CartItems.ProductItem.Product").First(&cart, input.CartID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return
+	}
+	if len(cart.CartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	}
+
+	var customer models.Customer
+	if err := h.DB.First(&customer, customerID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	order := models.Order{
+		OrderNumber:   newOrderNumber(),
+		PaymentStatus: models.PaymentStatusPending,
+		OrderStatus:   models.OrderStatusPending,
+		StoreID:       customer.StoreID,
+		CustomerID:    customer.ID,
+		Currency:      "INR",
+	}
+
+	for _, cartItem := range cart.CartItems {
+		sku := cartItem.ProductItem
+		if sku == nil || sku.Product == nil || sku.Product.StoreID != customer.StoreID || sku.Product.IsArchived {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Cart item %d is not available", cartItem.ID)})
+			return
+		}
+
+		price := sku.Price
+		if sku.DiscountedPrice > 0 {
+			price = sku.DiscountedPrice
+		}
+		order.OrderItems = append(order.OrderItems, models.OrderItem{
+			ProductItemID: sku.ID,
+			Quantity:      cartItem.Quantity,
+			UnitPrice:     price,
+		})
+		order.TotalAmount += price * float64(cartItem.Quantity)
+	}
+
+	var reservations []models.StockReservation
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+
+		var err error
+		reservations, err = inventory.Reserve(tx, order.ID, order.OrderItems, h.ReservationTTL)
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error
+	})
+
+	if errors.Is(err, inventory.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start checkout"})
+		return
+	}
+
+	// A fully backordered order holds no stock, so nothing expires
+	var expiresAt *time.Time
+	if len(reservations) > 0 {
+		expiresAt = &reservations[0].ExpiresAt
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{
+		"order":        order,
+		"reservations": reservations,
+		"expires_at":   expiresAt,
+	}, "error": nil})
+}
+
+// CancelCheckout abandons an unpaid order and gives its stock back. An
+// order with a payment still in progress can't be canceled, the payment
+// could yet go through.
+func (h *CheckoutHandler) CancelCheckout(c *gin.Context) {
+	customerID := c.MustGet("customer_id").(uint)
+
+	var order models.Order
+	if err := h.DB.Where("customer_id = ? AND store_id = ?", customerID, c.Param("store_id")).First(&order, c.Param("order_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// Locked like payments lock it, so a payment can't settle mid-cancel
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, order.ID).Error; err != nil {
+			return err
+		}
+		if order.OrderStatus != models.OrderStatusPending {
+			return errNotPending
+		}
+		if open, err := hasOpenAttempt(tx, order.ID); err != nil || open {
+			return cmp.Or(err, errPaymentOpen)
+		}
+
+		if err := inventory.Release(tx, order.ID); err != nil {
+			return err
+		}
+		return tx.Model(&order).Update("order_status", models.OrderStatusCanceled).Error
+	})
+
+	if errors.Is(err, errNotPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only pending orders can be canceled"})
+		return
+	}
+	if errors.Is(err, errPaymentOpen) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has a payment in progress, complete it or wait for it to fail"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel checkout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": order, "error": nil})
+}
+
+func newOrderNumber() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("ORD-%s-%s", time.Now().Format("20060102"), strings.ToUpper(hex.EncodeToString(b)))
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/collections"
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/slugs"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CollectionHandler struct {
+	DB *gorm.DB
+}
+
+func NewCollectionHandler(db *gorm.DB) *CollectionHandler {
+	return &CollectionHandler{DB: db}
+}
+
+// CollectionInput describes a collection. Automatic collections take
+// rules, manual ones get their products through SetCollectionProducts.
+type CollectionInput struct {
+	Name        string                  `json:"name" binding:"required,max=255"`
+	Description string                  `json:"description"`
+	Type        string                  `json:"type" binding:"required,oneof=manual automatic"`
+	Rules       []models.CollectionRule `json:"rules"`
+	MatchAny    bool                    `json:"match_any"`
+	IsPublished bool                    `json:"is_published"`
+	Slug        string                  `json:"slug" binding:"max=200"`
+}
+
+var collectionListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"type":         {Column: "collections.type", Kind: listing.String},
+		"is_published": {Column: "collections.is_published", Kind: listing.Bool},
+	},
+	Sorts: map[string]string{
+		"id":         "collections.id",
+		"name":       "collections.name",
+		"created_at": "collections.created_at",
+	},
+	DefaultSort: "name",
+	Key:         "collections.id",
+}
+
+// CollectionProductsInput lists a manual collection's products in order
+type CollectionProductsInput struct {
+	ProductIDs []uint `json:"product_ids" binding:"required,max=1000"`
+}
+
+func (h *CollectionHandler) ListCollections(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query, ok := parseList(c, collectionListSpec)
+	if !ok {
+		return
+	}
+
+	var list []models.Collection
+	page, err := query.Find(h.DB.Where("store_id = ?", store.ID), &list)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch collections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list, "meta": page, "error": nil})
+}
+
+// CreateCollection adds a collection, filling automatic ones from their
+// rules right away
+func (h *CollectionHandler) CreateCollection(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input CollectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	collection := models.Collection{StoreID: store.ID}
+	input.apply(&collection)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := collections.Check(tx, &collection); err != nil {
+			return err
+		}
+		var err error
+		if collection.Slug, err = slugs.Assign(tx, slugs.Collection, store.ID, 0, "", input.Slug, collection.Name); err != nil {
+			return err
+		}
+		if err := tx.Create(&collection).Error; err != nil {
+			return err
+		}
+		return collections.Refresh(tx, collection)
+	})
+	if err != nil {
+		collectionError(c, err, "Failed to create collection")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": collection, "error": nil})
+}
+
+func (h *CollectionHandler) GetCollection(c *gin.Context) {
+	collection, ok := getStoreCollection(c, h.DB)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": collection, "error": nil})
+}
+
+// UpdateCollection replaces a collection's definition. A collection
+// turning manual keeps the products its rules had matched.
+func (h *CollectionHandler) UpdateCollection(c *gin.Context) {
+	collection, ok := getStoreCollection(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input CollectionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current := collection.Slug
+	input.apply(collection)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := collections.Check(tx, collection); err != nil {
+			return err
+		}
+		var err error
+		if collection.Slug, err = slugs.Assign(tx, slugs.Collection, collection.StoreID, collection.ID, current, input.Slug, collection.Name); err != nil {
+			return err
+		}
+		if err := tx.Save(collection).Error; err != nil {
+			return err
+		}
+		return collections.Refresh(tx, *collection)
+	})
+	if err != nil {
+		collectionError(c, err, "Failed to update collection")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": collection, "error": nil})
+}
+
+func (h *CollectionHandler) DeleteCollection(c *gin.Context) {
+	collection, ok := getStoreCollection(c, h.DB)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := collections.Delete(tx, *collection); err != nil {
+			return err
+		}
+		return slugs.DeleteRedirects(tx, slugs.Collection, []uint{collection.ID})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete collection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Collection deleted successfully"})
+}
+
+// ListCollectionProducts lists a collection's products, in the manual
+// order unless another sort is asked for
+func (h *CollectionHandler) ListCollectionProducts(c *gin.Context) {
+	collection, ok := getStoreCollection(c, h.DB)
+	if !ok {
+		return
+	}
+
+	listProducts(c, h.DB, collectionProducts(h.DB, *collection), collectionProductListSpec(collection.ID), adminProductPreloads)
+}
+
+// SetCollectionProducts replaces the products of a manual collection,
+// which also sets their order
+func (h *CollectionHandler) SetCollectionProducts(c *gin.Context) {
+	collection, ok := getStoreCollection(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input CollectionProductsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return collections.SetProducts(tx, *collection, input.ProductIDs)
+	})
+	if err != nil {
+		collectionError(c, err, "Failed to set collection products")
+		return
+	}
+
+	var members []models.CollectionProduct
+	h.DB.Where("collection_id = ?", collection.ID).Order("position, id").Find(&members)
+
+	c.JSON(http.StatusOK, gin.H{"data": members, "error": nil})
+}
+
+// StorefrontListCollections lists the store's published collections
+func (h *CollectionHandler) StorefrontListCollections(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query, ok := parseList(c, collectionListSpec)
+	if !ok {
+		return
+	}
+
+	var list []models.Collection
+	page, err := query.Find(h.DB.Where("store_id = ? AND is_published = ?", store.ID, true), &list)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch collections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": list, "meta": page, "error": nil})
+}
+
+// StorefrontCollectionBySlug shows a published collection by its slug,
+// following old slugs like StorefrontProductBySlug
+func (h *CollectionHandler) StorefrontCollectionBySlug(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	id, ok := resolveSlug(c, h.DB, slugs.Collection, store.ID, "/collections/by-slug/")
+	if !ok {
+		return
+	}
+
+	var collection models.Collection
+	if err := h.DB.Where("is_published = ?", true).First(&collection, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": collection, "error": nil})
+}
+
+// StorefrontCollectionProducts lists the products of a published
+// collection customers can see
+func (h *CollectionHandler) StorefrontCollectionProducts(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var collection models.Collection
+	if err := h.DB.Where("store_id = ? AND is_published = ?", store.ID, true).First(&collection, c.Param("collection_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+
+	listProducts(c, h.DB, collectionProducts(h.DB, collection).Where("products.is_archived = ?", false),
+		collectionProductListSpec(collection.ID), storefrontProductPreloads)
+}
+
+// apply copies the input onto a collection
+func (input CollectionInput) apply(collection *models.Collection) {
+	collection.Name = input.Name
+	collection.Description = input.Description
+	collection.Type = input.Type
+	collection.Rules = input.Rules
+	collection.MatchAny = input.MatchAny
+	collection.IsPublished = input.IsPublished
+	if collection.Rules == nil {
+		collection.Rules = []models.CollectionRule{}
+	}
+}
+
+// collectionProducts selects the products in a collection
+func collectionProducts(db *gorm.DB, collection models.Collection) *gorm.DB {
+	members := db.Model(&models.CollectionProduct{}).Select("product_id").Where("collection_id = ?", collection.ID)
+	return db.Where("products.store_id = ? AND products.id IN (?)", collection.StoreID, members)
+}
+
+// collectionProductListSpec lists products like productListSpec, adding
+// the collection's position as the default sort
+func collectionProductListSpec(collectionID uint) listing.Spec {
+	spec := productListSpec
+	spec.Sorts = maps.Clone(spec.Sorts)
+	spec.Sorts["position"] = fmt.Sprintf("coalesce((SELECT collection_products.position FROM collection_products WHERE collection_products.collection_id = %d AND collection_products.product_id = products.id), 0)", collectionID)
+	spec.DefaultSort = "position"
+	return spec
+}
+
+// getStoreCollection loads the collection_id collection of a store owned
+// by the admin
+func getStoreCollection(c *gin.Context, db *gorm.DB) (*models.Collection, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var collection models.Collection
+	if err := db.Where("store_id = ?", store.ID).First(&collection, c.Param("collection_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return nil, false
+	}
+	return &collection, true
+}
+
+func collectionError(c *gin.Context, err error, fallback string) {
+	var invalid *collections.Error
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	slugError(c, err, fallback)
+}
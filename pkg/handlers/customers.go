@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CustomerHandler struct {
+	DB *gorm.DB
+}
+
+func NewCustomerHandler(db *gorm.DB) *CustomerHandler {
+	return &CustomerHandler{DB: db}
+}
+
+func (h *CustomerHandler) Signup(c *gin.Context) {
+	storeID, err := strconv.ParseUint(c.Param("store_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid store ID"})
+		return
+	}
+
+	var input SignupInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var store models.Store
+	if err := h.DB.First(&store, storeID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	customer := models.Customer{
+		Username: input.Username,
+		Email:    input.Email,
+		Password: hashedPassword,
+		StoreID:  store.ID,
+	}
+
+	if err := h.DB.Create(&customer).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
+		return
+	}
+
+	token, err := utils.GenerateCustomerToken(customer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.SetCookie("customer-token", token, 3600*24, "/", "", false, true) // Set token as http only cookie
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Signup successful"})
+}
+
+func (h *CustomerHandler) Login(c *gin.Context) {
+	var input LoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var customer models.Customer
+	if err := h.DB.Where("email = ? AND store_id = ?", input.Email, c.Param("store_id")).First(&customer).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	if err := utils.ComparePasswords(customer.Password, input.Password); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	token, err := utils.GenerateCustomerToken(customer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.SetCookie("customer-token", token, 3600*24, "/", "", false, true) // Set token as http only cookie
+
+	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "data": customer})
+}
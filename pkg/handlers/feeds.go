@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/blanc42/ecms/pkg/feeds"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type FeedHandler struct {
+	DB    *gorm.DB
+	Store storage.BlobStore
+}
+
+func NewFeedHandler(db *gorm.DB, store storage.BlobStore) *FeedHandler {
+	return &FeedHandler{DB: db, Store: store}
+}
+
+// ListFeeds shows the store's generated feeds and whether they are stale
+func (h *FeedHandler) ListFeeds(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var files []models.FeedFile
+	if err := h.DB.Where("store_id = ?", store.ID).Order("name").Find(&files).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feeds"})
+		return
+	}
+	var state models.FeedState
+	if err := h.DB.Where("store_id = ?", store.ID).Limit(1).Find(&state).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch feeds"})
+		return
+	}
+
+	stale := state.ID != 0 && (state.GeneratedAt == nil || state.ChangedAt.After(*state.GeneratedAt))
+	c.JSON(http.StatusOK, gin.H{"data": files, "meta": gin.H{"stale": stale, "changed_at": state.ChangedAt, "generated_at": state.GeneratedAt}, "error": nil})
+}
+
+// RegenerateFeeds rebuilds the store's feeds now instead of waiting for
+// the sweep
+func (h *FeedHandler) RegenerateFeeds(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	files, err := feeds.Generate(c.Request.Context(), h.DB, h.Store, store.ID)
+	if err != nil {
+		feedError(c, err, "Failed to generate feeds")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": files, "error": nil})
+}
+
+// StorefrontFeed serves a feed, sitemap.xml, its parts or merchant.xml.
+// Crawlers revalidate with If-None-Match, and are asked to come back later
+// while the store's first feeds are still being generated.
+func (h *FeedHandler) StorefrontFeed(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	feed, content, err := feeds.Open(c.Request.Context(), h.DB, h.Store, store.ID, c.Param("name"))
+	if err != nil {
+		feedError(c, err, "Failed to fetch feed")
+		return
+	}
+	defer content.Close()
+
+	etag := strconv.Quote(feed.ETag)
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", feed.GeneratedAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "public, max-age=900")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, feed.SizeBytes, feed.ContentType, content, nil)
+}
+
+func feedError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, feeds.ErrNoStorefrontURL):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, feeds.ErrNotGenerated):
+		c.Header("Retry-After", "300")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, feeds.ErrNotFound), errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Feed not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
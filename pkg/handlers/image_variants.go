@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/blanc42/ecms/pkg/media"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ImageVariantHandler struct {
+	DB     *gorm.DB
+	Store  storage.BlobStore
+	Cache  *media.DiskCache
+	Secret []byte
+}
+
+func NewImageVariantHandler(db *gorm.DB, store storage.BlobStore, cache *media.DiskCache, secret string) *ImageVariantHandler {
+	return &ImageVariantHandler{DB: db, Store: store, Cache: cache, Secret: []byte(secret)}
+}
+
+// SignImageURL gives the admin a signed URL for a derivative of an image,
+// described by the same w, h, fit, format and q parameters it will carry
+func (h *ImageVariantHandler) SignImageURL(c *gin.Context) {
+	image, ok := getProductImage(c, h.DB)
+	if !ok {
+		return
+	}
+
+	opts, err := media.ParseOptions(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(h.Secret) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Image signing is not configured"})
+		return
+	}
+
+	query := opts.Query()
+	query.Set("sig", media.Sign(h.Secret, image.ID, opts))
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"url": fmt.Sprintf("/api/images/%d?%s", image.ID, query.Encode())}, "error": nil})
+}
+
+// ServeImage renders a resized or converted product image. Parameters must
+// be signed so the endpoint can't be used to render arbitrary sizes.
+// Derivatives are cached on disk and never change for a given URL, so
+// clients may cache them for a year.
+func (h *ImageVariantHandler) ServeImage(c *gin.Context) {
+	imageID, err := strconv.ParseUint(c.Param("image_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	opts, err := media.ParseOptions(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(h.Secret) == 0 || media.Verify(h.Secret, uint(imageID), opts, c.Query("sig")) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid image signature"})
+		return
+	}
+
+	var image models.ProductImage
+	if err := h.DB.Joins("JOIN products ON products.id = product_images.product_id AND products.deleted_at IS NULL").
+		Where("product_images.blob_key <> ''").First(&image, imageID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
+
+	contentType := image.ContentType
+	if opts.Format != "" {
+		contentType = media.OutputTypes[opts.Format]
+	}
+
+	key := h.Cache.Key(image.BlobKey, opts)
+	etag := `"` + key + `"`
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", etag)
+
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, hit, err := h.Cache.Get(key)
+	if err != nil {
+		log.Printf("failed to read image cache %s: %v", key, err)
+	}
+	if hit {
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+
+	blob, err := h.Store.Get(c.Request.Context(), image.BlobKey)
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch image"})
+		return
+	}
+	defer blob.Close()
+
+	data, contentType, err = media.Transform(blob, image.ContentType, opts)
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		switch {
+		case errors.Is(err, media.ErrWebPUnsupported):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		case errors.Is(err, media.ErrInvalidOptions):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render image"})
+		}
+		return
+	}
+
+	if err := h.Cache.Put(key, data); err != nil {
+		log.Printf("failed to write image cache %s: %v", key, err)
+	}
+
+	c.Data(http.StatusOK, contentType, data)
+}
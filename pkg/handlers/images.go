@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/blanc42/ecms/pkg/feeds"
+	"github.com/blanc42/ecms/pkg/media"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ProductImageHandler struct {
+	DB             *gorm.DB
+	Store          storage.BlobStore
+	MaxUploadBytes int64
+}
+
+func NewProductImageHandler(db *gorm.DB, store storage.BlobStore, maxUploadBytes int64) *ProductImageHandler {
+	return &ProductImageHandler{DB: db, Store: store, MaxUploadBytes: maxUploadBytes}
+}
+
+type UpdateProductImageInput struct {
+	AltText   *string `json:"alt_text" binding:"omitempty,max=255"`
+	IsPrimary *bool   `json:"is_primary"`
+}
+
+type ReorderProductImagesInput struct {
+	ImageIDs []uint `json:"image_ids" binding:"required,min=1"`
+}
+
+// UploadImage stores a multipart "file" upload and adds it to the end of
+// the product's images. The first image of a product becomes its primary
+// image, as does any upload sent with is_primary=true.
+func (h *ProductImageHandler) UploadImage(c *gin.Context) {
+	product, ok := getStoreProduct(c, h.DB)
+	if !ok {
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.MaxUploadBytes+1<<20)
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An image file is required"})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.MaxUploadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return
+	}
+
+	upload, err := media.Inspect(file)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+		return
+	}
+
+	key := media.ProductImageKey(product.ID, upload.ContentType)
+	if err := h.Store.Put(c.Request.Context(), key, file, header.Size, upload.ContentType); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to store image"})
+		return
+	}
+
+	isPrimary, _ := strconv.ParseBool(c.PostForm("is_primary"))
+	image := models.ProductImage{
+		ProductID:   product.ID,
+		ImageURL:    h.Store.URL(key),
+		BlobKey:     key,
+		ContentType: upload.ContentType,
+		SizeBytes:   header.Size,
+		Width:       upload.Width,
+		Height:      upload.Height,
+		AltText:     c.PostForm("alt_text"),
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var stats struct {
+			Count       int64
+			MaxPosition int
+		}
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).
+			Select("COUNT(*) AS count, COALESCE(MAX(position), -1) AS max_position").Scan(&stats).Error; err != nil {
+			return err
+		}
+
+		image.Position = stats.MaxPosition + 1
+		image.IsPrimary = isPrimary || stats.Count == 0
+		if image.IsPrimary {
+			if err := clearPrimaryImage(tx, product.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&image).Error; err != nil {
+			return err
+		}
+		return feeds.Touch(tx, product.StoreID)
+	})
+
+	if err != nil {
+		// The blob has no row yet, don't leave it for the sweep
+		if delErr := h.Store.Delete(c.Request.Context(), key); delErr != nil {
+			log.Printf("failed to delete blob %s: %v", key, delErr)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": image, "error": nil})
+}
+
+func (h *ProductImageHandler) ListImages(c *gin.Context) {
+	product, ok := getStoreProduct(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var images []models.ProductImage
+	if err := h.DB.Where("product_id = ?", product.ID).Order("position, id").Find(&images).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": images, "error": nil})
+}
+
+func (h *ProductImageHandler) UpdateImage(c *gin.Context) {
+	image, ok := getProductImage(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input UpdateProductImageInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if input.AltText != nil {
+			image.AltText = *input.AltText
+		}
+		if input.IsPrimary != nil {
+			if *input.IsPrimary && !image.IsPrimary {
+				if err := clearPrimaryImage(tx, image.ProductID); err != nil {
+					return err
+				}
+			}
+			image.IsPrimary = *input.IsPrimary
+		}
+		if err := tx.Save(image).Error; err != nil {
+			return err
+		}
+		return feeds.TouchProduct(tx, image.ProductID)
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": image, "error": nil})
+}
+
+// ReorderImages sets the display order of a product's images. image_ids
+// must list every image of the product exactly once.
+func (h *ProductImageHandler) ReorderImages(c *gin.Context) {
+	product, ok := getStoreProduct(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input ReorderProductImagesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ids []uint
+	if err := h.DB.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Pluck("id", &ids).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+
+	existing := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		existing[id] = true
+	}
+	seen := make(map[uint]bool, len(input.ImageIDs))
+	for _, id := range input.ImageIDs {
+		if !existing[id] || seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must list every image of the product once"})
+			return
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(existing) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must list every image of the product once"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for position, id := range input.ImageIDs {
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return feeds.Touch(tx, product.StoreID)
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+		return
+	}
+
+	var images []models.ProductImage
+	h.DB.Where("product_id = ?", product.ID).Order("position, id").Find(&images)
+
+	c.JSON(http.StatusOK, gin.H{"data": images, "error": nil})
+}
+
+// DeleteImage removes an image and its blob. When the primary image goes,
+// the next image in order takes its place.
+func (h *ProductImageHandler) DeleteImage(c *gin.Context) {
+	image, ok := getProductImage(c, h.DB)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(image).Error; err != nil {
+			return err
+		}
+		if err := feeds.TouchProduct(tx, image.ProductID); err != nil {
+			return err
+		}
+		if !image.IsPrimary {
+			return nil
+		}
+
+		var next models.ProductImage
+		err := tx.Where("product_id = ?", image.ProductID).Order("position, id").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&next).Update("is_primary", true).Error
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+		return
+	}
+
+	// A blob left behind here is picked up by the orphan sweep
+	if image.BlobKey != "" {
+		if err := h.Store.Delete(c.Request.Context(), image.BlobKey); err != nil {
+			log.Printf("failed to delete blob %s: %v", image.BlobKey, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+// getStoreProduct loads the product_id product of a store owned by the admin
+func getStoreProduct(c *gin.Context, db *gorm.DB) (*models.Product, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var product models.Product
+	if err := db.Where("store_id = ?", store.ID).First(&product, c.Param("product_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return nil, false
+	}
+
+	return &product, true
+}
+
+// getProductImage loads the image_id image of a product owned by the admin
+func getProductImage(c *gin.Context, db *gorm.DB) (*models.ProductImage, bool) {
+	product, ok := getStoreProduct(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var image models.ProductImage
+	if err := db.Where("product_id = ?", product.ID).First(&image, c.Param("image_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return nil, false
+	}
+
+	return &image, true
+}
+
+func clearPrimaryImage(tx *gorm.DB, productID uint) error {
+	return tx.Model(&models.ProductImage{}).
+		Where("product_id = ? AND is_primary = ?", productID, true).
+		Update("is_primary", false).Error
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type InventoryHandler struct {
+	DB *gorm.DB
+}
+
+func NewInventoryHandler(db *gorm.DB) *InventoryHandler {
+	return &InventoryHandler{DB: db}
+}
+
+type StockMovementInput struct {
+	Type       string `json:"type" binding:"required,oneof=receipt sale return adjustment"`
+	Quantity   int    `json:"quantity" binding:"required,ne=0"`
+	Reason     string `json:"reason" binding:"required"`
+	Reference  string `json:"reference"`
+	LocationID *uint  `json:"location_id"`
+}
+
+var movementListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"type":              {Column: "stock_movements.type", Kind: listing.String},
+		"quantity":          {Column: "stock_movements.quantity", Kind: listing.Int},
+		"stock_location_id": {Column: "stock_movements.stock_location_id", Kind: listing.Int},
+		"actor_type":        {Column: "stock_movements.actor_type", Kind: listing.String},
+		"reference":         {Column: "stock_movements.reference", Kind: listing.String},
+		"created_at":        {Column: "stock_movements.created_at", Kind: listing.Time},
+	},
+	Sorts: map[string]string{
+		"id":         "stock_movements.id",
+		"quantity":   "stock_movements.quantity",
+		"created_at": "stock_movements.created_at",
+	},
+	DefaultSort: "-id",
+	Key:         "stock_movements.id",
+}
+
+// ListMovements returns the movement history of a SKU, newest first
+func (h *InventoryHandler) ListMovements(c *gin.Context) {
+	item, ok := getStoreProductItem(c, h.DB)
+	if !ok {
+		return
+	}
+
+	list, ok := parseList(c, movementListSpec)
+	if !ok {
+		return
+	}
+
+	var movements []models.StockMovement
+	page, err := list.Find(h.DB.Where("product_item_id = ?", item.ID), &movements)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": movements, "meta": page, "error": nil})
+}
+
+func (h *InventoryHandler) CreateMovement(c *gin.Context) {
+	item, ok := getStoreProductItem(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input StockMovementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Receipts and returns only ever add stock, sales only take it away
+	switch {
+	case (input.Type == models.MovementReceipt || input.Type == models.MovementReturn) && input.Quantity < 0,
+		input.Type == models.MovementSale && input.Quantity > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity sign does not match the movement type"})
+		return
+	}
+
+	if input.LocationID != nil {
+		var location models.StockLocation
+		if err := h.DB.Where("store_id = ?", c.Param("store_id")).First(&location, *input.LocationID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+			return
+		}
+	}
+
+	adminID := c.MustGet("admin_id").(uint)
+
+	var movement *models.StockMovement
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		movement, err = inventory.Record(tx, inventory.Movement{
+			ProductItemID: item.ID,
+			LocationID:    input.LocationID,
+			Type:          input.Type,
+			Quantity:      input.Quantity,
+			Reason:        input.Reason,
+			ActorType:     models.ActorAdmin,
+			ActorID:       &adminID,
+			Reference:     input.Reference,
+		})
+		return err
+	})
+
+	if errors.Is(err, inventory.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Movement would make stock negative"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record stock movement"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": movement, "error": nil})
+}
+
+// Reconcile compares a SKU's quantity with its ledger and makes them agree.
+// The ledger wins, except for SKUs that predate it: those get an opening
+// balance adjustment for their current quantity.
+func (h *InventoryHandler) Reconcile(c *gin.Context) {
+	item, ok := getStoreProductItem(c, h.DB)
+	if !ok {
+		return
+	}
+
+	adminID := c.MustGet("admin_id").(uint)
+
+	var before, ledger int
+	var movement *models.StockMovement
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(item, item.ID).Error; err != nil {
+			return err
+		}
+		before = item.Quantity
+
+		total, count, err := inventory.LedgerBalance(tx, item.ID)
+		if err != nil {
+			return err
+		}
+		ledger = total
+
+		if count == 0 && item.Quantity != 0 {
+			// Book the opening balance from zero so the ledger sums up
+			if err := tx.Model(item).Update("quantity", 0).Error; err != nil {
+				return err
+			}
+			movement, err = inventory.Record(tx, inventory.Movement{
+				ProductItemID: item.ID,
+				Type:          models.MovementAdjustment,
+				Quantity:      before,
+				Reason:        "Opening balance",
+				ActorType:     models.ActorAdmin,
+				ActorID:       &adminID,
+			})
+			ledger = before
+			return err
+		}
+
+		return tx.Model(item).Update("quantity", total).Error
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"product_item_id":  item.ID,
+		"quantity_before":  before,
+		"ledger_quantity":  ledger,
+		"discrepancy":      before - ledger,
+		"opening_movement": movement,
+	}, "error": nil})
+}
+
+// getStoreProductItem loads the SKU named by the item_id param, checking
+// that it belongs to the product_id product of a store owned by the admin
+func getStoreProductItem(c *gin.Context, db *gorm.DB) (*models.ProductItem, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var item models.ProductItem
+	err := db.Joins("JOIN products ON products.id = product_items.product_id AND products.deleted_at IS NULL").
+		Where("products.store_id = ? AND products.id = ?", store.ID, c.Param("product_id")).
+		First(&item, c.Param("item_id")).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product item not found"})
+		return nil, false
+	}
+
+	return &item, true
+}
+
+type LowStockRow struct {
+	ProductItemID uint     `json:"product_item_id"`
+	SKU           string   `json:"sku"`
+	ProductID     uint     `json:"product_id"`
+	ProductName   string   `json:"product_name"`
+	Quantity      int      `json:"quantity"`
+	Threshold     int      `json:"threshold"`
+	Sold          int      `json:"sold"`
+	DailyVelocity float64  `json:"daily_velocity"`
+	DaysOfCover   *float64 `json:"days_of_cover"`
+}
+
+// LowStockReport lists every SKU at or below its reorder threshold with how
+// fast it sold over the last `days` days
+func (h *InventoryHandler) LowStockReport(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be a positive number"})
+		return
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	var rows []LowStockRow
+	err = h.DB.Raw(`
+		SELECT pi.id AS product_item_id, pi.sku, p.id AS product_id, p.name AS product_name, pi.quantity,
+			COALESCE(pi.reorder_threshold, s.default_reorder_threshold) AS threshold,
+			COALESCE(sales.sold, 0) AS sold
+		FROM product_items pi
+		JOIN products p ON p.id = pi.product_id AND p.deleted_at IS NULL
+		JOIN stores s ON s.id = p.store_id
+		LEFT JOIN (
+			SELECT product_item_id, -SUM(quantity) AS sold
+			FROM stock_movements
+			WHERE type = ? AND created_at >= ? AND deleted_at IS NULL
+			GROUP BY product_item_id
+		) sales ON sales.product_item_id = pi.id
+		WHERE p.store_id = ? AND pi.deleted_at IS NULL
+			AND pi.quantity <= COALESCE(pi.reorder_threshold, s.default_reorder_threshold)
+		ORDER BY pi.quantity - COALESCE(pi.reorder_threshold, s.default_reorder_threshold), pi.id
+	`, models.MovementSale, since, store.ID).Scan(&rows).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build low stock report"})
+		return
+	}
+
+	for i := range rows {
+		rows[i].DailyVelocity = float64(rows[i].Sold) / float64(days)
+		if rows[i].DailyVelocity > 0 {
+			cover := float64(rows[i].Quantity) / rows[i].DailyVelocity
+			rows[i].DaysOfCover = &cover
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rows, "error": nil})
+}
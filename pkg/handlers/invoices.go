@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/invoices"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type InvoiceHandler struct {
+	DB *gorm.DB
+}
+
+func NewInvoiceHandler(db *gorm.DB) *InvoiceHandler {
+	return &InvoiceHandler{DB: db}
+}
+
+// ListOrderInvoices lists the invoice and credit notes of an order
+func (h *InvoiceHandler) ListOrderInvoices(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var docs []models.Invoice
+	if err := h.DB.Where("order_id = ?", order.ID).Preload("Lines").Order("id").Find(&docs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": docs, "error": nil})
+}
+
+func (h *InvoiceHandler) DownloadInvoice(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var invoice models.Invoice
+	if err := h.DB.Where("store_id = ?", store.ID).Preload("Lines").First(&invoice, c.Param("invoice_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+
+	h.render(c, *store, invoice)
+}
+
+// ListCustomerInvoices lists the invoices of the logged in customer's order
+func (h *InvoiceHandler) ListCustomerInvoices(c *gin.Context) {
+	customerID, _ := c.Get("customer_id")
+
+	var order models.Order
+	if err := h.DB.Where("store_id = ? AND customer_id = ?", c.Param("store_id"), customerID).First(&order, c.Param("order_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	var docs []models.Invoice
+	if err := h.DB.Where("order_id = ?", order.ID).Preload("Lines").Order("id").Find(&docs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": docs, "error": nil})
+}
+
+// DownloadCustomerInvoice serves an invoice only to the customer who placed the order
+func (h *InvoiceHandler) DownloadCustomerInvoice(c *gin.Context) {
+	customerID, _ := c.Get("customer_id")
+
+	var invoice models.Invoice
+	err := h.DB.Joins("JOIN orders ON orders.id = invoices.order_id").
+		Where("invoices.store_id = ? AND orders.customer_id = ?", c.Param("store_id"), customerID).
+		Preload("Lines").First(&invoice, c.Param("invoice_id")).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+
+	var store models.Store
+	if err := h.DB.First(&store, invoice.StoreID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return
+	}
+
+	h.render(c, store, invoice)
+}
+
+func (h *InvoiceHandler) render(c *gin.Context, store models.Store, invoice models.Invoice) {
+	var order models.Order
+	if err := h.DB.Preload("Customer").First(&order, invoice.OrderID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return
+	}
+
+	data := invoices.Data{
+		Title:       "Tax Invoice",
+		Store:       store,
+		Invoice:     invoice,
+		OrderNumber: order.OrderNumber,
+	}
+	if order.Customer != nil {
+		data.CustomerName = order.Customer.Username
+		data.CustomerEmail = order.Customer.Email
+	}
+	if invoice.Type == models.InvoiceTypeCreditNote {
+		data.Title = "Credit Note"
+		if invoice.RelatedInvoiceID != nil {
+			var related models.Invoice
+			if err := h.DB.First(&related, *invoice.RelatedInvoiceID).Error; err == nil {
+				data.RelatedNumber = related.Number
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := invoices.Render(&buf, data); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, invoice.Number))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type JobHandler struct {
+	DB *gorm.DB
+}
+
+func NewJobHandler(db *gorm.DB) *JobHandler {
+	return &JobHandler{DB: db}
+}
+
+var jobListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"type":       {Column: "jobs.type", Kind: listing.String},
+		"status":     {Column: "jobs.status", Kind: listing.String},
+		"created_at": {Column: "jobs.created_at", Kind: listing.Time},
+	},
+	Sorts: map[string]string{
+		"id":         "jobs.id",
+		"created_at": "jobs.created_at",
+	},
+	DefaultSort: "-id",
+	Key:         "jobs.id",
+}
+
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	list, ok := parseList(c, jobListSpec)
+	if !ok {
+		return
+	}
+
+	var jobs []models.Job
+	page, err := list.Find(h.DB.Where("store_id = ?", store.ID), &jobs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": jobs, "meta": page, "error": nil})
+}
+
+// GetJob is polled for a background job's progress and result
+func (h *JobHandler) GetJob(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var job models.Job
+	if err := h.DB.Where("store_id = ?", store.ID).First(&job, c.Param("job_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": job, "error": nil})
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/gin-gonic/gin"
+)
+
+// parseList reads the pagination, filter and sort parameters of a list
+// request. It writes a 400 itself when they're invalid.
+func parseList(c *gin.Context, spec listing.Spec) (*listing.Query, bool) {
+	query, err := listing.Parse(c.Request.URL.Query(), spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return query, true
+}
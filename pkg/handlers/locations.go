@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type LocationHandler struct {
+	DB *gorm.DB
+}
+
+func NewLocationHandler(db *gorm.DB) *LocationHandler {
+	return &LocationHandler{DB: db}
+}
+
+var locationListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"code":      {Column: "stock_locations.code", Kind: listing.String},
+		"is_active": {Column: "stock_locations.is_active", Kind: listing.Bool},
+	},
+	Sorts: map[string]string{
+		"id":       "stock_locations.id",
+		"name":     "stock_locations.name",
+		"priority": "stock_locations.priority",
+	},
+	DefaultSort: "-priority",
+	Key:         "stock_locations.id",
+}
+
+type StockLocationInput struct {
+	Name      string `json:"name" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+	Priority  int    `json:"priority"`
+	IsActive  *bool  `json:"is_active"`
+	City      string `json:"city"`
+	Pincode   string `json:"pincode"`
+	CountryID *uint  `json:"country_id"`
+}
+
+type StockTransferInput struct {
+	ProductItemID  uint   `json:"product_item_id" binding:"required"`
+	FromLocationID uint   `json:"from_location_id" binding:"required"`
+	ToLocationID   uint   `json:"to_location_id" binding:"required,nefield=FromLocationID"`
+	Quantity       int    `json:"quantity" binding:"required,gt=0"`
+	Reason         string `json:"reason"`
+}
+
+type AllocateOrderInput struct {
+	Strategy  string `json:"strategy" binding:"omitempty,oneof=priority proximity"`
+	AddressID *uint  `json:"address_id"`
+}
+
+func (h *LocationHandler) CreateLocation(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input StockLocationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	location := models.StockLocation{StoreID: store.ID}
+	applyLocationInput(&location, input)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&models.StockLocation{}).Where("store_id = ?", store.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&location).Error; err != nil {
+			return err
+		}
+		// The store's stock so far is at its first location
+		if existing == 0 {
+			return inventory.AssignUnlocated(tx, store.ID, location.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create location"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": location, "error": nil})
+}
+
+func (h *LocationHandler) ListLocations(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query, ok := parseList(c, locationListSpec)
+	if !ok {
+		return
+	}
+
+	var locations []models.StockLocation
+	page, err := query.Find(h.DB.Where("store_id = ?", store.ID), &locations)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": locations, "meta": page, "error": nil})
+}
+
+func (h *LocationHandler) UpdateLocation(c *gin.Context) {
+	location, ok := h.getLocation(c)
+	if !ok {
+		return
+	}
+
+	var input StockLocationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	applyLocationInput(location, input)
+	if err := h.DB.Save(location).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": location, "error": nil})
+}
+
+// DeleteLocation only removes locations that no longer hold stock
+func (h *LocationHandler) DeleteLocation(c *gin.Context) {
+	location, ok := h.getLocation(c)
+	if !ok {
+		return
+	}
+
+	var stocked int64
+	if err := h.DB.Model(&models.StockLevel{}).Where("stock_location_id = ? AND quantity > 0", location.ID).Count(&stocked).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check location stock"})
+		return
+	}
+	if stocked > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Location still holds stock, transfer it first"})
+		return
+	}
+
+	if err := h.DB.Delete(location).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Location deleted successfully"})
+}
+
+func (h *LocationHandler) ListLocationStock(c *gin.Context) {
+	location, ok := h.getLocation(c)
+	if !ok {
+		return
+	}
+
+	var levels []models.StockLevel
+	if err := h.DB.Where("stock_location_id = ?", location.ID).Order("product_item_id").Find(&levels).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock levels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": levels, "error": nil})
+}
+
+func (h *LocationHandler) TransferStock(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input StockTransferInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	h.DB.Model(&models.StockLocation{}).Where("store_id = ? AND id IN ?", store.ID, []uint{input.FromLocationID, input.ToLocationID}).Count(&count)
+	if count != 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	var item models.ProductItem
+	if err := h.DB.Joins("JOIN products ON products.id = product_items.product_id").
+		Where("products.store_id = ?", store.ID).First(&item, input.ProductItemID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product item not found"})
+		return
+	}
+
+	reason := input.Reason
+	if reason == "" {
+		reason = "Stock transfer"
+	}
+	adminID := c.MustGet("admin_id").(uint)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return inventory.Transfer(tx, item.ID, input.FromLocationID, input.ToLocationID, input.Quantity, inventory.Movement{
+			Reason:    reason,
+			ActorType: models.ActorAdmin,
+			ActorID:   &adminID,
+		})
+	})
+
+	if errors.Is(err, inventory.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock at the source location"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer stock"})
+		return
+	}
+
+	var levels []models.StockLevel
+	h.DB.Where("product_item_id = ? AND stock_location_id IN ?", item.ID, []uint{input.FromLocationID, input.ToLocationID}).Find(&levels)
+
+	c.JSON(http.StatusOK, gin.H{"data": levels, "error": nil})
+}
+
+// AllocateOrder decides which locations ship an order and takes the stock
+func (h *LocationHandler) AllocateOrder(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input AllocateOrderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	strategy := input.Strategy
+	if strategy == "" {
+		strategy = models.AllocateByPriority
+	}
+
+	var address *models.Address
+	if input.AddressID != nil {
+		address = &models.Address{}
+		if err := h.DB.First(address, *input.AddressID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+			return
+		}
+	}
+	if strategy == models.AllocateByProximity && address == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "address_id is required for proximity allocation"})
+		return
+	}
+
+	var allocations []models.OrderAllocation
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		allocations, err = inventory.Allocate(tx, *order, strategy, address)
+		return err
+	})
+
+	if errors.Is(err, inventory.ErrAlreadyAllocated) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, inventory.ErrInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate order"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": allocations, "error": nil})
+}
+
+func (h *LocationHandler) ListAllocations(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var allocations []models.OrderAllocation
+	if err := h.DB.Joins("JOIN order_items ON order_items.id = order_allocations.order_item_id").
+		Where("order_items.order_id = ?", order.ID).Preload("StockLocation").
+		Order("order_allocations.id").Find(&allocations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch allocations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": allocations, "error": nil})
+}
+
+func (h *LocationHandler) getLocation(c *gin.Context) (*models.StockLocation, bool) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return nil, false
+	}
+
+	var location models.StockLocation
+	if err := h.DB.Where("store_id = ?", store.ID).First(&location, c.Param("location_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return nil, false
+	}
+
+	return &location, true
+}
+
+func applyLocationInput(location *models.StockLocation, input StockLocationInput) {
+	location.Name = input.Name
+	location.Code = input.Code
+	location.Priority = input.Priority
+	location.IsActive = input.IsActive == nil || *input.IsActive
+	location.City = input.City
+	location.Pincode = input.Pincode
+	location.CountryID = input.CountryID
+}
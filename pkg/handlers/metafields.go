@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/metafields"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// The records metafields can be kept on
+const (
+	MetafieldsOfStore       = "store"
+	MetafieldsOfCategory    = "category"
+	MetafieldsOfProduct     = "product"
+	MetafieldsOfProductItem = "product_item"
+	MetafieldsOfCustomer    = "customer"
+)
+
+type MetafieldHandler struct {
+	DB *gorm.DB
+}
+
+func NewMetafieldHandler(db *gorm.DB) *MetafieldHandler {
+	return &MetafieldHandler{DB: db}
+}
+
+type MetafieldsInput struct {
+	Metafields []models.Metafield `json:"metafields" binding:"required,min=1,max=100"`
+}
+
+// ListMetafields lists the metafields of an owner, optionally of one
+// ?namespace=
+func (h *MetafieldHandler) ListMetafields(owner string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, fields, ok := getMetafieldOwner(c, h.DB, owner, false)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": metafields.Filter(*fields, c.Query("namespace"), false), "error": nil})
+	}
+}
+
+// SetMetafields creates or replaces metafields of an owner by namespace
+// and key, leaving the others as they are
+func (h *MetafieldHandler) SetMetafields(owner string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		record, fields, ok := getMetafieldOwner(c, h.DB, owner, false)
+		if !ok {
+			return
+		}
+
+		var input MetafieldsInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			// Lock the owner so concurrent writes don't lose each other's fields
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(record).Error; err != nil {
+				return err
+			}
+			updated, err := metafields.Set(*fields, input.Metafields)
+			if err != nil {
+				return err
+			}
+			*fields = updated
+			return tx.Model(record).Update("metafields", updated).Error
+		})
+		if err != nil {
+			var invalid *metafields.Error
+			if errors.As(err, &invalid) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save metafields"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": *fields, "error": nil})
+	}
+}
+
+func (h *MetafieldHandler) DeleteMetafield(owner string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		record, fields, ok := getMetafieldOwner(c, h.DB, owner, false)
+		if !ok {
+			return
+		}
+
+		var found bool
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(record).Error; err != nil {
+				return err
+			}
+			var updated models.Metafields
+			if updated, found = metafields.Remove(*fields, c.Param("namespace"), c.Param("key")); !found {
+				return nil
+			}
+			return tx.Model(record).Update("metafields", updated).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete metafield"})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Metafield not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Metafield deleted successfully"})
+	}
+}
+
+// StorefrontMetafields lists the metafields of an owner that are exposed
+// on the storefront
+func (h *MetafieldHandler) StorefrontMetafields(owner string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, fields, ok := getMetafieldOwner(c, h.DB, owner, true)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": metafields.Filter(*fields, c.Query("namespace"), true), "error": nil})
+	}
+}
+
+// getMetafieldOwner loads the record whose metafields a route is about and
+// points at its metafields. Admins reach the records of their stores, the
+// storefront only what customers can see and a customer only themselves.
+func getMetafieldOwner(c *gin.Context, db *gorm.DB, owner string, storefront bool) (any, *models.Metafields, bool) {
+	var store *models.Store
+	var ok bool
+	if storefront {
+		store, ok = getStorefrontStore(c, db)
+	} else {
+		store, ok = getOwnedStore(c, db)
+	}
+	if !ok {
+		return nil, nil, false
+	}
+
+	switch owner {
+	case MetafieldsOfStore:
+		return store, &store.Metafields, true
+
+	case MetafieldsOfCategory:
+		var category models.Category
+		if err := db.Where("store_id = ?", store.ID).First(&category, c.Param("category_id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return nil, nil, false
+		}
+		return &category, &category.Metafields, true
+
+	case MetafieldsOfProduct:
+		query := db.Where("store_id = ?", store.ID)
+		if storefront {
+			query = query.Where("is_archived = ?", false)
+		}
+		var product models.Product
+		if err := query.First(&product, c.Param("product_id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return nil, nil, false
+		}
+		return &product, &product.Metafields, true
+
+	case MetafieldsOfProductItem:
+		query := db.Joins("JOIN products ON products.id = product_items.product_id AND products.deleted_at IS NULL").
+			Where("products.store_id = ? AND products.id = ?", store.ID, c.Param("product_id"))
+		if storefront {
+			query = query.Where("products.is_archived = ?", false)
+		}
+		var item models.ProductItem
+		if err := query.First(&item, c.Param("item_id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product item not found"})
+			return nil, nil, false
+		}
+		return &item, &item.Metafields, true
+
+	case MetafieldsOfCustomer:
+		var customerID any = c.Param("customer_id")
+		if storefront {
+			customerID, _ = c.Get("customer_id")
+		}
+		var customer models.Customer
+		if customerID == nil || db.Where("store_id = ?", store.ID).First(&customer, customerID).Error != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return nil, nil, false
+		}
+		return &customer, &customer.Metafields, true
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Unknown metafield owner"})
+	return nil, nil, false
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type NotificationHandler struct {
+	DB *gorm.DB
+}
+
+func NewNotificationHandler(db *gorm.DB) *NotificationHandler {
+	return &NotificationHandler{DB: db}
+}
+
+var notificationListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"type":       {Column: "notifications.type", Kind: listing.String},
+		"created_at": {Column: "notifications.created_at", Kind: listing.Time},
+	},
+	Sorts: map[string]string{
+		"id":         "notifications.id",
+		"created_at": "notifications.created_at",
+	},
+	DefaultSort: "-id",
+	Key:         "notifications.id",
+	Params:      []string{"unread"},
+}
+
+// ListNotifications is the store's in-app feed, newest first
+func (h *NotificationHandler) ListNotifications(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	list, ok := parseList(c, notificationListSpec)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Where("store_id = ?", store.ID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []models.Notification
+	page, err := list.Find(query, &notifications, func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Deliveries")
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": notifications, "meta": page, "error": nil})
+}
+
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var notification models.Notification
+	if err := h.DB.Where("store_id = ?", store.ID).First(&notification, c.Param("notification_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if err := h.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": notification, "error": nil})
+}
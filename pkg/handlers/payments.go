@@ -0,0 +1,685 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/invoices"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/notifications"
+	"github.com/blanc42/ecms/pkg/payments"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PaymentHandler struct {
+	DB              *gorm.DB
+	Providers       map[string]payments.Provider
+	DefaultProvider string
+}
+
+func NewPaymentHandler(db *gorm.DB, providers ...payments.Provider) *PaymentHandler {
+	h := &PaymentHandler{DB: db, Providers: make(map[string]payments.Provider)}
+	for _, p := range providers {
+		if h.DefaultProvider == "" {
+			h.DefaultProvider = p.Name()
+		}
+		h.Providers[p.Name()] = p
+	}
+	return h
+}
+
+type CreatePaymentInput struct {
+	Provider      string `json:"provider"`
+	PaymentMethod string `json:"payment_method"`
+	CaptureMethod string `json:"capture_method" binding:"omitempty,oneof=automatic manual"`
+}
+
+type CapturePaymentInput struct {
+	Amount float64 `json:"amount" binding:"omitempty,gt=0"`
+}
+
+type RefundLineInput struct {
+	OrderItemID uint    `json:"order_item_id" binding:"required"`
+	Amount      float64 `json:"amount" binding:"omitempty,gt=0"`
+}
+
+type RefundInput struct {
+	Lines []RefundLineInput `json:"lines" binding:"required,min=1,dive"`
+}
+
+// refundLine is an amount to refund against one order item. An Amount of 0
+// refunds whatever is left on the line.
+type refundLine struct {
+	OrderItemID uint
+	Amount      float64
+}
+
+var errRefundExceedsLine = errors.New("refund exceeds the refundable amount of the line")
+var errRefundExceedsCaptured = errors.New("refund exceeds the captured amount of the order")
+
+var (
+	errOrderCanceled  = errors.New("order is canceled")
+	errPaymentOpen    = errors.New("order has a payment in progress")
+	errOrderPaid      = errors.New("order is already paid")
+	errProviderFailed = errors.New("payment provider failed")
+)
+
+func (h *PaymentHandler) CreatePayment(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	h.createPayment(c, order)
+}
+
+// CreateCustomerPayment lets a customer pay for their own order
+func (h *PaymentHandler) CreateCustomerPayment(c *gin.Context) {
+	customerID, _ := c.Get("customer_id")
+
+	var order models.Order
+	if err := h.DB.Where("store_id = ? AND customer_id = ?", c.Param("store_id"), customerID).First(&order, c.Param("order_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	h.createPayment(c, &order)
+}
+
+func (h *PaymentHandler) createPayment(c *gin.Context, order *models.Order) {
+	var input CreatePaymentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if order.TotalAmount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order has nothing to pay"})
+		return
+	}
+
+	providerName := input.Provider
+	if providerName == "" {
+		providerName = h.DefaultProvider
+	}
+	provider, ok := h.Providers[providerName]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	captureMethod := input.CaptureMethod
+	if captureMethod == "" {
+		captureMethod = payments.CaptureAutomatic
+	}
+
+	var intent *payments.Intent
+	var attempt models.PaymentAttempt
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// The order stays locked until the attempt is recorded, so two
+		// requests can't both start a live intent and charge twice
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(order, order.ID).Error; err != nil {
+			return err
+		}
+		if order.OrderStatus == models.OrderStatusCanceled {
+			return errOrderCanceled
+		}
+		switch order.PaymentStatus {
+		case models.PaymentStatusPaid, models.PaymentStatusPartialRefund, models.PaymentStatusRefunded:
+			return errOrderPaid
+		}
+		if open, err := hasOpenAttempt(tx, order.ID); err != nil || open {
+			return cmp.Or(err, errPaymentOpen)
+		}
+
+		var err error
+		intent, err = provider.CreateIntent(c.Request.Context(), payments.IntentRequest{
+			Amount:        order.TotalAmount,
+			Currency:      order.Currency,
+			Reference:     order.OrderNumber,
+			PaymentMethod: input.PaymentMethod,
+			CaptureMethod: captureMethod,
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %v", errProviderFailed, err)
+		}
+
+		attempt = models.PaymentAttempt{
+			OrderID:          order.ID,
+			Provider:         provider.Name(),
+			ProviderIntentID: intent.ID,
+			Amount:           intent.Amount,
+			CapturedAmount:   intent.CapturedAmount,
+			Currency:         order.Currency,
+			Status:           intent.Status,
+			FailureReason:    intent.FailureReason,
+		}
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+		return syncOrderPaymentStatus(tx, order.ID)
+	})
+	switch {
+	case errors.Is(err, errOrderCanceled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is canceled"})
+		return
+	case errors.Is(err, errOrderPaid):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is already paid"})
+		return
+	case errors.Is(err, errPaymentOpen):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has a payment in progress, complete, capture or wait out that one first"})
+		return
+	case errors.Is(err, errProviderFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create payment with provider"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": gin.H{"attempt": attempt, "intent": intent}, "error": nil})
+}
+
+func (h *PaymentHandler) CapturePayment(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var attempt models.PaymentAttempt
+	if err := h.DB.Where("order_id = ?", order.ID).First(&attempt, c.Param("attempt_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	var input CapturePaymentInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	amount := input.Amount
+	if amount == 0 {
+		amount = attempt.Amount
+	}
+
+	provider, ok := h.Providers[attempt.Provider]
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment provider is not configured"})
+		return
+	}
+
+	intent, err := provider.Capture(c.Request.Context(), attempt.ProviderIntentID, amount)
+	if errors.Is(err, payments.ErrInvalidState) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment cannot be captured"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to capture payment with provider"})
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&attempt, attempt.ID).Error; err != nil {
+			return err
+		}
+		if !payments.CanTransition(attempt.Status, intent.Status) {
+			return nil
+		}
+		attempt.Status = intent.Status
+		attempt.CapturedAmount = intent.CapturedAmount
+		if err := tx.Save(&attempt).Error; err != nil {
+			return err
+		}
+		return syncOrderPaymentStatus(tx, order.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record capture"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attempt, "error": nil})
+}
+
+func (h *PaymentHandler) ListPayments(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var attempts []models.PaymentAttempt
+	if err := h.DB.Where("order_id = ?", order.ID).Order("id").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attempts, "error": nil})
+}
+
+// Webhook ingests signed provider events. Each event is applied at most
+// once; redeliveries are acknowledged without touching the order.
+func (h *PaymentHandler) Webhook(c *gin.Context) {
+	provider, ok := h.Providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown payment provider"})
+		return
+	}
+
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read payload"})
+		return
+	}
+
+	event, err := provider.VerifyWebhook(payload, c.Request.Header)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if event.Status != "" && !payments.ValidStatus(event.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment status " + event.Status})
+		return
+	}
+
+	duplicate, stale := false, false
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		record := models.PaymentWebhookEvent{
+			Provider: provider.Name(),
+			EventID:  event.ID,
+			Type:     event.Type,
+			Payload:  string(payload),
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			duplicate = true
+			return nil
+		}
+
+		var attempt models.PaymentAttempt
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("provider = ? AND provider_intent_id = ?", provider.Name(), event.IntentID).First(&attempt).Error; err != nil {
+			return err
+		}
+
+		// Events can arrive out of order, one moving a final payment
+		// back is acknowledged and ignored
+		if event.Status != "" && !payments.CanTransition(attempt.Status, event.Status) {
+			stale = true
+			log.Printf("payments: ignored %s event %s moving payment %d from %s to %s",
+				provider.Name(), event.ID, attempt.ID, attempt.Status, event.Status)
+			return nil
+		}
+
+		if event.Status != "" {
+			attempt.Status = event.Status
+		}
+		if event.Status == payments.IntentSucceeded {
+			attempt.CapturedAmount = attempt.Amount
+			if event.Amount > 0 {
+				attempt.CapturedAmount = event.Amount
+			}
+		}
+		if event.FailureReason != "" {
+			attempt.FailureReason = event.FailureReason
+		}
+		if err := tx.Save(&attempt).Error; err != nil {
+			return err
+		}
+
+		return syncOrderPaymentStatus(tx, attempt.OrderID)
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"event_id": event.ID, "duplicate": duplicate, "ignored": stale}, "error": nil})
+}
+
+// Refund refunds individual order lines in full or in part
+func (h *PaymentHandler) Refund(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input RefundInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lines := make([]refundLine, 0, len(input.Lines))
+	for _, line := range input.Lines {
+		lines = append(lines, refundLine{OrderItemID: line.OrderItemID, Amount: line.Amount})
+	}
+
+	var refunds []models.PaymentRefund
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		refunds, err = h.refundLines(tx, order.ID, lines, nil)
+		return err
+	})
+	if errors.Is(err, errRefundExceedsLine) || errors.Is(err, errRefundExceedsCaptured) || errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund payment"})
+		return
+	}
+	h.settleRefunds(c.Request.Context(), refunds)
+
+	c.JSON(refundStatus(refunds), gin.H{"data": refunds, "error": nil})
+}
+
+// refundLines books a refund of order lines, drawing on the order's
+// captured payments oldest first. It records a pending refund per payment
+// and leaves asking the providers to settleRefunds once the transaction
+// committed.
+func (h *PaymentHandler) refundLines(tx *gorm.DB, orderID uint, lines []refundLine, returnID *uint) ([]models.PaymentRefund, error) {
+	perLine := make(map[uint]float64)
+	total := 0.0
+
+	for _, line := range lines {
+		var item models.OrderItem
+		if err := tx.Where("order_id = ?", orderID).First(&item, line.OrderItemID).Error; err != nil {
+			return nil, err
+		}
+
+		refundable := item.UnitPrice*float64(item.Quantity) - item.RefundedAmount - perLine[item.ID]
+		amount := line.Amount
+		if amount == 0 {
+			amount = refundable
+		}
+		if amount <= 0 || amount > refundable+0.005 {
+			return nil, fmt.Errorf("order item %d: %w", item.ID, errRefundExceedsLine)
+		}
+
+		perLine[item.ID] += amount
+		total += amount
+	}
+
+	for itemID, amount := range perLine {
+		if err := tx.Model(&models.OrderItem{}).Where("id = ?", itemID).
+			Update("refunded_amount", gorm.Expr("refunded_amount + ?", amount)).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	var attempts []models.PaymentAttempt
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ? AND status = ?", orderID, payments.IntentSucceeded).Order("id").Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var refunds []models.PaymentRefund
+	remaining := total
+	for i := range attempts {
+		if remaining <= 0.005 {
+			break
+		}
+		attempt := &attempts[i]
+		available := attempt.CapturedAmount - attempt.RefundedAmount
+		if available <= 0 {
+			continue
+		}
+		if _, ok := h.Providers[attempt.Provider]; !ok {
+			return nil, fmt.Errorf("payment provider %q is not configured", attempt.Provider)
+		}
+		amount := min(remaining, available)
+
+		record := models.PaymentRefund{
+			PaymentAttemptID: attempt.ID,
+			ReturnRequestID:  returnID,
+			Amount:           amount,
+			Status:           models.RefundPending,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		attempt.RefundedAmount += amount
+		if err := tx.Save(attempt).Error; err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, record)
+		remaining -= amount
+	}
+
+	if remaining > 0.005 {
+		return nil, errRefundExceedsCaptured
+	}
+
+	if err := syncOrderPaymentStatus(tx, orderID); err != nil {
+		return nil, err
+	}
+
+	if _, err := invoices.IssueCreditNote(tx, orderID, perLine); err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// settleRefunds asks the providers to pay out pending refunds. A refund
+// the provider turns down is marked failed and the store is alerted to
+// settle it by hand, one that got no answer stays pending for
+// SweepRefunds to retry.
+func (h *PaymentHandler) settleRefunds(ctx context.Context, refunds []models.PaymentRefund) {
+	for i := range refunds {
+		refund := &refunds[i]
+		if refund.Status != models.RefundPending {
+			continue
+		}
+
+		var attempt models.PaymentAttempt
+		if err := h.DB.First(&attempt, refund.PaymentAttemptID).Error; err != nil {
+			log.Printf("payments: failed to load payment of refund %d: %v", refund.ID, err)
+			continue
+		}
+		provider, ok := h.Providers[attempt.Provider]
+		if !ok {
+			log.Printf("payments: provider %q of refund %d is not configured", attempt.Provider, refund.ID)
+			continue
+		}
+
+		result, err := provider.Refund(ctx, attempt.ProviderIntentID, refund.Amount, fmt.Sprintf("refund-%d", refund.ID))
+		switch {
+		case err == nil:
+			// a refund the provider is still working on is asked about
+			// again by the sweep, under the same key
+			refund.ProviderRefundID = &result.ID
+			switch result.Status {
+			case models.RefundPending:
+			case models.RefundFailed:
+				refund.Status = models.RefundFailed
+				refund.FailureReason = "declined by the payment provider"
+			default:
+				refund.Status = models.RefundSucceeded
+			}
+		case errors.Is(err, payments.ErrInvalidState), errors.Is(err, payments.ErrIntentNotFound):
+			refund.Status = models.RefundFailed
+			refund.FailureReason = err.Error()
+		default:
+			log.Printf("payments: refund %d is still pending: %v", refund.ID, err)
+			continue
+		}
+
+		err = h.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(refund).Error; err != nil {
+				return err
+			}
+			if refund.Status != models.RefundFailed {
+				return nil
+			}
+			var order models.Order
+			if err := tx.First(&order, attempt.OrderID).Error; err != nil {
+				return err
+			}
+			_, err := notifications.Emit(tx, order.StoreID, models.NotificationRefundFailed,
+				fmt.Sprintf("Refund of %.2f %s on order %s failed", refund.Amount, attempt.Currency, order.OrderNumber),
+				"The payment provider turned the refund down, the customer has to be refunded by hand.",
+				gin.H{"order_id": order.ID, "payment_refund_id": refund.ID, "reason": refund.FailureReason})
+			return err
+		})
+		if err != nil {
+			log.Printf("payments: failed to record the outcome of refund %d: %v", refund.ID, err)
+		}
+	}
+}
+
+// SweepRefunds retries refunds left pending, e.g. by a provider timeout,
+// every interval until ctx is done
+func (h *PaymentHandler) SweepRefunds(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var pending []models.PaymentRefund
+			if err := h.DB.Where("status = ? AND created_at < ?", models.RefundPending, time.Now().Add(-interval)).
+				Order("id").Find(&pending).Error; err != nil {
+				log.Printf("payments: failed to look for pending refunds: %v", err)
+				continue
+			}
+			h.settleRefunds(ctx, pending)
+		}
+	}
+}
+
+// refundStatus answers 201 when every refund went through and 202 while
+// some are still pending or failed
+func refundStatus(refunds []models.PaymentRefund) int {
+	for _, refund := range refunds {
+		if refund.Status != models.RefundSucceeded {
+			return http.StatusAccepted
+		}
+	}
+	return http.StatusCreated
+}
+
+// syncOrderPaymentStatus derives Order.PaymentStatus from its payment
+// attempts and confirms the order once it is paid
+func syncOrderPaymentStatus(tx *gorm.DB, orderID uint) error {
+	// Locked so the order can't be canceled while its payment is settled
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+		return err
+	}
+
+	var attempts []models.PaymentAttempt
+	if err := tx.Where("order_id = ?", orderID).Order("id").Find(&attempts).Error; err != nil {
+		return err
+	}
+
+	status := models.PaymentStatusPending
+	for _, attempt := range attempts {
+		switch attempt.Status {
+		case payments.IntentSucceeded:
+			status = models.PaymentStatusPaid
+		case payments.IntentRequiresCapture:
+			if status != models.PaymentStatusPaid {
+				status = models.PaymentStatusAuthorized
+			}
+		case payments.IntentRequiresAction:
+			if status != models.PaymentStatusPaid && status != models.PaymentStatusAuthorized {
+				status = models.PaymentStatusRequiresAction
+			}
+		case payments.IntentFailed, payments.IntentCanceled:
+			if status == models.PaymentStatusPending || status == models.PaymentStatusRequiresAction {
+				status = models.PaymentStatusFailed
+			}
+		}
+	}
+
+	if status == models.PaymentStatusPaid {
+		captured, refunded := 0.0, 0.0
+		for _, attempt := range attempts {
+			captured += attempt.CapturedAmount
+			refunded += attempt.RefundedAmount
+		}
+		if refunded > 0 && refunded >= captured-0.005 {
+			status = models.PaymentStatusRefunded
+		} else if refunded > 0 {
+			status = models.PaymentStatusPartialRefund
+		}
+	}
+
+	if err := tx.Model(&models.Order{}).Where("id = ?", orderID).Update("payment_status", status).Error; err != nil {
+		return err
+	}
+
+	// Paid orders are invoiced and their reserved stock is committed. A
+	// canceled order gave its stock back already; it isn't sold, the money
+	// has to be refunded.
+	if status == models.PaymentStatusPaid && order.OrderStatus != models.OrderStatusCanceled {
+		if _, err := invoices.IssueInvoice(tx, orderID); err != nil {
+			return err
+		}
+		if err := inventory.Commit(tx, order); err != nil {
+			return err
+		}
+		if order.OrderStatus == models.OrderStatusPending {
+			if err := tx.Model(&order).Update("order_status", models.OrderStatusConfirmed).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasOpenAttempt reports whether an order has a payment that hasn't
+// settled yet: one the customer may still complete or an admin may still
+// capture
+func hasOpenAttempt(tx *gorm.DB, orderID uint) (bool, error) {
+	var statuses []string
+	if err := tx.Model(&models.PaymentAttempt{}).Where("order_id = ?", orderID).Pluck("status", &statuses).Error; err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(statuses, func(status string) bool { return !payments.Final(status) }), nil
+}
+
+// getStoreOrder loads the order named by the order_id param from a store
+// owned by the current admin. It writes the error response itself.
+func getStoreOrder(c *gin.Context, db *gorm.DB) (*models.Order, bool) {
+	store, ok := getOwnedStore(c, db)
+	if !ok {
+		return nil, false
+	}
+
+	var order models.Order
+	if err := db.Where("store_id = ?", store.ID).First(&order, c.Param("order_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return nil, false
+	}
+
+	return &order, true
+}
@@ -1,10 +1,22 @@
 package handlers
 
 import (
+	"errors"
+	"maps"
 	"net/http"
 	"strconv"
-
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/attributes"
+	"github.com/blanc42/ecms/pkg/categories"
+	"github.com/blanc42/ecms/pkg/collections"
+	"github.com/blanc42/ecms/pkg/feeds"
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/listing"
 	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/search"
+	"github.com/blanc42/ecms/pkg/slugs"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -18,10 +30,15 @@ func NewProductHandler(db *gorm.DB) *ProductHandler {
 }
 
 type ProductItemInput struct {
-	SKU             string  `json:"sku" binding:"required"`
-	Quantity        int     `json:"quantity" binding:"required,gte=0"`
-	Price           float64 `json:"price" binding:"required,gt=0"`
-	DiscountedPrice float64 `json:"discounted_price,omitempty"`
+	SKU              string     `json:"sku" binding:"required"`
+	Quantity         int        `json:"quantity" binding:"required,gte=0"`
+	Price            float64    `json:"price" binding:"required,gt=0"`
+	DiscountedPrice  float64    `json:"discounted_price,omitempty"`
+	WeightGrams      int        `json:"weight_grams" binding:"gte=0"`
+	ReorderThreshold *int       `json:"reorder_threshold" binding:"omitempty,gte=0"`
+	StockPolicy      string     `json:"stock_policy" binding:"omitempty,oneof=deny backorder preorder"`
+	BackorderLimit   *int       `json:"backorder_limit" binding:"omitempty,gte=0"`
+	ReleaseDate      *time.Time `json:"release_date" binding:"required_if=StockPolicy preorder"`
 }
 
 type CreateProductInput struct {
@@ -34,14 +51,21 @@ type CreateProductInput struct {
 	CategoryID  uint               `json:"category_id" binding:"required"`
 	StoreID     uint               `json:"store_id" binding:"required"`
 	Items       []ProductItemInput `json:"items"`
+	Tags        []string           `json:"tags" binding:"max=50,dive,max=100"`
+	SEOInput
 }
 
 type UpdateProductItemInput struct {
-	ID              *uint   `json:"id"`
-	SKU             string  `json:"sku" binding:"required"`
-	Quantity        int     `json:"quantity" binding:"required,gte=0"`
-	Price           float64 `json:"price" binding:"required,gt=0"`
-	DiscountedPrice float64 `json:"discounted_price,omitempty"`
+	ID               *uint      `json:"id"`
+	SKU              string     `json:"sku" binding:"required"`
+	Quantity         int        `json:"quantity" binding:"required,gte=0"`
+	Price            float64    `json:"price" binding:"required,gt=0"`
+	DiscountedPrice  float64    `json:"discounted_price,omitempty"`
+	WeightGrams      int        `json:"weight_grams" binding:"gte=0"`
+	ReorderThreshold *int       `json:"reorder_threshold" binding:"omitempty,gte=0"`
+	StockPolicy      string     `json:"stock_policy" binding:"omitempty,oneof=deny backorder preorder"`
+	BackorderLimit   *int       `json:"backorder_limit" binding:"omitempty,gte=0"`
+	ReleaseDate      *time.Time `json:"release_date" binding:"required_if=StockPolicy preorder"`
 }
 
 type UpdateProductInput struct {
@@ -53,6 +77,12 @@ type UpdateProductInput struct {
 	HasVariants bool                     `json:"has_variants"`
 	CategoryID  uint                     `json:"category_id"`
 	Items       []UpdateProductItemInput `json:"items"`
+	// Tags replace the product's tags when given
+	Tags []string `json:"tags" binding:"max=50,dive,max=100"`
+	// Attributes are set like SetProductAttributes does, e.g. the required
+	// ones of a new category
+	Attributes map[string]any `json:"attributes"`
+	SEOInput
 }
 
 func (h *ProductHandler) CreateProduct(c *gin.Context) {
@@ -82,39 +112,60 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	}
 
 	product := models.Product{
-		Name:        input.Name,
-		Description: input.Description,
-		Rating:      input.Rating,
-		IsFeatured:  input.IsFeatured,
-		IsArchived:  input.IsArchived,
-		HasVariants: input.HasVariants,
-		CategoryID:  input.CategoryID,
-		StoreID:     input.StoreID,
+		Name:            input.Name,
+		Description:     input.Description,
+		Rating:          input.Rating,
+		IsFeatured:      input.IsFeatured,
+		IsArchived:      input.IsArchived,
+		HasVariants:     input.HasVariants,
+		CategoryID:      input.CategoryID,
+		StoreID:         input.StoreID,
+		SEOTitle:        input.SEOTitle,
+		MetaDescription: input.MetaDescription,
+		CanonicalURL:    input.CanonicalURL,
+		Tags:            collections.Tags(input.Tags),
 	}
 
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if product.Slug, err = slugs.Assign(tx, slugs.Product, product.StoreID, 0, "", input.Slug, product.Name); err != nil {
+			return err
+		}
 		if err := tx.Create(&product).Error; err != nil {
 			return err
 		}
 
 		for _, itemInput := range input.Items {
 			item := models.ProductItem{
-				ProductID:       product.ID,
-				SKU:             itemInput.SKU,
-				Quantity:        itemInput.Quantity,
-				Price:           itemInput.Price,
-				DiscountedPrice: itemInput.DiscountedPrice,
+				ProductID:        product.ID,
+				SKU:              itemInput.SKU,
+				Price:            itemInput.Price,
+				DiscountedPrice:  itemInput.DiscountedPrice,
+				WeightGrams:      itemInput.WeightGrams,
+				ReorderThreshold: itemInput.ReorderThreshold,
+				StockPolicy:      stockPolicy(itemInput.StockPolicy),
+				BackorderLimit:   itemInput.BackorderLimit,
+				ReleaseDate:      itemInput.ReleaseDate,
 			}
 			if err := tx.Create(&item).Error; err != nil {
 				return err
 			}
+			if err := recordInitialStock(tx, item.ID, itemInput.Quantity, adminID.(uint)); err != nil {
+				return err
+			}
 		}
 
-		return nil
+		if err := feeds.Touch(tx, product.StoreID); err != nil {
+			return err
+		}
+		if err := collections.RefreshProducts(tx, product.StoreID, product.ID); err != nil {
+			return err
+		}
+		return search.IndexProducts(tx, product.ID)
 	})
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		slugError(c, err, "Failed to create product")
 		return
 	}
 
@@ -125,11 +176,16 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 	productID := c.Param("product_id")
 	var product models.Product
 
-	if err := h.DB.Preload("Items").First(&product, productID).Error; err != nil {
+	if err := h.DB.Preload("Items.StockLevels.StockLocation").Preload("Images", orderImages).Preload("Attributes.Attribute").First(&product, productID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
+	if err := fillProductAvailability(h.DB, []models.Product{product}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock availability"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": product, "error": nil})
 }
 
@@ -148,6 +204,8 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		return
 	}
 
+	adminID := c.MustGet("admin_id").(uint)
+
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
 		// Update product fields if provided
 		if input.Name != "" {
@@ -160,14 +218,32 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		product.IsFeatured = input.IsFeatured
 		product.IsArchived = input.IsArchived
 		product.HasVariants = input.HasVariants
+		recategorized := input.CategoryID != 0 && input.CategoryID != product.CategoryID
 		if input.CategoryID != 0 {
 			product.CategoryID = input.CategoryID
 		}
+		if input.Tags != nil {
+			product.Tags = collections.Tags(input.Tags)
+		}
+		input.SEOInput.apply(&product.SEOTitle, &product.MetaDescription, &product.CanonicalURL)
+
+		slug, err := slugs.Assign(tx, slugs.Product, product.StoreID, product.ID, product.Slug, input.Slug, product.Name)
+		if err != nil {
+			return err
+		}
+		product.Slug = slug
 
 		if err := tx.Save(&product).Error; err != nil {
 			return err
 		}
 
+		// A new category drops attributes it doesn't have and may require others
+		if recategorized || input.Attributes != nil {
+			if err := attributes.Set(tx, product, input.Attributes); err != nil {
+				return err
+			}
+		}
+
 		// Handle product items
 		for _, itemInput := range input.Items {
 			if itemInput.ID != nil {
@@ -177,37 +253,67 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 					return err
 				}
 				existingItem.SKU = itemInput.SKU
-				existingItem.Quantity = itemInput.Quantity
 				existingItem.Price = itemInput.Price
 				existingItem.DiscountedPrice = itemInput.DiscountedPrice
-				if err := tx.Save(&existingItem).Error; err != nil {
+				existingItem.WeightGrams = itemInput.WeightGrams
+				existingItem.ReorderThreshold = itemInput.ReorderThreshold
+				existingItem.StockPolicy = stockPolicy(itemInput.StockPolicy)
+				existingItem.BackorderLimit = itemInput.BackorderLimit
+				existingItem.ReleaseDate = itemInput.ReleaseDate
+				if err := tx.Omit("quantity").Save(&existingItem).Error; err != nil {
+					return err
+				}
+				// Quantity changes go through the ledger as adjustments
+				if _, err := inventory.SetQuantity(tx, existingItem.ID, itemInput.Quantity, inventory.Movement{
+					Reason:    "Product update",
+					ActorType: models.ActorAdmin,
+					ActorID:   &adminID,
+				}); err != nil {
 					return err
 				}
 			} else {
 				// Create new item
 				newItem := models.ProductItem{
-					ProductID:       product.ID,
-					SKU:             itemInput.SKU,
-					Quantity:        itemInput.Quantity,
-					Price:           itemInput.Price,
-					DiscountedPrice: itemInput.DiscountedPrice,
+					ProductID:        product.ID,
+					SKU:              itemInput.SKU,
+					Price:            itemInput.Price,
+					DiscountedPrice:  itemInput.DiscountedPrice,
+					WeightGrams:      itemInput.WeightGrams,
+					ReorderThreshold: itemInput.ReorderThreshold,
+					StockPolicy:      stockPolicy(itemInput.StockPolicy),
+					BackorderLimit:   itemInput.BackorderLimit,
+					ReleaseDate:      itemInput.ReleaseDate,
 				}
 				if err := tx.Create(&newItem).Error; err != nil {
 					return err
 				}
+				if err := recordInitialStock(tx, newItem.ID, itemInput.Quantity, adminID); err != nil {
+					return err
+				}
 			}
 		}
 
-		return nil
+		if err := feeds.Touch(tx, product.StoreID); err != nil {
+			return err
+		}
+		if err := collections.RefreshProducts(tx, product.StoreID, product.ID); err != nil {
+			return err
+		}
+		return search.IndexProducts(tx, product.ID)
 	})
 
+	var invalid *attributes.Error
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		slugError(c, err, "Failed to update product")
 		return
 	}
 
 	// Fetch the updated product with its items
-	if err := h.DB.Preload("Items").First(&product, productID).Error; err != nil {
+	if err := h.DB.Preload("Items.StockLevels.StockLocation").Preload("Images", orderImages).Preload("Attributes.Attribute").First(&product, productID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated product"})
 		return
 	}
@@ -215,6 +321,46 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": product, "error": nil})
 }
 
+// fillProductAvailability sets the available quantity of every SKU of the products
+func fillProductAvailability(db *gorm.DB, products []models.Product) error {
+	var items []*models.ProductItem
+	for p := range products {
+		for i := range products[p].Items {
+			items = append(items, &products[p].Items[i])
+		}
+	}
+	return inventory.FillAvailable(db, items)
+}
+
+// recordInitialStock books the opening quantity of a new SKU as a receipt
+func recordInitialStock(tx *gorm.DB, productItemID uint, quantity int, adminID uint) error {
+	if quantity == 0 {
+		return nil
+	}
+	_, err := inventory.Record(tx, inventory.Movement{
+		ProductItemID: productItemID,
+		Type:          models.MovementReceipt,
+		Quantity:      quantity,
+		Reason:        "Initial stock",
+		ActorType:     models.ActorAdmin,
+		ActorID:       &adminID,
+	})
+	return err
+}
+
+// orderImages sorts preloaded product images in display order
+func orderImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+// stockPolicy defaults an empty policy to deny
+func stockPolicy(policy string) string {
+	if policy == "" {
+		return models.StockPolicyDeny
+	}
+	return policy
+}
+
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	productID := c.Param("product_id")
 	var product models.Product
@@ -230,12 +376,18 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 			return err
 		}
 
-		// Delete the product
+		// Delete the product and the redirects to it
 		if err := tx.Delete(&product).Error; err != nil {
 			return err
 		}
+		if err := slugs.DeleteRedirects(tx, slugs.Product, []uint{product.ID}); err != nil {
+			return err
+		}
+		if err := collections.RemoveProducts(tx, []uint{product.ID}); err != nil {
+			return err
+		}
 
-		return nil
+		return feeds.Touch(tx, product.StoreID)
 	})
 
 	if err != nil {
@@ -246,21 +398,117 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
 
+// itemPrice is what a product's SKU sells for
+const itemPrice = "CASE WHEN product_items.discounted_price > 0 THEN product_items.discounted_price ELSE product_items.price END"
+
+var productListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"name":         {Column: "products.name", Kind: listing.String},
+		"category_id":  {Column: "products.category_id", Kind: listing.Int},
+		"is_featured":  {Column: "products.is_featured", Kind: listing.Bool},
+		"is_archived":  {Column: "products.is_archived", Kind: listing.Bool},
+		"has_variants": {Column: "products.has_variants", Kind: listing.Bool},
+		"created_at":   {Column: "products.created_at", Kind: listing.Time},
+		"updated_at":   {Column: "products.updated_at", Kind: listing.Time},
+		// A product matches when any of its SKUs does
+		"price": {Column: itemPrice, Kind: listing.Float,
+			Within: "EXISTS (SELECT 1 FROM product_items WHERE product_items.product_id = products.id AND product_items.deleted_at IS NULL AND %s)"},
+		"sku": {Column: "product_items.sku", Kind: listing.String,
+			Within: "EXISTS (SELECT 1 FROM product_items WHERE product_items.product_id = products.id AND product_items.deleted_at IS NULL AND %s)"},
+	},
+	Sorts: map[string]string{
+		"id":         "products.id",
+		"name":       "products.name",
+		"created_at": "products.created_at",
+		"updated_at": "products.updated_at",
+		// The lowest price the product sells for
+		"price": "coalesce((SELECT min(" + itemPrice + ") FROM product_items WHERE product_items.product_id = products.id AND product_items.deleted_at IS NULL), 0)",
+	},
+	DefaultSort: "id",
+	Key:         "products.id",
+	Params:      []string{"include_descendants"},
+}
+
+// withCategorySubtrees is used with include_descendants=true, category_id
+// then also matches the categories below the given ones
+func withCategorySubtrees(spec listing.Spec) listing.Spec {
+	filters := maps.Clone(spec.Filters)
+	// Within is a format string, the join's LIKE pattern needs escaping
+	filters["category_id"] = listing.Field{Column: "ancestor.id", Kind: listing.Int,
+		Within: "products.category_id IN (SELECT category.id FROM " + strings.ReplaceAll(categories.SubtreeJoin, "%", "%%") + " WHERE %s)"}
+	spec.Filters = filters
+	return spec
+}
+
 func (h *ProductHandler) ListProducts(c *gin.Context) {
 	storeID := c.Param("store_id")
+	listProducts(c, h.DB, h.DB.Where("products.store_id = ?", storeID), productListSpec, adminProductPreloads)
+}
+
+// ListCategoryProducts lists the products of a category and of all the
+// categories below it
+func (h *ProductHandler) ListCategoryProducts(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query := categories.Subtree(h.DB.Joins("JOIN categories ON categories.id = products.category_id"), *category)
+	listProducts(c, h.DB, query.Where("products.store_id = ?", category.StoreID), productListSpec, adminProductPreloads)
+}
+
+// StorefrontListProducts lists the products customers can see
+func (h *ProductHandler) StorefrontListProducts(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	listProducts(c, h.DB, h.DB.Where("products.store_id = ? AND products.is_archived = ?", store.ID, false), productListSpec, storefrontProductPreloads)
+}
+
+func adminProductPreloads(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items.StockLevels.StockLocation").Preload("Images", orderImages)
+}
+
+func storefrontProductPreloads(db *gorm.DB) *gorm.DB {
+	return db.Preload("Items").Preload("Images", orderImages)
+}
+
+// listProducts answers with a page of the products query selects, listed
+// the way spec allows. include_descendants=true widens category_id filters
+// to subcategories.
+func listProducts(c *gin.Context, db *gorm.DB, query *gorm.DB, spec listing.Spec, preloads func(*gorm.DB) *gorm.DB) {
 	var products []models.Product
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if value := c.Query("include_descendants"); value != "" {
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "include_descendants must be true or false"})
+			return
+		}
+		if include {
+			spec = withCategorySubtrees(spec)
+		}
+	}
 
-	offset := (page - 1) * pageSize
+	list, ok := parseList(c, spec)
+	if !ok {
+		return
+	}
 
-	if err := h.DB.Where("store_id = ?", storeID).Preload("Items").Offset(offset).Limit(pageSize).Find(&products).Error; err != nil {
+	page, err := list.Find(query, &products, preloads)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": products, "error": nil})
+	if err := fillProductAvailability(db, products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock availability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": products, "meta": page, "error": nil})
 }
 
 
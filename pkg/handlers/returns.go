@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/inventory"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ReturnHandler struct {
+	DB       *gorm.DB
+	Payments *PaymentHandler
+}
+
+func NewReturnHandler(db *gorm.DB, payments *PaymentHandler) *ReturnHandler {
+	return &ReturnHandler{DB: db, Payments: payments}
+}
+
+type ReturnItemInput struct {
+	OrderItemID uint   `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required,gt=0"`
+	Reason      string `json:"reason" binding:"required,oneof=damaged wrong_item not_as_described changed_mind other"`
+	Note        string `json:"note"`
+}
+
+type CreateReturnInput struct {
+	Note  string            `json:"note"`
+	Items []ReturnItemInput `json:"items" binding:"required,min=1,dive"`
+}
+
+type ApproveReturnInput struct {
+	Restock bool `json:"restock"`
+}
+
+type InspectReturnItemInput struct {
+	InspectionState string `json:"inspection_state" binding:"required,oneof=pending passed failed"`
+}
+
+type RefundReturnItemInput struct {
+	ReturnItemID uint    `json:"return_item_id" binding:"required"`
+	Amount       float64 `json:"amount" binding:"omitempty,gt=0"`
+}
+
+type RefundReturnInput struct {
+	Items []RefundReturnItemInput `json:"items" binding:"dive"`
+}
+
+var errReturnExceedsOrdered = errors.New("return quantity exceeds the quantity still returnable")
+var errNothingToRefund = errors.New("nothing is left to refund on this return")
+
+func (h *ReturnHandler) CreateReturn(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input CreateReturnInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request := models.ReturnRequest{
+		OrderID: order.ID,
+		Status:  models.ReturnStatusRequested,
+		Note:    input.Note,
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		requested := make(map[uint]int)
+		for _, itemInput := range input.Items {
+			var orderItem models.OrderItem
+			if err := tx.Where("order_id = ?", order.ID).First(&orderItem, itemInput.OrderItemID).Error; err != nil {
+				return err
+			}
+
+			// Units already in an open or approved return can't be returned again
+			var pending int64
+			if err := tx.Model(&models.ReturnItem{}).
+				Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id AND return_requests.deleted_at IS NULL").
+				Where("return_items.order_item_id = ? AND return_requests.status <> ?", orderItem.ID, models.ReturnStatusRejected).
+				Select("COALESCE(SUM(return_items.quantity), 0)").Scan(&pending).Error; err != nil {
+				return err
+			}
+
+			requested[orderItem.ID] += itemInput.Quantity
+			if int(pending)+requested[orderItem.ID] > orderItem.Quantity {
+				return fmt.Errorf("order item %d: %w", orderItem.ID, errReturnExceedsOrdered)
+			}
+
+			request.Items = append(request.Items, models.ReturnItem{
+				OrderItemID:     orderItem.ID,
+				Quantity:        itemInput.Quantity,
+				Reason:          itemInput.Reason,
+				Note:            itemInput.Note,
+				InspectionState: models.InspectionPending,
+			})
+		}
+
+		return tx.Create(&request).Error
+	})
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order item not found"})
+		return
+	}
+	if errors.Is(err, errReturnExceedsOrdered) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create return"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": request, "error": nil})
+}
+
+func (h *ReturnHandler) ListReturns(c *gin.Context) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var requests []models.ReturnRequest
+	if err := h.DB.Where("order_id = ?", order.ID).Preload("Items").Order("id").Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": requests, "error": nil})
+}
+
+func (h *ReturnHandler) GetReturn(c *gin.Context) {
+	_, request, ok := h.getReturn(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": request, "error": nil})
+}
+
+// ApproveReturn accepts a return and, when asked, puts the returned units
+// back into stock
+func (h *ReturnHandler) ApproveReturn(c *gin.Context) {
+	_, request, ok := h.getReturn(c)
+	if !ok {
+		return
+	}
+
+	if request.Status != models.ReturnStatusRequested {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only requested returns can be approved"})
+		return
+	}
+
+	var input ApproveReturnInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	adminID := c.MustGet("admin_id").(uint)
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range request.Items {
+			var orderItem models.OrderItem
+			if err := tx.First(&orderItem, item.OrderItemID).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&orderItem).Update("returned_quantity", gorm.Expr("returned_quantity + ?", item.Quantity)).Error; err != nil {
+				return err
+			}
+
+			if input.Restock && item.InspectionState != models.InspectionFailed {
+				if _, err := inventory.Record(tx, inventory.Movement{
+					ProductItemID: orderItem.ProductItemID,
+					Type:          models.MovementReturn,
+					Quantity:      item.Quantity,
+					Reason:        "Return approved",
+					ActorType:     models.ActorAdmin,
+					ActorID:       &adminID,
+					Reference:     fmt.Sprintf("return:%d", request.ID),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+		request.Status = models.ReturnStatusApproved
+		request.Restocked = input.Restock
+		return tx.Omit("Items").Save(request).Error
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve return"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": request, "error": nil})
+}
+
+func (h *ReturnHandler) RejectReturn(c *gin.Context) {
+	_, request, ok := h.getReturn(c)
+	if !ok {
+		return
+	}
+
+	if request.Status != models.ReturnStatusRequested {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only requested returns can be rejected"})
+		return
+	}
+
+	if err := h.DB.Model(request).Update("status", models.ReturnStatusRejected).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject return"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": request, "error": nil})
+}
+
+func (h *ReturnHandler) InspectReturnItem(c *gin.Context) {
+	_, request, ok := h.getReturn(c)
+	if !ok {
+		return
+	}
+
+	var input InspectReturnItemInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var item models.ReturnItem
+	if err := h.DB.Where("return_request_id = ?", request.ID).First(&item, c.Param("item_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return item not found"})
+		return
+	}
+
+	if err := h.DB.Model(&item).Update("inspection_state", input.InspectionState).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update inspection state"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": item, "error": nil})
+}
+
+// RefundReturn refunds the lines of an approved return through the payment
+// layer. Without items, every line that didn't fail inspection is refunded
+// in full, or what is left of it after earlier refunds.
+func (h *ReturnHandler) RefundReturn(c *gin.Context) {
+	order, request, ok := h.getReturn(c)
+	if !ok {
+		return
+	}
+
+	if request.Status != models.ReturnStatusApproved {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only approved returns can be refunded"})
+		return
+	}
+
+	var input RefundReturnInput
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	itemsByID := make(map[uint]*models.ReturnItem)
+	for i := range request.Items {
+		itemsByID[request.Items[i].ID] = &request.Items[i]
+	}
+
+	requested := make(map[uint]float64)
+	refundRest := len(input.Items) == 0
+	if refundRest {
+		for _, item := range request.Items {
+			if item.InspectionState != models.InspectionFailed {
+				requested[item.ID] = 0
+			}
+		}
+	}
+	for _, itemInput := range input.Items {
+		if _, ok := itemsByID[itemInput.ReturnItemID]; !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Return item not found"})
+			return
+		}
+		requested[itemInput.ReturnItemID] += itemInput.Amount
+	}
+
+	var refunds []models.PaymentRefund
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// What each returned line is worth, to know what is left to refund
+		orderItemIDs := make([]uint, len(request.Items))
+		for i, item := range request.Items {
+			orderItemIDs[i] = item.OrderItemID
+		}
+		var orderItems []models.OrderItem
+		if err := tx.Where("id IN ?", orderItemIDs).Find(&orderItems).Error; err != nil {
+			return err
+		}
+		unitPrices := make(map[uint]float64, len(orderItems))
+		for _, orderItem := range orderItems {
+			unitPrices[orderItem.ID] = orderItem.UnitPrice
+		}
+
+		var lines []refundLine
+		amounts := make(map[uint]float64)
+		for itemID, amount := range requested {
+			item := itemsByID[itemID]
+
+			refundable := unitPrices[item.OrderItemID]*float64(item.Quantity) - item.RefundedAmount
+			if refundRest && refundable <= 0.005 {
+				continue
+			}
+			if amount == 0 {
+				amount = refundable
+			}
+			if amount <= 0 || amount > refundable+0.005 {
+				return fmt.Errorf("return item %d: %w", item.ID, errRefundExceedsLine)
+			}
+
+			amounts[itemID] = amount
+			lines = append(lines, refundLine{OrderItemID: item.OrderItemID, Amount: amount})
+		}
+
+		// An empty refund would still use up a credit note number
+		if len(lines) == 0 {
+			return errNothingToRefund
+		}
+
+		var err error
+		refunds, err = h.Payments.refundLines(tx, order.ID, lines, &request.ID)
+		if err != nil {
+			return err
+		}
+
+		total := 0.0
+		fullyRefunded := true
+		for i := range request.Items {
+			item := &request.Items[i]
+			item.RefundedAmount += amounts[item.ID]
+			total += amounts[item.ID]
+			if amounts[item.ID] > 0 {
+				if err := tx.Model(item).Update("refunded_amount", item.RefundedAmount).Error; err != nil {
+					return err
+				}
+			}
+			worth := unitPrices[item.OrderItemID] * float64(item.Quantity)
+			if item.InspectionState != models.InspectionFailed && item.RefundedAmount < worth-0.005 {
+				fullyRefunded = false
+			}
+		}
+
+		request.RefundAmount += total
+		if fullyRefunded {
+			request.Status = models.ReturnStatusRefunded
+		}
+		return tx.Omit("Items").Save(request).Error
+	})
+
+	if errors.Is(err, errRefundExceedsLine) || errors.Is(err, errRefundExceedsCaptured) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errNothingToRefund) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund return"})
+		return
+	}
+	h.Payments.settleRefunds(c.Request.Context(), refunds)
+
+	status := http.StatusOK
+	if refundStatus(refunds) == http.StatusAccepted {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, gin.H{"data": gin.H{"return": request, "refunds": refunds}, "error": nil})
+}
+
+func (h *ReturnHandler) getReturn(c *gin.Context) (*models.Order, *models.ReturnRequest, bool) {
+	order, ok := getStoreOrder(c, h.DB)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var request models.ReturnRequest
+	if err := h.DB.Where("order_id = ?", order.ID).Preload("Items").First(&request, c.Param("return_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return nil, nil, false
+	}
+
+	return order, &request, true
+}
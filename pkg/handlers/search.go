@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/blanc42/ecms/pkg/attributes"
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/search"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const suggestLimit = 10
+
+type SearchHandler struct {
+	DB *gorm.DB
+}
+
+func NewSearchHandler(db *gorm.DB) *SearchHandler {
+	return &SearchHandler{DB: db}
+}
+
+type SynonymInput struct {
+	Terms []string `json:"terms" binding:"required,min=2,dive,required,max=255"`
+}
+
+// searchListSpec only pages search results, they are ordered by relevance
+// and filtered by the search's own parameters
+var searchListSpec = listing.Spec{
+	Key:           "products.id",
+	Params:        []string{"q", "category_id", "featured", "archived", "min_price", "max_price"},
+	ParamPrefixes: []string{"attr."},
+}
+
+var synonymListSpec = listing.Spec{
+	Sorts: map[string]string{
+		"id":         "search_synonyms.id",
+		"created_at": "search_synonyms.created_at",
+	},
+	DefaultSort: "id",
+	Key:         "search_synonyms.id",
+}
+
+// SearchResponse is a page of search results
+type SearchResponse struct {
+	Products []models.Product `json:"products"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"page_size"`
+	*search.Result
+}
+
+type Suggestion struct {
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	CategoryID uint   `json:"category_id"`
+}
+
+// SearchProducts searches all of a store's products, archived ones
+// included unless filtered out
+func (h *SearchHandler) SearchProducts(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+	h.search(c, store.ID, false)
+}
+
+// SuggestProducts completes a partially typed query
+func (h *SearchHandler) SuggestProducts(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+	h.suggest(c, store.ID, false)
+}
+
+// StorefrontSearch searches the products a store's customers can see
+func (h *SearchHandler) StorefrontSearch(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+	h.search(c, store.ID, true)
+}
+
+func (h *SearchHandler) StorefrontSuggest(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+	h.suggest(c, store.ID, true)
+}
+
+func (h *SearchHandler) search(c *gin.Context, storeID uint, storefront bool) {
+	filter, ok := searchFilter(c, h.DB, storeID, storefront)
+	if !ok {
+		return
+	}
+
+	list, ok := parseList(c, searchListSpec)
+	if !ok {
+		return
+	}
+	if list.Cursor != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search results are paged by page number"})
+		return
+	}
+
+	result, err := search.Search(h.DB, search.Request{
+		StoreID: storeID,
+		Text:    c.Query("q"),
+		Filter:  filter,
+		Offset:  (list.Page - 1) * list.PageSize,
+		Limit:   list.PageSize,
+		Facets:  true,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+
+	query := h.DB.Preload("Items").Preload("Images", orderImages)
+	if !storefront {
+		query = query.Preload("Items.StockLevels.StockLocation")
+	}
+	products, err := loadProductsInOrder(query, result.ProductIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	if err := fillProductAvailability(h.DB, products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock availability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": SearchResponse{Products: products, Page: list.Page, PageSize: list.PageSize, Result: result}, "error": nil})
+}
+
+func (h *SearchHandler) suggest(c *gin.Context, storeID uint, storefront bool) {
+	filter, ok := searchFilter(c, h.DB, storeID, storefront)
+	if !ok {
+		return
+	}
+
+	suggestions := []Suggestion{}
+	result, err := search.Search(h.DB, search.Request{
+		StoreID: storeID,
+		Text:    c.Query("q"),
+		Prefix:  true,
+		Filter:  filter,
+		Limit:   suggestLimit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		return
+	}
+	if result.Mode == search.ModeBrowse {
+		c.JSON(http.StatusOK, gin.H{"data": suggestions, "error": nil})
+		return
+	}
+
+	products, err := loadProductsInOrder(h.DB, result.ProductIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+	for _, product := range products {
+		suggestions = append(suggestions, Suggestion{ID: product.ID, Name: product.Name, CategoryID: product.CategoryID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": suggestions, "error": nil})
+}
+
+func (h *SearchHandler) ListSynonyms(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	list, ok := parseList(c, synonymListSpec)
+	if !ok {
+		return
+	}
+
+	var synonyms []models.SearchSynonym
+	page, err := list.Find(h.DB.Where("store_id = ?", store.ID), &synonyms)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch synonyms"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": synonyms, "meta": page, "error": nil})
+}
+
+func (h *SearchHandler) CreateSynonym(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input SynonymInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	synonym := models.SearchSynonym{StoreID: store.ID, Terms: input.Terms}
+	if err := h.DB.Create(&synonym).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create synonym"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": synonym, "error": nil})
+}
+
+func (h *SearchHandler) UpdateSynonym(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var synonym models.SearchSynonym
+	if err := h.DB.Where("store_id = ?", store.ID).First(&synonym, c.Param("synonym_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Synonym not found"})
+		return
+	}
+
+	var input SynonymInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	synonym.Terms = input.Terms
+	if err := h.DB.Save(&synonym).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update synonym"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": synonym, "error": nil})
+}
+
+func (h *SearchHandler) DeleteSynonym(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var synonym models.SearchSynonym
+	if err := h.DB.Where("store_id = ?", store.ID).First(&synonym, c.Param("synonym_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Synonym not found"})
+		return
+	}
+
+	if err := h.DB.Delete(&synonym).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete synonym"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Synonym deleted successfully"})
+}
+
+// searchFilter reads the facet filters from the query string, attribute
+// filters included. The storefront never shows archived products.
+func searchFilter(c *gin.Context, db *gorm.DB, storeID uint, storefront bool) (search.Filter, bool) {
+	var filter search.Filter
+	for _, value := range c.QueryArray("category_id") {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category_id"})
+			return filter, false
+		}
+		filter.CategoryIDs = append(filter.CategoryIDs, uint(id))
+	}
+
+	bools := map[string]**bool{"featured": &filter.Featured, "archived": &filter.Archived}
+	for name, field := range bools {
+		if value, ok := c.GetQuery(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return filter, false
+			}
+			*field = &b
+		}
+	}
+
+	floats := map[string]**float64{"min_price": &filter.MinPrice, "max_price": &filter.MaxPrice}
+	for name, field := range floats {
+		if value, ok := c.GetQuery(name); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return filter, false
+			}
+			*field = &f
+		}
+	}
+
+	conditions, err := attributes.ParseFilters(db, storeID, c.Request.URL.Query())
+	if err != nil {
+		var invalid *attributes.Error
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attributes"})
+		}
+		return filter, false
+	}
+	filter.Attributes = conditions
+
+	if storefront {
+		archived := false
+		filter.Archived = &archived
+	}
+	return filter, true
+}
+
+// loadProductsInOrder fetches products by ID, keeping the order of ids
+func loadProductsInOrder(query *gorm.DB, ids []uint) ([]models.Product, error) {
+	products := []models.Product{}
+	if len(ids) == 0 {
+		return products, nil
+	}
+
+	var found []models.Product
+	if err := query.Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.Product, len(found))
+	for _, product := range found {
+		byID[product.ID] = product
+	}
+	for _, id := range ids {
+		if product, ok := byID[id]; ok {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+// getStorefrontStore looks up the store a public storefront route is for
+func getStorefrontStore(c *gin.Context, db *gorm.DB) (*models.Store, bool) {
+	var store models.Store
+	if err := db.First(&store, c.Param("store_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return nil, false
+	}
+	return &store, true
+}
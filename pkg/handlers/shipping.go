@@ -0,0 +1,523 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/blanc42/ecms/pkg/listing"
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/shipping"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var zoneListSpec = listing.Spec{
+	Sorts: map[string]string{
+		"id":       "shipping_zones.id",
+		"name":     "shipping_zones.name",
+		"priority": "shipping_zones.priority",
+	},
+	DefaultSort: "-priority",
+	Key:         "shipping_zones.id",
+}
+
+type ShippingHandler struct {
+	DB       *gorm.DB
+	Carriers map[string]shipping.CarrierRateProvider
+}
+
+func NewShippingHandler(db *gorm.DB) *ShippingHandler {
+	return &ShippingHandler{
+		DB: db,
+		Carriers: map[string]shipping.CarrierRateProvider{
+			"fake": shipping.NewFakeCarrier(),
+		},
+	}
+}
+
+type ShippingZoneInput struct {
+	Name       string   `json:"name" binding:"required"`
+	Priority   int      `json:"priority"`
+	CountryIDs []uint   `json:"country_ids" binding:"required,min=1"`
+	Pincodes   []string `json:"pincodes"`
+}
+
+type ShippingRateInput struct {
+	Min   float64 `json:"min" binding:"gte=0"`
+	Max   float64 `json:"max" binding:"gte=0"`
+	Price float64 `json:"price" binding:"gte=0"`
+}
+
+type ShippingMethodInput struct {
+	Name     string              `json:"name" binding:"required"`
+	Type     string              `json:"type" binding:"required"`
+	IsActive *bool               `json:"is_active"`
+	FlatRate float64             `json:"flat_rate" binding:"gte=0"`
+	FreeOver float64             `json:"free_over" binding:"gte=0"`
+	Carrier  string              `json:"carrier"`
+	Rates    []ShippingRateInput `json:"rates"`
+}
+
+type ShippingPincodesInput struct {
+	Pincodes []string `json:"pincodes"`
+}
+
+type AddressInput struct {
+	City      string `json:"city"`
+	Pincode   string `json:"pincode" binding:"required"`
+	CountryID uint   `json:"country_id" binding:"required"`
+}
+
+type ShippingQuoteInput struct {
+	CartID    uint          `json:"cart_id" binding:"required"`
+	AddressID *uint         `json:"address_id"`
+	Address   *AddressInput `json:"address"`
+}
+
+func (h *ShippingHandler) CreateZone(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input ShippingZoneInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var countries []models.Country
+	if err := h.DB.Find(&countries, input.CountryIDs).Error; err != nil || len(countries) != len(input.CountryIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One or more countries not found"})
+		return
+	}
+
+	zone := models.ShippingZone{
+		Name:      input.Name,
+		StoreID:   store.ID,
+		Priority:  input.Priority,
+		Countries: countries,
+	}
+	for _, pincode := range input.Pincodes {
+		zone.Pincodes = append(zone.Pincodes, models.ShippingZonePincode{Pincode: pincode})
+	}
+
+	if err := h.DB.Create(&zone).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shipping zone"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": zone, "error": nil})
+}
+
+func (h *ShippingHandler) ListZones(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	query, ok := parseList(c, zoneListSpec)
+	if !ok {
+		return
+	}
+
+	var zones []models.ShippingZone
+	page, err := query.Find(h.DB.Where("store_id = ?", store.ID), &zones, preloadZone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shipping zones"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": zones, "meta": page, "error": nil})
+}
+
+func (h *ShippingHandler) GetZone(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": zone, "error": nil})
+}
+
+func (h *ShippingHandler) UpdateZone(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	var input ShippingZoneInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var countries []models.Country
+	if err := h.DB.Find(&countries, input.CountryIDs).Error; err != nil || len(countries) != len(input.CountryIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "One or more countries not found"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		zone.Name = input.Name
+		zone.Priority = input.Priority
+		if err := tx.Omit("Countries", "Pincodes", "Methods").Save(zone).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(zone).Association("Countries").Replace(countries); err != nil {
+			return err
+		}
+		return replacePincodes(tx, zone.ID, input.Pincodes)
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shipping zone"})
+		return
+	}
+
+	zone, ok = h.getZone(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": zone, "error": nil})
+}
+
+func (h *ShippingHandler) DeleteZone(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var methodIDs []uint
+		if err := tx.Model(&models.ShippingMethod{}).Where("shipping_zone_id = ?", zone.ID).Pluck("id", &methodIDs).Error; err != nil {
+			return err
+		}
+		if len(methodIDs) > 0 {
+			if err := tx.Where("shipping_method_id IN ?", methodIDs).Delete(&models.ShippingRate{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("shipping_zone_id = ?", zone.ID).Delete(&models.ShippingMethod{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("shipping_zone_id = ?", zone.ID).Delete(&models.ShippingZonePincode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(zone).Association("Countries").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(zone).Error
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete shipping zone"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipping zone deleted successfully"})
+}
+
+func (h *ShippingHandler) SetZonePincodes(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	var input ShippingPincodesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return replacePincodes(tx, zone.ID, input.Pincodes)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pincodes"})
+		return
+	}
+
+	zone, ok = h.getZone(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": zone, "error": nil})
+}
+
+func (h *ShippingHandler) CreateMethod(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	var input ShippingMethodInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := h.validateMethod(input); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	method := models.ShippingMethod{ShippingZoneID: zone.ID}
+	applyMethodInput(&method, input)
+
+	if err := h.DB.Create(&method).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": method, "error": nil})
+}
+
+func (h *ShippingHandler) UpdateMethod(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	var method models.ShippingMethod
+	if err := h.DB.Where("shipping_zone_id = ?", zone.ID).First(&method, c.Param("method_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+		return
+	}
+
+	var input ShippingMethodInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := h.validateMethod(input); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// Rates are replaced wholesale, tiers have no identity of their own
+		if err := tx.Where("shipping_method_id = ?", method.ID).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		applyMethodInput(&method, input)
+		return tx.Save(&method).Error
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": method, "error": nil})
+}
+
+func (h *ShippingHandler) DeleteMethod(c *gin.Context) {
+	zone, ok := h.getZone(c)
+	if !ok {
+		return
+	}
+
+	var method models.ShippingMethod
+	if err := h.DB.Where("shipping_zone_id = ?", zone.ID).First(&method, c.Param("method_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("shipping_method_id = ?", method.ID).Delete(&models.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&method).Error
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipping method deleted successfully"})
+}
+
+// Quote prices every active method of the first zone, by priority, that
+// serves the destination address and has active methods
+func (h *ShippingHandler) Quote(c *gin.Context) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input ShippingQuoteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var dest shipping.Destination
+	switch {
+	case input.Address != nil:
+		dest = shipping.Destination{CountryID: input.Address.CountryID, City: input.Address.City, Pincode: input.Address.Pincode}
+	case input.AddressID != nil:
+		var address models.Address
+		if err := h.DB.First(&address, *input.AddressID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+			return
+		}
+		dest = shipping.Destination{CountryID: address.CountryID, City: address.City, Pincode: address.Pincode}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either address or address_id is required"})
+		return
+	}
+
+	parcel, ok := h.cartParcel(c, store.ID, input.CartID)
+	if !ok {
+		return
+	}
+
+	var zones []models.ShippingZone
+	if err := h.zoneQuery().Where("store_id = ?", store.ID).Order("priority desc, id").Find(&zones).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch shipping zones"})
+		return
+	}
+
+	for _, zone := range zones {
+		if !shipping.ZoneServes(zone, dest) || !slices.ContainsFunc(zone.Methods, isActiveMethod) {
+			continue
+		}
+
+		quotes := []shipping.Quote{}
+		for _, method := range zone.Methods {
+			if !method.IsActive {
+				continue
+			}
+			amount, err := shipping.Calculate(c.Request.Context(), method, dest, parcel, h.Carriers)
+			if err != nil {
+				// A method that can't price this parcel is simply not offered
+				continue
+			}
+			quotes = append(quotes, shipping.Quote{
+				MethodID: method.ID,
+				Name:     method.Name,
+				Type:     method.Type,
+				Carrier:  method.Carrier,
+				Amount:   amount,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"zone_id": zone.ID, "quotes": quotes}, "error": nil})
+		return
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Destination is not serviceable"})
+}
+
+func (h *ShippingHandler) zoneQuery() *gorm.DB {
+	return preloadZone(h.DB)
+}
+
+func preloadZone(db *gorm.DB) *gorm.DB {
+	return db.Preload("Countries").Preload("Pincodes").Preload("Methods").Preload("Methods.Rates", func(db *gorm.DB) *gorm.DB {
+		return db.Order("min")
+	})
+}
+
+func (h *ShippingHandler) getZone(c *gin.Context) (*models.ShippingZone, bool) {
+	store, ok := getOwnedStore(c, h.DB)
+	if !ok {
+		return nil, false
+	}
+
+	var zone models.ShippingZone
+	if err := h.zoneQuery().Where("store_id = ?", store.ID).First(&zone, c.Param("zone_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping zone not found"})
+		return nil, false
+	}
+
+	return &zone, true
+}
+
+// cartParcel sums the weight and price of a cart that belongs to a customer of the store
+func (h *ShippingHandler) cartParcel(c *gin.Context, storeID, cartID uint) (shipping.Parcel, bool) {
+	var cart models.Cart
+	if err := h.DB.Preload("Customer").Preload("CartItems.ProductItem").First(&cart, cartID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return shipping.Parcel{}, false
+	}
+	if cart.Customer == nil || cart.Customer.StoreID != storeID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+		return shipping.Parcel{}, false
+	}
+
+	var parcel shipping.Parcel
+	for _, item := range cart.CartItems {
+		if item.ProductItem == nil {
+			continue
+		}
+		price := item.ProductItem.Price
+		if item.ProductItem.DiscountedPrice > 0 {
+			price = item.ProductItem.DiscountedPrice
+		}
+		parcel.Subtotal += price * float64(item.Quantity)
+		parcel.WeightGrams += item.ProductItem.WeightGrams * item.Quantity
+	}
+
+	return parcel, true
+}
+
+func (h *ShippingHandler) validateMethod(input ShippingMethodInput) string {
+	if !shipping.ValidMethodType(input.Type) {
+		return "Invalid shipping method type"
+	}
+	switch input.Type {
+	case models.ShippingMethodWeight, models.ShippingMethodPriceTier:
+		if len(input.Rates) == 0 {
+			return "Rates are required for weight and price_tier methods"
+		}
+		if err := shipping.CheckRates(methodRates(input)); err != nil {
+			return "Invalid rates: " + err.Error()
+		}
+	case models.ShippingMethodFreeOver:
+		if input.FreeOver <= 0 {
+			return "free_over must be greater than 0"
+		}
+	case models.ShippingMethodCarrier:
+		if _, ok := h.Carriers[input.Carrier]; !ok {
+			return "Unknown carrier"
+		}
+	}
+	return ""
+}
+
+func applyMethodInput(method *models.ShippingMethod, input ShippingMethodInput) {
+	method.Name = input.Name
+	method.Type = input.Type
+	method.IsActive = input.IsActive == nil || *input.IsActive
+	method.FlatRate = input.FlatRate
+	method.FreeOver = input.FreeOver
+	method.Carrier = input.Carrier
+	method.Rates = methodRates(input)
+}
+
+func methodRates(input ShippingMethodInput) []models.ShippingRate {
+	var rates []models.ShippingRate
+	for _, rate := range input.Rates {
+		rates = append(rates, models.ShippingRate{Min: rate.Min, Max: rate.Max, Price: rate.Price})
+	}
+	return rates
+}
+
+func isActiveMethod(method models.ShippingMethod) bool {
+	return method.IsActive
+}
+
+func replacePincodes(tx *gorm.DB, zoneID uint, pincodes []string) error {
+	if err := tx.Unscoped().Where("shipping_zone_id = ?", zoneID).Delete(&models.ShippingZonePincode{}).Error; err != nil {
+		return err
+	}
+	for _, pincode := range pincodes {
+		if err := tx.Create(&models.ShippingZonePincode{Pincode: pincode, ShippingZoneID: zoneID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/slugs"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SEOInput is the slug and search engine metadata of a product or
+// category. A blank slug keeps the current one, or on creation makes one
+// from the name.
+type SEOInput struct {
+	Slug            string `json:"slug" binding:"max=200"`
+	SEOTitle        string `json:"seo_title" binding:"max=255"`
+	MetaDescription string `json:"meta_description" binding:"max=500"`
+	CanonicalURL    string `json:"canonical_url" binding:"omitempty,url,max=2048"`
+}
+
+// apply copies the fields that were given
+func (input SEOInput) apply(title, description, canonicalURL *string) {
+	if input.SEOTitle != "" {
+		*title = input.SEOTitle
+	}
+	if input.MetaDescription != "" {
+		*description = input.MetaDescription
+	}
+	if input.CanonicalURL != "" {
+		*canonicalURL = input.CanonicalURL
+	}
+}
+
+// StorefrontProductBySlug shows a product by its slug. An old slug
+// answers with a permanent redirect to the current one.
+func (h *ProductHandler) StorefrontProductBySlug(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	id, ok := resolveSlug(c, h.DB, slugs.Product, store.ID, "/products/by-slug/")
+	if !ok {
+		return
+	}
+
+	var product models.Product
+	err := h.DB.Where("is_archived = ?", false).Preload("Items").Preload("Images", orderImages).Preload("Attributes.Attribute").
+		First(&product, id).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if err := fillProductAvailability(h.DB, []models.Product{product}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock availability"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": product, "error": nil})
+}
+
+// StorefrontCategoryBySlug shows a category by its slug, following old
+// slugs like StorefrontProductBySlug
+func (h *CategoryHandler) StorefrontCategoryBySlug(c *gin.Context) {
+	store, ok := getStorefrontStore(c, h.DB)
+	if !ok {
+		return
+	}
+
+	id, ok := resolveSlug(c, h.DB, slugs.Category, store.ID, "/categories/by-slug/")
+	if !ok {
+		return
+	}
+
+	var category models.Category
+	if err := h.DB.First(&category, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": category, "error": nil})
+}
+
+// resolveSlug looks up the slug param. It answers old slugs with a
+// redirect to route plus the current slug, and unknown ones with a 404.
+func resolveSlug(c *gin.Context, db *gorm.DB, kind string, storeID uint, route string) (uint, bool) {
+	id, current, moved, err := slugs.Resolve(db, kind, storeID, c.Param("slug"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up slug"})
+		return 0, false
+	}
+	if id == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return 0, false
+	}
+	if moved {
+		c.Header("Location", fmt.Sprintf("/api/storefront/%d%s%s", storeID, route, current))
+		c.JSON(http.StatusMovedPermanently, gin.H{"error": "Moved permanently", "slug": current})
+		return 0, false
+	}
+	return id, true
+}
+
+// slugError answers with the status a slug problem deserves
+func slugError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, slugs.ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, slugs.ErrTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"cmp"
 	"net/http"
-	"strconv"
 
+	"github.com/blanc42/ecms/pkg/feeds"
+	"github.com/blanc42/ecms/pkg/listing"
 	"github.com/blanc42/ecms/pkg/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -18,8 +20,16 @@ func NewStoreHandler(db *gorm.DB) *StoreHandler {
 }
 
 type CreateStoreInput struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
+	Name               string `json:"name" binding:"required"`
+	Description        string `json:"description"`
+	PostalAddress      string `json:"postal_address"`
+	InvoicePrefix      string `json:"invoice_prefix"`
+	CreditNotePrefix   string `json:"credit_note_prefix"`
+	InvoiceYearlyReset *bool  `json:"invoice_yearly_reset"`
+	ReorderThreshold   *int   `json:"default_reorder_threshold" binding:"omitempty,gte=0"`
+	AlertEmail         string `json:"alert_email" binding:"omitempty,email"`
+	AlertWebhookURL    string `json:"alert_webhook_url" binding:"omitempty,url"`
+	StorefrontURL      string `json:"storefront_url" binding:"omitempty,url"`
 }
 
 func (h *StoreHandler) CreateStore(c *gin.Context) {
@@ -29,11 +39,28 @@ func (h *StoreHandler) CreateStore(c *gin.Context) {
 		return
 	}
 
+	if prefixesClash(models.Store{}, input) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invoice_prefix and credit_note_prefix must differ"})
+		return
+	}
+
 	adminID, _ := c.Get("admin_id")
 	store := models.Store{
-		Name:        input.Name,
-		Description: input.Description,
-		AdminID:     adminID.(uint),
+		Name:             input.Name,
+		Description:      input.Description,
+		PostalAddress:    input.PostalAddress,
+		InvoicePrefix:    input.InvoicePrefix,
+		CreditNotePrefix: input.CreditNotePrefix,
+		AlertEmail:       input.AlertEmail,
+		AlertWebhookURL:  input.AlertWebhookURL,
+		StorefrontURL:    input.StorefrontURL,
+		AdminID:          adminID.(uint),
+	}
+	if input.InvoiceYearlyReset != nil {
+		store.InvoiceYearlyReset = *input.InvoiceYearlyReset
+	}
+	if input.ReorderThreshold != nil {
+		store.DefaultReorderThreshold = *input.ReorderThreshold
 	}
 
 	if err := h.DB.Create(&store).Error; err != nil {
@@ -81,8 +108,31 @@ func (h *StoreHandler) UpdateStore(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if prefixesClash(store, input) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invoice_prefix and credit_note_prefix must differ"})
+		return
+	}
 
-	h.DB.Model(&store).Updates(models.Store{Name: input.Name, Description: input.Description})
+	h.DB.Model(&store).Updates(models.Store{
+		Name:             input.Name,
+		Description:      input.Description,
+		PostalAddress:    input.PostalAddress,
+		InvoicePrefix:    input.InvoicePrefix,
+		CreditNotePrefix: input.CreditNotePrefix,
+		AlertEmail:       input.AlertEmail,
+		AlertWebhookURL:  input.AlertWebhookURL,
+		StorefrontURL:    input.StorefrontURL,
+	})
+	if input.InvoiceYearlyReset != nil {
+		h.DB.Model(&store).Update("invoice_yearly_reset", *input.InvoiceYearlyReset)
+	}
+	if input.ReorderThreshold != nil {
+		h.DB.Model(&store).Update("default_reorder_threshold", *input.ReorderThreshold)
+	}
+	// Feeds link to the storefront
+	if input.StorefrontURL != "" {
+		feeds.Touch(h.DB, store.ID)
+	}
 	c.JSON(http.StatusOK, gin.H{"data": store, "error": nil})
 }
 
@@ -105,16 +155,61 @@ func (h *StoreHandler) DeleteStore(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Store deleted successfully"})
 }
 
+// prefixesClash reports whether invoices and credit notes of a store would
+// end up with the same prefix, and so the same numbers. Prefixes left
+// empty keep the store's, or the defaults for a new store.
+func prefixesClash(store models.Store, input CreateStoreInput) bool {
+	invoice := cmp.Or(input.InvoicePrefix, store.InvoicePrefix, "INV-")
+	creditNote := cmp.Or(input.CreditNotePrefix, store.CreditNotePrefix, "CN-")
+	return invoice == creditNote
+}
+
+var storeListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"name":       {Column: "stores.name", Kind: listing.String},
+		"created_at": {Column: "stores.created_at", Kind: listing.Time},
+	},
+	Sorts: map[string]string{
+		"id":         "stores.id",
+		"name":       "stores.name",
+		"created_at": "stores.created_at",
+	},
+	DefaultSort: "id",
+	Key:         "stores.id",
+}
+
 func (h *StoreHandler) ListStores(c *gin.Context) {
 	var stores []models.Store
 	adminID, _ := c.Get("admin_id")
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	list, ok := parseList(c, storeListSpec)
+	if !ok {
+		return
+	}
+
+	page, err := list.Find(h.DB.Where("admin_id = ?", adminID), &stores)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stores"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stores, "meta": page, "error": nil})
+}
 
-	offset := (page - 1) * pageSize
+// getOwnedStore loads the store named by the store_id param and checks that
+// it belongs to the current admin. It writes the error response itself.
+func getOwnedStore(c *gin.Context, db *gorm.DB) (*models.Store, bool) {
+	var store models.Store
+	if err := db.First(&store, c.Param("store_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return nil, false
+	}
 
-	h.DB.Where("admin_id = ?", adminID).Offset(offset).Limit(pageSize).Find(&stores)
+	adminID, _ := c.Get("admin_id")
+	if store.AdminID != adminID.(uint) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to access this store"})
+		return nil, false
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": stores, "error": nil})
+	return &store, true
 }
@@ -7,9 +7,11 @@ we can add `red` option again and again with a different option id
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"strconv"
 
+	"github.com/blanc42/ecms/pkg/categories"
+	"github.com/blanc42/ecms/pkg/listing"
 	"github.com/blanc42/ecms/pkg/models"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -37,6 +39,13 @@ type CreateVariantInput struct {
 	Options     []VariantOptionInput `json:"options"`
 }
 
+// VariantOverrideInput changes how a category sees an inherited variant:
+// hidden drops it altogether, hidden_option_ids only those options
+type VariantOverrideInput struct {
+	Hidden          bool   `json:"hidden"`
+	HiddenOptionIDs []uint `json:"hidden_option_ids"`
+}
+
 type UpdateVariantOptionInput struct {
 	ID          *uint  `json:"id"`
 	Value       string `json:"value" binding:"required"`
@@ -214,10 +223,13 @@ func (h *VariantHandler) DeleteVariant(c *gin.Context) {
 	}
 
 	err := h.DB.Transaction(func(tx *gorm.DB) error {
-		// Delete associated options
+		// Delete associated options and what subcategories changed about it
 		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.VariantOption{}).Error; err != nil {
 			return err
 		}
+		if err := tx.Unscoped().Where("variant_id = ?", variant.ID).Delete(&models.VariantOverride{}).Error; err != nil {
+			return err
+		}
 
 		// Delete the variant
 		if err := tx.Delete(&variant).Error; err != nil {
@@ -235,19 +247,167 @@ func (h *VariantHandler) DeleteVariant(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted successfully"})
 }
 
+var variantListSpec = listing.Spec{
+	Filters: map[string]listing.Field{
+		"name":       {Column: "variants.name", Kind: listing.String},
+		"weight":     {Column: "variants.weight", Kind: listing.Int},
+		"created_at": {Column: "variants.created_at", Kind: listing.Time},
+	},
+	Sorts: map[string]string{
+		"id":         "variants.id",
+		"name":       "variants.name",
+		"weight":     "variants.weight",
+		"created_at": "variants.created_at",
+	},
+	DefaultSort: "id",
+	Key:         "variants.id",
+}
+
 func (h *VariantHandler) ListVariants(c *gin.Context) {
 	categoryID := c.Param("category_id")
 	var variants []models.Variant
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
-
-	offset := (page - 1) * pageSize
+	list, ok := parseList(c, variantListSpec)
+	if !ok {
+		return
+	}
 
-	if err := h.DB.Where("category_id = ?", categoryID).Preload("Options").Offset(offset).Limit(pageSize).Find(&variants).Error; err != nil {
+	page, err := list.Find(h.DB.Where("category_id = ?", categoryID), &variants, func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Options")
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variants"})
 		return
 	}
 
+	c.JSON(http.StatusOK, gin.H{"data": variants, "meta": page, "error": nil})
+}
+
+// ResolveVariants lists the variants products in a category can use, those
+// inherited from its ancestors included
+func (h *VariantHandler) ResolveVariants(c *gin.Context) {
+	category, ok := getStoreCategory(c, h.DB)
+	if !ok {
+		return
+	}
+
+	variants, err := categories.ResolveVariants(h.DB, *category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve variants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": variants, "error": nil})
+}
+
+// ListProductVariants lists the variants of a product's category
+func (h *VariantHandler) ListProductVariants(c *gin.Context) {
+	product, ok := getStoreProduct(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var category models.Category
+	if err := h.DB.First(&category, product.CategoryID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return
+	}
+
+	variants, err := categories.ResolveVariants(h.DB, category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve variants"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"data": variants, "error": nil})
 }
+
+func (h *VariantHandler) SetVariantOverride(c *gin.Context) {
+	category, variant, ok := getCategoryVariant(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input VariantOverrideInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	override, err := categories.SetOverride(h.DB, *category, *variant, input.Hidden, input.HiddenOptionIDs)
+	if err != nil {
+		variantError(c, err, "Failed to save variant override")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": override, "error": nil})
+}
+
+func (h *VariantHandler) DeleteVariantOverride(c *gin.Context) {
+	category, variant, ok := getCategoryVariant(c, h.DB)
+	if !ok {
+		return
+	}
+
+	if err := categories.RemoveOverride(h.DB, *category, *variant); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant override"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variant override deleted successfully"})
+}
+
+// AddVariantOption adds an option to a variant. Added to an inherited
+// variant, the option only applies to the category and its subcategories.
+func (h *VariantHandler) AddVariantOption(c *gin.Context) {
+	category, variant, ok := getCategoryVariant(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var input VariantOptionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	option := models.VariantOption{
+		Value:       input.Value,
+		Description: input.Description,
+		Weight:      input.Weight,
+	}
+	if err := categories.AddOption(h.DB, *category, *variant, &option); err != nil {
+		variantError(c, err, "Failed to add variant option")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": option, "error": nil})
+}
+
+// getCategoryVariant loads the category_id category of an owned store and
+// the variant_id variant of the same store
+func getCategoryVariant(c *gin.Context, db *gorm.DB) (*models.Category, *models.Variant, bool) {
+	category, ok := getStoreCategory(c, db)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var variant models.Variant
+	err := db.Joins("JOIN categories ON categories.id = variants.category_id AND categories.deleted_at IS NULL").
+		Where("categories.store_id = ?", category.StoreID).
+		First(&variant, c.Param("variant_id")).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+		return nil, nil, false
+	}
+	return category, &variant, true
+}
+
+func variantError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, categories.ErrNotInherited), errors.Is(err, categories.ErrUnknownOption):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
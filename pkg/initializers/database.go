@@ -39,6 +39,37 @@ func ConnectToDB() {
 	// &models.CartItem{},
 	// &models.Address{},
 	// &models.Country{},
+	// &models.ShippingZone{},
+	// &models.ShippingZonePincode{},
+	// &models.ShippingMethod{},
+	// &models.ShippingRate{},
+	// &models.PaymentAttempt{},
+	// &models.PaymentWebhookEvent{},
+	// &models.ReturnRequest{},
+	// &models.ReturnItem{},
+	// &models.PaymentRefund{},
+	// &models.InvoiceSequence{},
+	// &models.Invoice{},
+	// &models.InvoiceLine{},
+	// &models.StockMovement{},
+	// &models.StockLocation{},
+	// &models.StockLevel{},
+	// &models.OrderAllocation{},
+	// &models.StockReservation{},
+	// &models.Notification{},
+	// &models.NotificationDelivery{},
+	// &models.Job{},
+	// &models.SearchSynonym{},
+	// &models.VariantOverride{},
+	// &models.Attribute{},
+	// &models.AttributeSet{},
+	// &models.AttributeSetAttribute{},
+	// &models.ProductAttributeValue{},
+	// &models.SlugRedirect{},
+	// &models.FeedState{},
+	// &models.FeedFile{},
+	// &models.Collection{},
+	// &models.CollectionProduct{},
 	// )
 
 	// if err != nil {
@@ -3,6 +3,8 @@ package initializers
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -24,3 +26,39 @@ func LoadEnvVariables() {
 		}
 	}
 }
+
+// StringEnv reads an optional setting from the environment
+func StringEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// DurationEnv reads an optional duration such as "15m" from the environment
+func DurationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s must be a duration like 15m: %v", name, err)
+	}
+	return d
+}
+
+// Int64Env reads an optional whole number from the environment
+func Int64Env(name string, fallback int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("%s must be a whole number: %v", name, err)
+	}
+	return n
+}
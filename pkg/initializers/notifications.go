@@ -0,0 +1,26 @@
+package initializers
+
+import (
+	"os"
+
+	"github.com/blanc42/ecms/pkg/notifications"
+)
+
+// NotificationChannels builds the outgoing notification channels from the
+// environment. Email is only enabled when SMTP_ADDR is set.
+func NotificationChannels() []notifications.Channel {
+	channels := []notifications.Channel{
+		notifications.NewWebhookChannel(os.Getenv("NOTIFICATION_WEBHOOK_SECRET")),
+	}
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		channels = append(channels, &notifications.EmailChannel{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	}
+
+	return channels
+}
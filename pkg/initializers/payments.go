@@ -0,0 +1,24 @@
+package initializers
+
+import (
+	"os"
+	"sync"
+
+	"github.com/blanc42/ecms/pkg/payments"
+)
+
+var (
+	paymentProviders     []payments.Provider
+	paymentProvidersOnce sync.Once
+)
+
+// PaymentProviders builds the payment providers once, the API and the
+// refund sweep have to talk to the same ones
+func PaymentProviders() []payments.Provider {
+	paymentProvidersOnce.Do(func() {
+		paymentProviders = []payments.Provider{
+			payments.NewMockProvider(os.Getenv("PAYMENT_WEBHOOK_SECRET")),
+		}
+	})
+	return paymentProviders
+}
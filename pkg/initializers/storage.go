@@ -0,0 +1,29 @@
+package initializers
+
+import (
+	"os"
+
+	"github.com/blanc42/ecms/pkg/media"
+	"github.com/blanc42/ecms/pkg/storage"
+)
+
+// MediaURLPath is where the local blob store's files are served from
+const MediaURLPath = "/media"
+
+// BlobStore picks where uploaded files are kept. Setting S3_BUCKET selects
+// an S3-compatible bucket; otherwise files go to MEDIA_ROOT on local disk.
+func BlobStore() storage.BlobStore {
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		region := StringEnv("S3_REGION", "us-east-1")
+		endpoint := StringEnv("S3_ENDPOINT", "https://s3."+region+".amazonaws.com")
+		return storage.NewS3Store(endpoint, region, bucket,
+			os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"), os.Getenv("S3_PUBLIC_URL"))
+	}
+
+	return storage.NewLocalStore(StringEnv("MEDIA_ROOT", "media"), MediaURLPath)
+}
+
+// ImageCache is where rendered image derivatives are kept, IMAGE_CACHE_DIR
+func ImageCache() *media.DiskCache {
+	return media.NewDiskCache(StringEnv("IMAGE_CACHE_DIR", "cache/images"))
+}
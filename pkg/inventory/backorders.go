@@ -0,0 +1,131 @@
+package inventory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Split decides how much of quantity a SKU can sell from the available
+// stock and how much goes on backorder, following the SKU's stock policy.
+// outstanding is the number of units the SKU already owes to orders. deny
+// never sells beyond stock; backorder and preorder sell the shortfall up to
+// BackorderLimit outstanding units, or without limit when it is unset.
+// Pre-orders are only taken until the release date.
+func Split(sku models.ProductItem, available, outstanding, quantity int, now time.Time) (fromStock, backordered int, preorder bool, err error) {
+	if available < 0 {
+		available = 0
+	}
+	if quantity <= available {
+		return quantity, 0, false, nil
+	}
+
+	switch sku.StockPolicy {
+	case models.StockPolicyBackorder:
+	case models.StockPolicyPreorder:
+		if sku.ReleaseDate == nil || !now.Before(*sku.ReleaseDate) {
+			return 0, 0, false, ErrInsufficientStock
+		}
+		preorder = true
+	default:
+		return 0, 0, false, ErrInsufficientStock
+	}
+
+	backordered = quantity - available
+	if sku.BackorderLimit != nil && outstanding+backordered > *sku.BackorderLimit {
+		return 0, 0, false, ErrInsufficientStock
+	}
+	return available, backordered, preorder, nil
+}
+
+// Backordered returns the units each SKU owes to confirmed orders. Pending
+// orders don't count, most of them are never paid for.
+func Backordered(tx *gorm.DB, productItemIDs []uint) (map[uint]int, error) {
+	var rows []struct {
+		ProductItemID uint
+		Total         int
+	}
+	err := tx.Model(&models.OrderItem{}).
+		Select("order_items.product_item_id, SUM(order_items.backordered_quantity) AS total").
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("order_items.product_item_id IN ? AND order_items.backordered_quantity > 0 AND orders.order_status = ?", productItemIDs, models.OrderStatusConfirmed).
+		Group("order_items.product_item_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	backordered := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		backordered[row.ProductItemID] = row.Total
+	}
+	return backordered, nil
+}
+
+// fillBackorders hands stock coming in to the backordered lines of
+// paid orders, oldest first. Units held by active reservations are left
+// alone. Stock received at a location is allocated from that location. The
+// SKU row must already be locked by the caller.
+func fillBackorders(tx *gorm.DB, item models.ProductItem, balance int, locationID *uint) error {
+	reserved, err := Reserved(tx, []uint{item.ID})
+	if err != nil {
+		return err
+	}
+	free := balance - reserved[item.ID]
+
+	if locationID != nil {
+		var level models.StockLevel
+		if err := tx.Where("stock_location_id = ? AND product_item_id = ?", *locationID, item.ID).First(&level).Error; err != nil {
+			return err
+		}
+		if level.Quantity < free {
+			free = level.Quantity
+		}
+	}
+	if free <= 0 {
+		return nil
+	}
+
+	var lines []models.OrderItem
+	if err := tx.Joins("Order").
+		Where("order_items.product_item_id = ? AND order_items.backordered_quantity > 0", item.ID).
+		Where(`"Order".order_status = ?`, models.OrderStatusConfirmed).
+		Order("order_items.id").Find(&lines).Error; err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if free == 0 {
+			break
+		}
+		take := line.BackorderedQuantity
+		if take > free {
+			take = free
+		}
+
+		if _, err := Record(tx, Movement{
+			ProductItemID: item.ID,
+			LocationID:    locationID,
+			Type:          models.MovementSale,
+			Quantity:      -take,
+			Reason:        "Backorder filled",
+			Reference:     fmt.Sprintf("order:%s", line.Order.OrderNumber),
+		}); err != nil {
+			return err
+		}
+
+		if locationID != nil {
+			if err := tx.Create(&models.OrderAllocation{OrderItemID: line.ID, StockLocationID: *locationID, Quantity: take}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&line).Update("backordered_quantity", gorm.Expr("backordered_quantity - ?", take)).Error; err != nil {
+			return err
+		}
+		free -= take
+	}
+
+	return nil
+}
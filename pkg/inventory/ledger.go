@@ -0,0 +1,215 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/notifications"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// Movement describes a change to a SKU's stock. Quantity is signed: receipts
+// and returns add stock, sales take it away, adjustments go either way.
+// Movements with a location also change that location's stock level.
+type Movement struct {
+	ProductItemID uint
+	LocationID    *uint
+	Type          string
+	Quantity      int
+	Reason        string
+	ActorType     string
+	ActorID       *uint
+	Reference     string
+}
+
+// Record appends a movement to the ledger and applies it to the SKU's
+// quantity. The SKU row is locked for the rest of the transaction so
+// concurrent movements are applied one after another. Stock coming in,
+// received, returned or adjusted up, goes to outstanding backorders first.
+// In stores with locations a movement without one is routed to them, see
+// route, so the SKU's quantity stays the sum of its stock levels; the last
+// movement recorded is returned.
+func Record(tx *gorm.DB, m Movement) (*models.StockMovement, error) {
+	var item models.ProductItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, m.ProductItemID).Error; err != nil {
+		return nil, err
+	}
+
+	if m.LocationID == nil {
+		legs, err := route(tx, item, m.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		if len(legs) > 0 {
+			var entry *models.StockMovement
+			for _, leg := range legs {
+				routed := m
+				routed.LocationID = &leg.locationID
+				routed.Quantity = leg.quantity
+				if entry, err = Record(tx, routed); err != nil {
+					return nil, err
+				}
+			}
+			return entry, nil
+		}
+	}
+
+	balance := item.Quantity + m.Quantity
+	if balance < 0 {
+		return nil, ErrInsufficientStock
+	}
+
+	if m.LocationID != nil {
+		if err := applyToLocation(tx, *m.LocationID, item.ID, m.Quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := models.StockMovement{
+		ProductItemID:   item.ID,
+		StockLocationID: m.LocationID,
+		Type:            m.Type,
+		Quantity:        m.Quantity,
+		BalanceAfter:    balance,
+		Reason:          m.Reason,
+		ActorType:       m.ActorType,
+		ActorID:         m.ActorID,
+		Reference:       m.Reference,
+	}
+	if entry.ActorType == "" {
+		entry.ActorType = models.ActorSystem
+	}
+
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(&item).Update("quantity", balance).Error; err != nil {
+		return nil, err
+	}
+
+	if (item.Quantity > 0) != (balance > 0) {
+		if err := touchFeeds(tx, item.ProductID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The outgoing leg of a transfer doesn't lower the SKU's stock for good
+	if m.Type != models.MovementTransfer {
+		if err := checkThreshold(tx, item, balance); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.Quantity > 0 && m.Type != models.MovementTransfer {
+		if err := fillBackorders(tx, item, balance, m.LocationID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// SetQuantity records whatever adjustment brings a SKU to quantity. Nothing
+// is recorded when the quantity doesn't change.
+func SetQuantity(tx *gorm.DB, productItemID uint, quantity int, m Movement) (*models.StockMovement, error) {
+	var item models.ProductItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, productItemID).Error; err != nil {
+		return nil, err
+	}
+
+	if item.Quantity == quantity {
+		return nil, nil
+	}
+
+	m.ProductItemID = productItemID
+	m.Type = models.MovementAdjustment
+	m.Quantity = quantity - item.Quantity
+	return Record(tx, m)
+}
+
+// LedgerBalance sums every movement of a SKU
+func LedgerBalance(tx *gorm.DB, productItemID uint) (int, int64, error) {
+	var result struct {
+		Total int
+		Count int64
+	}
+	err := tx.Model(&models.StockMovement{}).
+		Where("product_item_id = ?", productItemID).
+		Select("COALESCE(SUM(quantity), 0) AS total, COUNT(*) AS count").
+		Scan(&result).Error
+	return result.Total, result.Count, err
+}
+
+func applyToLocation(tx *gorm.DB, locationID, productItemID uint, delta int) error {
+	level := models.StockLevel{StockLocationID: locationID, ProductItemID: productItemID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("stock_location_id = ? AND product_item_id = ?", locationID, productItemID).
+		First(&level).Error; err != nil {
+		return err
+	}
+
+	if level.Quantity+delta < 0 {
+		return ErrInsufficientStock
+	}
+
+	return tx.Model(&level).Update("quantity", level.Quantity+delta).Error
+}
+
+// touchFeeds marks the feeds of a product's store stale when a SKU runs
+// out or comes back in stock, like feeds.Touch: the feeds package reads
+// availability from this one and can't be imported here
+func touchFeeds(tx *gorm.DB, productID uint) error {
+	state := models.FeedState{ChangedAt: time.Now()}
+	if err := tx.Model(&models.Product{}).Where("id = ?", productID).Select("store_id").Scan(&state.StoreID).Error; err != nil {
+		return err
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "store_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"changed_at", "updated_at"}),
+	}).Create(&state).Error
+}
+
+// checkThreshold raises a low-stock alert when a movement takes a SKU from
+// above its reorder threshold to at or below it. The SKU's own threshold
+// wins over the store default; a threshold of 0 only alerts on running out.
+func checkThreshold(tx *gorm.DB, item models.ProductItem, balance int) error {
+	if balance >= item.Quantity {
+		return nil
+	}
+
+	var product models.Product
+	if err := tx.Preload("Store").First(&product, item.ProductID).Error; err != nil {
+		return err
+	}
+
+	threshold := product.Store.DefaultReorderThreshold
+	if item.ReorderThreshold != nil {
+		threshold = *item.ReorderThreshold
+	}
+
+	if item.Quantity <= threshold || balance > threshold {
+		return nil
+	}
+
+	_, err := notifications.Emit(tx, product.StoreID, models.NotificationLowStock,
+		fmt.Sprintf("Low stock: %s (%s)", product.Name, item.SKU),
+		fmt.Sprintf("%s (%s) is down to %d units, at or below its reorder threshold of %d.", product.Name, item.SKU, balance, threshold),
+		map[string]any{
+			"product_id":      product.ID,
+			"product_item_id": item.ID,
+			"sku":             item.SKU,
+			"quantity":        balance,
+			"threshold":       threshold,
+		})
+	return err
+}
@@ -0,0 +1,274 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// ErrAlreadyAllocated is returned for orders whose stock was already
+// taken, by an allocation or a sale booked without one
+var ErrAlreadyAllocated = errors.New("order is already allocated")
+
+// leg is the part of a movement applied to one location
+type leg struct {
+	locationID uint
+	quantity   int
+}
+
+// route spreads a movement without a location over the locations of the
+// SKU's store. Stock coming in goes to the default location, the active
+// one with the highest priority. Stock going out is taken from the
+// locations in that order, inactive ones last. Stores without locations
+// get no legs, their stock is only kept as the SKU total.
+func route(tx *gorm.DB, item models.ProductItem, quantity int) ([]leg, error) {
+	var locations []models.StockLocation
+	if err := tx.Where("store_id = (SELECT store_id FROM products WHERE id = ?)", item.ProductID).
+		Order("is_active DESC, priority DESC, id").Find(&locations).Error; err != nil {
+		return nil, err
+	}
+	if len(locations) == 0 {
+		return nil, nil
+	}
+	if quantity >= 0 {
+		return []leg{{locations[0].ID, quantity}}, nil
+	}
+
+	var levels []models.StockLevel
+	if err := tx.Where("product_item_id = ? AND quantity > 0", item.ID).Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	stock := make(map[uint]int, len(levels))
+	for _, level := range levels {
+		stock[level.StockLocationID] = level.Quantity
+	}
+
+	var legs []leg
+	remaining := -quantity
+	for _, loc := range locations {
+		take := min(stock[loc.ID], remaining)
+		if take <= 0 {
+			continue
+		}
+		legs = append(legs, leg{loc.ID, -take})
+		remaining -= take
+		if remaining == 0 {
+			return legs, nil
+		}
+	}
+	return nil, ErrInsufficientStock
+}
+
+// AssignUnlocated puts stock of a store's SKUs that isn't at any location
+// yet, from before the store had locations, at locationID. Run when the
+// first location is created.
+func AssignUnlocated(tx *gorm.DB, storeID, locationID uint) error {
+	return tx.Exec(`INSERT INTO stock_levels (created_at, updated_at, stock_location_id, product_item_id, quantity)
+		SELECT NOW(), NOW(), ?, id, unlocated FROM (
+			SELECT product_items.id, product_items.quantity - COALESCE((SELECT SUM(stock_levels.quantity) FROM stock_levels
+				WHERE stock_levels.product_item_id = product_items.id AND stock_levels.deleted_at IS NULL), 0) AS unlocated
+			FROM product_items JOIN products ON products.id = product_items.product_id
+			WHERE products.store_id = ? AND product_items.deleted_at IS NULL
+		) AS items WHERE unlocated > 0
+		ON CONFLICT (stock_location_id, product_item_id) DO UPDATE SET quantity = stock_levels.quantity + EXCLUDED.quantity, updated_at = NOW()`,
+		locationID, storeID).Error
+}
+
+// Transfer moves stock of a SKU between two locations of the same store.
+// Both legs are recorded as transfer movements, so the SKU total is unchanged.
+func Transfer(tx *gorm.DB, productItemID, fromID, toID uint, quantity int, m Movement) error {
+	if fromID == toID {
+		return errors.New("cannot transfer stock to the same location")
+	}
+
+	m.ProductItemID = productItemID
+	m.Type = models.MovementTransfer
+	if m.Reference == "" {
+		m.Reference = fmt.Sprintf("transfer:%d->%d", fromID, toID)
+	}
+
+	out := m
+	out.LocationID = &fromID
+	out.Quantity = -quantity
+	if _, err := Record(tx, out); err != nil {
+		return err
+	}
+
+	in := m
+	in.LocationID = &toID
+	in.Quantity = quantity
+	_, err := Record(tx, in)
+	return err
+}
+
+// Allocate picks the locations an order ships from and takes the stock
+// there. Locations are ranked by priority, or by how close they are to the
+// shipping address first when strategy is proximity. An order is shipped
+// from a single location when any one of them can fulfil it; otherwise each
+// line is split over the ranked locations.
+func Allocate(tx *gorm.DB, order models.Order, strategy string, address *models.Address) ([]models.OrderAllocation, error) {
+	var existing int64
+	if err := tx.Model(&models.OrderAllocation{}).
+		Joins("JOIN order_items ON order_items.id = order_allocations.order_item_id").
+		Where("order_items.order_id = ?", order.ID).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, ErrAlreadyAllocated
+	}
+
+	// A sale booked without allocating, e.g. before the store had
+	// locations, already took the order's stock
+	var sold int64
+	if err := tx.Model(&models.StockMovement{}).
+		Where("type = ? AND reference = ?", models.MovementSale, "order:"+order.OrderNumber).Count(&sold).Error; err != nil {
+		return nil, err
+	}
+	if sold > 0 {
+		return nil, ErrAlreadyAllocated
+	}
+
+	// Backordered units are allocated as stock comes in
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ? AND quantity > backordered_quantity", order.ID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Quantity -= items[i].BackorderedQuantity
+	}
+
+	var locations []models.StockLocation
+	if err := tx.Where("store_id = ? AND is_active = ?", order.StoreID, true).Find(&locations).Error; err != nil {
+		return nil, err
+	}
+	RankLocations(locations, strategy, address)
+
+	locationIDs := make([]uint, len(locations))
+	for i, loc := range locations {
+		locationIDs[i] = loc.ID
+	}
+	itemIDs := make([]uint, len(items))
+	for i, item := range items {
+		itemIDs[i] = item.ProductItemID
+	}
+
+	var levels []models.StockLevel
+	if err := tx.Where("stock_location_id IN ? AND product_item_id IN ?", locationIDs, itemIDs).Find(&levels).Error; err != nil {
+		return nil, err
+	}
+	available := make(map[uint]map[uint]int)
+	for _, level := range levels {
+		if available[level.StockLocationID] == nil {
+			available[level.StockLocationID] = make(map[uint]int)
+		}
+		available[level.StockLocationID][level.ProductItemID] += level.Quantity
+	}
+
+	var plan []models.OrderAllocation
+	for _, loc := range locations {
+		if canFulfil(available[loc.ID], items) {
+			for _, item := range items {
+				plan = append(plan, models.OrderAllocation{OrderItemID: item.ID, StockLocationID: loc.ID, Quantity: item.Quantity})
+			}
+			break
+		}
+	}
+
+	if plan == nil {
+		for _, item := range items {
+			remaining := item.Quantity
+			for _, loc := range locations {
+				take := available[loc.ID][item.ProductItemID]
+				if take > remaining {
+					take = remaining
+				}
+				if take <= 0 {
+					continue
+				}
+				plan = append(plan, models.OrderAllocation{OrderItemID: item.ID, StockLocationID: loc.ID, Quantity: take})
+				available[loc.ID][item.ProductItemID] -= take
+				remaining -= take
+				if remaining == 0 {
+					break
+				}
+			}
+			if remaining > 0 {
+				return nil, fmt.Errorf("order item %d: %w", item.ID, ErrInsufficientStock)
+			}
+		}
+	}
+
+	productItems := make(map[uint]uint)
+	for _, item := range items {
+		productItems[item.ID] = item.ProductItemID
+	}
+
+	for i := range plan {
+		locationID := plan[i].StockLocationID
+		if _, err := Record(tx, Movement{
+			ProductItemID: productItems[plan[i].OrderItemID],
+			LocationID:    &locationID,
+			Type:          models.MovementSale,
+			Quantity:      -plan[i].Quantity,
+			Reason:        "Order allocated",
+			Reference:     "order:" + order.OrderNumber,
+		}); err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&plan[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// RankLocations sorts locations best first for the allocation strategy
+func RankLocations(locations []models.StockLocation, strategy string, address *models.Address) {
+	sort.SliceStable(locations, func(i, j int) bool {
+		if strategy == models.AllocateByProximity && address != nil {
+			pi, pj := proximity(locations[i], *address), proximity(locations[j], *address)
+			if pi != pj {
+				return pi < pj
+			}
+		}
+		if locations[i].Priority != locations[j].Priority {
+			return locations[i].Priority > locations[j].Priority
+		}
+		return locations[i].ID < locations[j].ID
+	})
+}
+
+// proximity ranks how close a location is to an address using only what an
+// address has: same pincode, same pincode area, same city, same country.
+// Lower is closer.
+func proximity(loc models.StockLocation, address models.Address) int {
+	switch {
+	case loc.Pincode != "" && loc.Pincode == address.Pincode:
+		return 0
+	case len(loc.Pincode) >= 3 && len(address.Pincode) >= 3 && loc.Pincode[:3] == address.Pincode[:3]:
+		return 1
+	case loc.City != "" && strings.EqualFold(loc.City, address.City):
+		return 2
+	case loc.CountryID != nil && *loc.CountryID == address.CountryID:
+		return 3
+	}
+	return 4
+}
+
+func canFulfil(stock map[uint]int, items []models.OrderItem) bool {
+	needed := make(map[uint]int)
+	for _, item := range items {
+		needed[item.ProductItemID] += item.Quantity
+	}
+	for productItemID, quantity := range needed {
+		if stock[productItemID] < quantity {
+			return false
+		}
+	}
+	return true
+}
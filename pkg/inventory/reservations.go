@@ -0,0 +1,334 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/notifications"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reserved returns the units of each SKU held by active, unexpired reservations
+func Reserved(tx *gorm.DB, productItemIDs []uint) (map[uint]int, error) {
+	var rows []struct {
+		ProductItemID uint
+		Total         int
+	}
+	err := tx.Model(&models.StockReservation{}).
+		Select("product_item_id, SUM(quantity) AS total").
+		Where("product_item_id IN ? AND status = ? AND expires_at > ?", productItemIDs, models.ReservationActive, time.Now()).
+		Group("product_item_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		reserved[row.ProductItemID] = row.Total
+	}
+	return reserved, nil
+}
+
+// FillAvailable sets AvailableQuantity, the quantity less reserved units, on SKUs
+func FillAvailable(tx *gorm.DB, items []*models.ProductItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	reserved, err := Reserved(tx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		item.AvailableQuantity = item.Quantity - reserved[item.ID]
+		if item.AvailableQuantity < 0 {
+			item.AvailableQuantity = 0
+		}
+	}
+	return nil
+}
+
+// Reserve holds stock for every item of an order until ttl runs out. SKU
+// rows are locked in ID order so concurrent checkouts can't both take the
+// last unit or deadlock each other. Whatever a SKU's stock policy lets it
+// sell beyond its stock is put on backorder: the items are updated with
+// their backordered quantity and only the in-stock part is reserved.
+func Reserve(tx *gorm.DB, orderID uint, items []models.OrderItem, ttl time.Duration) ([]models.StockReservation, error) {
+	needed := make(map[uint]int)
+	var ids []uint
+	for _, item := range items {
+		if _, ok := needed[item.ProductItemID]; !ok {
+			ids = append(ids, item.ProductItemID)
+		}
+		needed[item.ProductItemID] += item.Quantity
+	}
+
+	var skus []models.ProductItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Order("id").Find(&skus, ids).Error; err != nil {
+		return nil, err
+	}
+	if len(skus) != len(ids) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	reserved, err := Reserved(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	outstanding, err := Backordered(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	fromStock := make(map[uint]int, len(skus))
+	short := make(map[uint]int)
+	preorder := make(map[uint]bool)
+	for _, sku := range skus {
+		inStock, backordered, isPreorder, err := Split(sku, sku.Quantity-reserved[sku.ID], outstanding[sku.ID], needed[sku.ID], now)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sku.SKU, err)
+		}
+		fromStock[sku.ID] = inStock
+		short[sku.ID] = backordered
+		preorder[sku.ID] = isPreorder
+	}
+
+	// The shortfall of a SKU is put on its last lines first
+	for i := len(items) - 1; i >= 0; i-- {
+		item := &items[i]
+		take := short[item.ProductItemID]
+		if take > item.Quantity {
+			take = item.Quantity
+		}
+		if take == 0 {
+			continue
+		}
+		short[item.ProductItemID] -= take
+		item.BackorderedQuantity = take
+		item.IsPreorder = preorder[item.ProductItemID]
+		if err := tx.Model(item).Updates(map[string]any{
+			"backordered_quantity": item.BackorderedQuantity,
+			"is_preorder":          item.IsPreorder,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	expiresAt := now.Add(ttl)
+	reservations := make([]models.StockReservation, 0, len(ids))
+	for _, id := range ids {
+		if fromStock[id] == 0 {
+			continue
+		}
+		reservations = append(reservations, models.StockReservation{
+			ProductItemID: id,
+			OrderID:       orderID,
+			Quantity:      fromStock[id],
+			Status:        models.ReservationActive,
+			ExpiresAt:     expiresAt,
+		})
+	}
+	if len(reservations) > 0 {
+		if err := tx.Create(&reservations).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return reservations, nil
+}
+
+// Commit turns an order's reservations into sold stock once it is paid.
+// Stores with locations get the order allocated; otherwise the sale is
+// booked against the SKU totals. Reservations that already expired are
+// committed too, the customer has paid: units sold to someone else in the
+// meantime are put on backorder and the store is alerted that the order
+// is oversold, the payment is never turned down over stock.
+func Commit(tx *gorm.DB, order models.Order) error {
+	var reservations []models.StockReservation
+	if err := tx.Where("order_id = ? AND status <> ?", order.ID, models.ReservationCommitted).Find(&reservations).Error; err != nil {
+		return err
+	}
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	// Release first so the units held for this order count as available
+	if err := tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status <> ?", order.ID, models.ReservationCommitted).
+		Update("status", models.ReservationReleased).Error; err != nil {
+		return err
+	}
+
+	held := make(map[uint]int)
+	for _, r := range reservations {
+		held[r.ProductItemID] += r.Quantity
+	}
+	sold, err := oversell(tx, order, held)
+	if err != nil {
+		return err
+	}
+
+	var locations int64
+	if err := tx.Model(&models.StockLocation{}).Where("store_id = ? AND is_active = ?", order.StoreID, true).Count(&locations).Error; err != nil {
+		return err
+	}
+
+	allocated := false
+	if locations > 0 {
+		err := tx.Transaction(func(nested *gorm.DB) error {
+			_, err := Allocate(nested, order, models.AllocateByPriority, nil)
+			return err
+		})
+		if err == nil || errors.Is(err, ErrAlreadyAllocated) {
+			allocated = true
+		} else if !errors.Is(err, ErrInsufficientStock) {
+			return err
+		}
+	}
+
+	if !allocated {
+		for productItemID, quantity := range sold {
+			if quantity == 0 {
+				continue
+			}
+			if _, err := Record(tx, Movement{
+				ProductItemID: productItemID,
+				Type:          models.MovementSale,
+				Quantity:      -quantity,
+				Reason:        "Order paid",
+				Reference:     "order:" + order.OrderNumber,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Model(&models.StockReservation{}).
+		Where("id IN ?", reservationIDs(reservations)).
+		Update("status", models.ReservationCommitted).Error
+}
+
+// oversell checks the units an order held are still there and returns how
+// many of each SKU can be sold from stock. Units held by other orders'
+// active reservations aren't. The shortfall goes on backorder, on the
+// SKU's last lines first like in Reserve, and raises an oversold alert.
+func oversell(tx *gorm.DB, order models.Order, held map[uint]int) (map[uint]int, error) {
+	ids := make([]uint, 0, len(held))
+	for id := range held {
+		ids = append(ids, id)
+	}
+
+	var skus []models.ProductItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Product", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Order("id").Find(&skus, ids).Error; err != nil {
+		return nil, err
+	}
+	reserved, err := Reserved(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	sold := make(map[uint]int, len(skus))
+	short := make(map[uint]int)
+	for _, sku := range skus {
+		sold[sku.ID] = min(held[sku.ID], max(sku.Quantity-reserved[sku.ID], 0))
+		if sold[sku.ID] < held[sku.ID] {
+			short[sku.ID] = held[sku.ID] - sold[sku.ID]
+		}
+	}
+	if len(short) == 0 {
+		return sold, nil
+	}
+
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ? AND product_item_id IN ?", order.ID, ids).Order("id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		take := min(short[item.ProductItemID], item.Quantity-item.BackorderedQuantity)
+		if take <= 0 {
+			continue
+		}
+		short[item.ProductItemID] -= take
+		if err := tx.Model(&item).Update("backordered_quantity", gorm.Expr("backordered_quantity + ?", take)).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, sku := range skus {
+		missing := held[sku.ID] - sold[sku.ID]
+		if missing == 0 {
+			continue
+		}
+		_, err := notifications.Emit(tx, order.StoreID, models.NotificationOversold,
+			fmt.Sprintf("Order %s is oversold", order.OrderNumber),
+			fmt.Sprintf("Order %s was paid after its hold on %s (%s) expired. %d of %d units were sold in the meantime and are on backorder.",
+				order.OrderNumber, sku.Product.Name, sku.SKU, missing, held[sku.ID]),
+			map[string]any{
+				"order_id":        order.ID,
+				"product_item_id": sku.ID,
+				"sku":             sku.SKU,
+				"backordered":     missing,
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sold, nil
+}
+
+// Release gives back the stock held for an order
+func Release(tx *gorm.DB, orderID uint) error {
+	return tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND status = ?", orderID, models.ReservationActive).
+		Update("status", models.ReservationReleased).Error
+}
+
+// ReleaseExpired releases every reservation past its expiry
+func ReleaseExpired(db *gorm.DB) (int64, error) {
+	result := db.Model(&models.StockReservation{}).
+		Where("status = ? AND expires_at <= ?", models.ReservationActive, time.Now()).
+		Update("status", models.ReservationReleased)
+	return result.RowsAffected, result.Error
+}
+
+// SweepReservations releases expired reservations every interval until ctx
+// is done. Expired holds already stop counting against availability, the
+// sweep keeps their status honest.
+func SweepReservations(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := ReleaseExpired(db)
+			if err != nil {
+				log.Printf("failed to release expired reservations: %v", err)
+			} else if released > 0 {
+				log.Printf("released %d expired reservations", released)
+			}
+		}
+	}
+}
+
+func reservationIDs(reservations []models.StockReservation) []uint {
+	ids := make([]uint, len(reservations))
+	for i, r := range reservations {
+		ids[i] = r.ID
+	}
+	return ids
+}
@@ -0,0 +1,140 @@
+package invoices
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IssueInvoice issues the invoice of a paid order. Orders get exactly one
+// invoice, so calling it again returns the existing one. The order row is
+// locked first so concurrent callers wait for each other's invoice.
+func IssueInvoice(tx *gorm.DB, orderID uint) (*models.Invoice, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Store").Preload("OrderItems.ProductItem.Product").First(&order, orderID).Error; err != nil {
+		return nil, err
+	}
+
+	var existing models.Invoice
+	err := tx.Where("order_id = ? AND type = ?", orderID, models.InvoiceTypeInvoice).Preload("Lines").First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	invoice := models.Invoice{
+		StoreID:  order.StoreID,
+		OrderID:  order.ID,
+		Type:     models.InvoiceTypeInvoice,
+		IssuedAt: time.Now(),
+		Currency: order.Currency,
+	}
+
+	for _, item := range order.OrderItems {
+		itemID := item.ID
+		amount := item.UnitPrice * float64(item.Quantity)
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			OrderItemID: &itemID,
+			Description: describe(item),
+			Quantity:    item.Quantity,
+			UnitPrice:   item.UnitPrice,
+			Amount:      amount,
+		})
+		invoice.Total += amount
+	}
+	if len(invoice.Lines) == 0 {
+		invoice.Lines = append(invoice.Lines, models.InvoiceLine{
+			Description: "Order " + order.OrderNumber,
+			Quantity:    1,
+			UnitPrice:   order.TotalAmount,
+			Amount:      order.TotalAmount,
+		})
+		invoice.Total = order.TotalAmount
+	}
+
+	invoice.Number, err = NextNumber(tx, *order.Store, models.InvoiceTypeInvoice, invoice.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(&invoice).Error; err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// IssueCreditNote issues a credit note against the order's invoice for the
+// amounts refunded per order item
+func IssueCreditNote(tx *gorm.DB, orderID uint, refunded map[uint]float64) (*models.Invoice, error) {
+	invoice, err := IssueInvoice(tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var store models.Store
+	if err := tx.First(&store, invoice.StoreID).Error; err != nil {
+		return nil, err
+	}
+
+	note := models.Invoice{
+		StoreID:          invoice.StoreID,
+		OrderID:          orderID,
+		Type:             models.InvoiceTypeCreditNote,
+		IssuedAt:         time.Now(),
+		Currency:         invoice.Currency,
+		RelatedInvoiceID: &invoice.ID,
+	}
+
+	itemIDs := make([]uint, 0, len(refunded))
+	for itemID := range refunded {
+		itemIDs = append(itemIDs, itemID)
+	}
+	sort.Slice(itemIDs, func(i, j int) bool { return itemIDs[i] < itemIDs[j] })
+
+	for _, itemID := range itemIDs {
+		amount := refunded[itemID]
+		var item models.OrderItem
+		if err := tx.Preload("ProductItem.Product").First(&item, itemID).Error; err != nil {
+			return nil, err
+		}
+		id := item.ID
+		note.Lines = append(note.Lines, models.InvoiceLine{
+			OrderItemID: &id,
+			Description: "Refund: " + describe(item),
+			Quantity:    1,
+			UnitPrice:   amount,
+			Amount:      amount,
+		})
+		note.Total += amount
+	}
+
+	note.Number, err = NextNumber(tx, store, models.InvoiceTypeCreditNote, note.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(&note).Error; err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+func describe(item models.OrderItem) string {
+	if item.ProductItem == nil {
+		return fmt.Sprintf("Item %d", item.ProductItemID)
+	}
+	if item.ProductItem.Product == nil {
+		return item.ProductItem.SKU
+	}
+	return fmt.Sprintf("%s (%s)", item.ProductItem.Product.Name, item.ProductItem.SKU)
+}
@@ -0,0 +1,52 @@
+package invoices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NextNumber takes the next document number of a store's sequence. It must
+// run inside the transaction that creates the document: the sequence row
+// stays locked until that transaction ends, so a rollback returns the number
+// and concurrent issuers wait instead of skipping ahead.
+func NextNumber(tx *gorm.DB, store models.Store, docType string, issuedAt time.Time) (string, error) {
+	year := 0
+	if store.InvoiceYearlyReset {
+		year = issuedAt.Year()
+	}
+
+	seq := models.InvoiceSequence{StoreID: store.ID, Type: docType, Year: year}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return "", err
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("store_id = ? AND type = ? AND year = ?", store.ID, docType, year).
+		First(&seq).Error; err != nil {
+		return "", err
+	}
+
+	seq.LastNumber++
+	if err := tx.Model(&seq).Update("last_number", seq.LastNumber).Error; err != nil {
+		return "", err
+	}
+
+	return Format(store, docType, year, seq.LastNumber), nil
+}
+
+// Format renders a sequence number with the store's prefix, e.g. INV-000042
+// or, with yearly reset, INV-2026-000042
+func Format(store models.Store, docType string, year, number int) string {
+	prefix := store.InvoicePrefix
+	if docType == models.InvoiceTypeCreditNote {
+		prefix = store.CreditNotePrefix
+	}
+	if year != 0 {
+		return fmt.Sprintf("%s%d-%06d", prefix, year, number)
+	}
+	return fmt.Sprintf("%s%06d", prefix, number)
+}
@@ -0,0 +1,108 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/pdf"
+)
+
+// Data is what the document template is executed with
+type Data struct {
+	Title         string
+	Store         models.Store
+	Invoice       models.Invoice
+	RelatedNumber string
+	OrderNumber   string
+	CustomerName  string
+	CustomerEmail string
+}
+
+// The template produces one PDF line per text line. A line starting with
+// "# " is a heading, "**" makes a line bold, "---" is a blank gap and tabs
+// split a line into the item table columns.
+const documentTemplate = `# {{.Store.Name}}
+{{range addressLines .Store.PostalAddress}}{{.}}
+{{end}}---
+# {{.Title}}
+**Number:	{{.Invoice.Number}}
+Date:	{{date .Invoice.IssuedAt}}
+Order:	{{.OrderNumber}}
+{{if .RelatedNumber}}Corrects invoice:	{{.RelatedNumber}}
+{{end}}---
+**Bill to
+{{.CustomerName}}
+{{.CustomerEmail}}
+---
+**Description	Qty	Unit price	Amount
+{{range .Invoice.Lines}}{{.Description}}	{{.Quantity}}	{{money .UnitPrice}}	{{money .Amount}}
+{{end}}---
+**Total	 	{{.Invoice.Currency}}	{{money .Invoice.Total}}
+`
+
+var documentTmpl = template.Must(template.New("document").Funcs(template.FuncMap{
+	"money": func(v float64) string { return fmt.Sprintf("%.2f", v) },
+	"date":  func(t time.Time) string { return t.Format("02 Jan 2006") },
+	"addressLines": func(address string) []string {
+		if strings.TrimSpace(address) == "" {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(address), "\n")
+	},
+}).Parse(documentTemplate))
+
+var columns = []float64{50, 330, 390, 480}
+
+const (
+	lineHeight  = 16.0
+	topMargin   = 60.0
+	bottomLimit = pdf.PageHeight - 60
+)
+
+// Render writes an invoice or credit note as a PDF
+func Render(w io.Writer, data Data) error {
+	var text bytes.Buffer
+	if err := documentTmpl.Execute(&text, data); err != nil {
+		return err
+	}
+
+	doc := pdf.New()
+	doc.AddPage()
+	y := topMargin
+
+	for _, line := range strings.Split(strings.TrimRight(text.String(), "\n"), "\n") {
+		if y > bottomLimit {
+			doc.AddPage()
+			y = topMargin
+		}
+
+		size, font := 10.0, pdf.FontRegular
+		switch {
+		case line == "---":
+			y += lineHeight / 2
+			continue
+		case strings.HasPrefix(line, "# "):
+			size, font = 16, pdf.FontBold
+			line = strings.TrimPrefix(line, "# ")
+		case strings.HasPrefix(line, "**"):
+			font = pdf.FontBold
+			line = strings.TrimPrefix(line, "**")
+		}
+
+		for i, cell := range strings.Split(line, "\t") {
+			if i >= len(columns) {
+				break
+			}
+			doc.Text(columns[i], y, size, font, cell)
+		}
+		y += lineHeight * size / 10
+	}
+
+	_, err := doc.WriteTo(w)
+	return err
+}
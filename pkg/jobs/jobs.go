@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Progress reports how many of the job's units are done
+type Progress func(processed int)
+
+// Work is the body of a job. Whatever it returns is stored as the job's
+// result.
+type Work func(ctx context.Context, progress Progress) (any, error)
+
+// Start saves a queued job and runs work for it in the background, keeping
+// the job's status, progress and result up to date. Jobs live in memory
+// while they run: a job interrupted by a restart is failed by
+// FailInterrupted and has to be started again.
+func Start(db *gorm.DB, job *models.Job, work Work) error {
+	job.Status = models.JobQueued
+	if err := db.Create(job).Error; err != nil {
+		return err
+	}
+
+	go run(db, *job, work)
+	return nil
+}
+
+// FailInterrupted fails the jobs still queued or running, on startup those
+// can only be left over from a previous process
+func FailInterrupted(db *gorm.DB) (int64, error) {
+	result := db.Model(&models.Job{}).Where("status IN ?", []string{models.JobQueued, models.JobRunning}).
+		Updates(map[string]any{"status": models.JobFailed, "error": "interrupted by a restart", "finished_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+func run(db *gorm.DB, job models.Job, work Work) {
+	now := time.Now()
+	db.Model(&job).Updates(map[string]any{"status": models.JobRunning, "started_at": now})
+
+	progress := func(processed int) {
+		if err := db.Model(&job).Update("processed", processed).Error; err != nil {
+			log.Printf("failed to update progress of job %d: %v", job.ID, err)
+		}
+	}
+
+	result, err := safely(work, progress)
+
+	updates := map[string]any{"finished_at": time.Now()}
+	if result != nil {
+		encoded, encErr := json.Marshal(result)
+		if encErr == nil {
+			updates["result"] = string(encoded)
+		}
+	}
+	if err != nil {
+		updates["status"] = models.JobFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["status"] = models.JobCompleted
+	}
+
+	if err := db.Model(&job).Updates(updates).Error; err != nil {
+		log.Printf("failed to finish job %d: %v", job.ID, err)
+	}
+}
+
+// safely runs work, turning a panic into a failed job rather than a crash
+func safely(work Work, progress Progress) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return work(context.Background(), progress)
+}
@@ -0,0 +1,237 @@
+package listing
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cursor points at the row a page starts after, or with Before, ends
+// before. It is handed to clients as an opaque token.
+type Cursor struct {
+	Signature string
+	Before    bool
+	// Values are the row's sort values followed by its key
+	Values []any
+}
+
+// Page describes a page of results
+type Page struct {
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	Total      *int64 `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// Find loads a page of the rows of db matching the query into dest, a
+// pointer to a slice of models. scopes apply to loading the rows only,
+// e.g. preloads.
+func (q *Query) Find(db *gorm.DB, dest any, scopes ...func(*gorm.DB) *gorm.DB) (*Page, error) {
+	db = q.Filter(db.Session(&gorm.Session{}))
+	page := &Page{PageSize: q.PageSize}
+
+	if q.IncludeTotal {
+		var total int64
+		if err := db.Model(dest).Count(&total).Error; err != nil {
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	before := q.Cursor != nil && q.Cursor.Before
+	query := q.sort(db.Scopes(scopes...), before).Limit(q.PageSize + 1)
+	if q.Cursor != nil {
+		sql, vars := q.after(q.Cursor.Values, before)
+		query = query.Where(sql, vars...)
+	} else {
+		page.Page = q.Page
+		query = query.Offset((q.Page - 1) * q.PageSize)
+	}
+	if err := query.Find(dest).Error; err != nil {
+		return nil, err
+	}
+
+	// The extra row only tells whether there is more in that direction
+	rows := reflect.ValueOf(dest).Elem()
+	more := rows.Len() > q.PageSize
+	if more {
+		rows.SetLen(q.PageSize)
+	}
+	if before {
+		swap := reflect.Swapper(rows.Interface())
+		for i, j := 0, rows.Len()-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+	if rows.Len() == 0 {
+		return page, nil
+	}
+
+	// Coming from a cursor there is always a way back
+	var hasPrev, hasNext bool
+	switch {
+	case q.Cursor == nil:
+		hasPrev, hasNext = q.Page > 1, more
+	case before:
+		hasPrev, hasNext = more, true
+	default:
+		hasPrev, hasNext = true, more
+	}
+
+	var err error
+	if hasPrev {
+		if page.PrevCursor, err = q.cursorAt(db, rows.Index(0), true); err != nil {
+			return nil, err
+		}
+	}
+	if hasNext {
+		if page.NextCursor, err = q.cursorAt(db, rows.Index(rows.Len()-1), false); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// after matches the rows that come after values in sort order, or before
+// them when reversed:
+//
+//	a > va OR (a = va AND b > vb) OR (a = va AND b = vb AND id > vid)
+func (q *Query) after(values []any, reverse bool) (string, []any) {
+	keys := q.keys()
+	var ors []string
+	var vars []any
+	for i, key := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, keys[j].expr+" = ?")
+			vars = append(vars, values[j])
+		}
+		op := " > ?"
+		if key.desc != reverse {
+			op = " < ?"
+		}
+		ands = append(ands, key.expr+op)
+		vars = append(vars, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", vars
+}
+
+// cursorAt reads the sort values of a loaded row and makes a cursor of them
+func (q *Query) cursorAt(db *gorm.DB, row reflect.Value, before bool) (string, error) {
+	id := reflect.Indirect(row).FieldByName("ID").Interface()
+
+	keys := q.keys()
+	exprs := make([]string, len(keys))
+	for i, key := range keys {
+		exprs[i] = key.expr
+	}
+	table, _, _ := strings.Cut(q.spec.Key, ".")
+
+	values := make([]any, len(keys))
+	pointers := make([]any, len(keys))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	err := db.Session(&gorm.Session{NewDB: true}).Table(table).
+		Select(strings.Join(exprs, ", ")).Where(q.spec.Key+" = ?", id).
+		Row().Scan(pointers...)
+	if err != nil {
+		return "", err
+	}
+	return encodeCursor(Cursor{Signature: q.signature(), Before: before, Values: values})
+}
+
+// signature ties cursors to the sort and filters they were made with
+func (q *Query) signature() string {
+	parts := slices.Clone(q.params)
+	for _, condition := range q.Conditions {
+		parts = append(parts, fmt.Sprintf("%s[%s]=%v", condition.Field, condition.Op, condition.Value))
+	}
+	slices.Sort(parts)
+	for _, order := range q.Orders {
+		parts = append(parts, fmt.Sprintf("sort=%s/%t", order.Field, order.Desc))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "&")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cursorToken is the wire form of a cursor. Values keep their type so they
+// compare against their columns exactly.
+type cursorToken struct {
+	S string      `json:"s"`
+	B bool        `json:"b,omitempty"`
+	V [][2]string `json:"v"`
+}
+
+func encodeCursor(c Cursor) (string, error) {
+	token := cursorToken{S: c.Signature, B: c.Before}
+	for _, value := range c.Values {
+		switch v := value.(type) {
+		case int64:
+			token.V = append(token.V, [2]string{"i", strconv.FormatInt(v, 10)})
+		case float64:
+			token.V = append(token.V, [2]string{"f", strconv.FormatFloat(v, 'g', -1, 64)})
+		case bool:
+			token.V = append(token.V, [2]string{"b", strconv.FormatBool(v)})
+		case time.Time:
+			token.V = append(token.V, [2]string{"t", v.Format(time.RFC3339Nano)})
+		case string:
+			token.V = append(token.V, [2]string{"s", v})
+		case []byte:
+			token.V = append(token.V, [2]string{"s", string(v)})
+		default:
+			return "", fmt.Errorf("listing: cannot put %T in a cursor", value)
+		}
+	}
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+func decodeCursor(raw string) (*Cursor, error) {
+	encoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var token cursorToken
+	if err := json.Unmarshal(encoded, &token); err != nil {
+		return nil, err
+	}
+
+	c := &Cursor{Signature: token.S, Before: token.B}
+	for _, pair := range token.V {
+		var value any
+		switch pair[0] {
+		case "i":
+			value, err = strconv.ParseInt(pair[1], 10, 64)
+		case "f":
+			value, err = strconv.ParseFloat(pair[1], 64)
+		case "b":
+			value, err = strconv.ParseBool(pair[1])
+		case "t":
+			value, err = time.Parse(time.RFC3339Nano, pair[1])
+		case "s":
+			value = pair[1]
+		default:
+			err = fmt.Errorf("unknown cursor value type %q", pair[0])
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.Values = append(c.Values, value)
+	}
+	return c, nil
+}
@@ -0,0 +1,387 @@
+// Package listing parses the query string of list endpoints: pagination,
+// field filters and sorting.
+//
+//	?page=2&page_size=20&price[gte]=100&is_featured=true&category_id[in]=3,4&sort=-price,name
+//
+// A filter is field=value or field[op]=value. Every resource whitelists the
+// fields it can be filtered and sorted by; anything else is an error
+// meant to be shown to the client.
+//
+// Pages are addressed by page number or, so deep pages stay fast, by the
+// opaque next_cursor and prev_cursor handed out with every page. Totals
+// are only counted on request with include_total=true.
+package listing
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+)
+
+// Kind is the type of value a field holds
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Float
+	Bool
+	Time
+)
+
+// Filter operators
+const (
+	OpEq       = "eq"
+	OpNe       = "ne"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpIn       = "in"
+	OpContains = "contains"
+)
+
+var operators = map[string]string{
+	OpEq:  "=",
+	OpNe:  "<>",
+	OpGt:  ">",
+	OpGte: ">=",
+	OpLt:  "<",
+	OpLte: "<=",
+	OpIn:  "IN",
+}
+
+// kindOperators lists the operators each kind of field supports
+var kindOperators = map[Kind][]string{
+	String: {OpEq, OpNe, OpIn, OpContains},
+	Int:    {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn},
+	Float:  {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte},
+	Bool:   {OpEq, OpNe},
+	Time:   {OpEq, OpGt, OpGte, OpLt, OpLte},
+}
+
+// Field is a filterable attribute of a resource
+type Field struct {
+	Column string
+	Kind   Kind
+	// Within wraps the condition, for fields of related rows, e.g.
+	// "EXISTS (SELECT 1 FROM items WHERE items.order_id = orders.id AND %s)"
+	Within string
+}
+
+// Spec whitelists what a resource can be filtered and sorted by
+type Spec struct {
+	Filters map[string]Field
+	// Sorts maps sort names to the SQL expression they order by. The
+	// expressions must never be NULL for cursors to work.
+	Sorts map[string]string
+	// DefaultSort applies when the request has no sort, e.g. "-id"
+	DefaultSort string
+	// Key is the table qualified primary key, appended to every sort so
+	// pages are stable
+	Key string
+	// Params are other query parameters the endpoint reads itself
+	Params []string
+	// ParamPrefixes start other parameters the endpoint reads itself, e.g.
+	// "attr." for attribute filters
+	ParamPrefixes []string
+}
+
+// Condition is one parsed filter
+type Condition struct {
+	Field string
+	Op    string
+	Value any
+}
+
+// Order is one parsed sort key
+type Order struct {
+	Field string
+	Desc  bool
+}
+
+// Query is a parsed list request
+type Query struct {
+	Page         int
+	PageSize     int
+	Conditions   []Condition
+	Orders       []Order
+	Cursor       *Cursor
+	IncludeTotal bool
+	spec         Spec
+	// params holds the endpoint's own parameters, they narrow the list too
+	params []string
+}
+
+// Error is a problem with a query parameter
+type Error struct {
+	Param   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid query parameter %q: %s", e.Param, e.Message)
+}
+
+var reserved = map[string]bool{"page": true, "page_size": true, "sort": true, "cursor": true, "include_total": true}
+
+// Parse reads a list request's query string against spec
+func Parse(values url.Values, spec Spec) (*Query, error) {
+	q := &Query{Page: 1, PageSize: DefaultPageSize, spec: spec}
+
+	var err error
+	if q.Page, err = positiveInt(values, "page", 1); err != nil {
+		return nil, err
+	}
+	if q.PageSize, err = positiveInt(values, "page_size", DefaultPageSize); err != nil {
+		return nil, err
+	}
+	if q.PageSize > MaxPageSize {
+		return nil, &Error{"page_size", fmt.Sprintf("must be at most %d", MaxPageSize)}
+	}
+
+	sort := values.Get("sort")
+	if sort == "" {
+		sort = spec.DefaultSort
+	}
+	if q.Orders, err = parseSort(sort, spec); err != nil {
+		return nil, err
+	}
+
+	for _, param := range spec.Params {
+		q.params = append(q.params, param+"="+values.Get(param))
+	}
+
+	for param, params := range values {
+		if reserved[param] || slices.Contains(spec.Params, param) || hasPrefix(param, spec.ParamPrefixes) {
+			continue
+		}
+		for _, raw := range params {
+			condition, err := parseCondition(param, raw, spec)
+			if err != nil {
+				return nil, err
+			}
+			q.Conditions = append(q.Conditions, condition)
+		}
+	}
+
+	if raw := values.Get("include_total"); raw != "" {
+		if q.IncludeTotal, err = strconv.ParseBool(raw); err != nil {
+			return nil, &Error{"include_total", "must be true or false"}
+		}
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		if values.Has("page") {
+			return nil, &Error{"cursor", "cannot be combined with page"}
+		}
+		if q.Cursor, err = decodeCursor(raw); err != nil {
+			return nil, &Error{"cursor", "is malformed"}
+		}
+		if q.Cursor.Signature != q.signature() || len(q.Cursor.Values) != len(q.Orders)+1 {
+			return nil, &Error{"cursor", "belongs to a different sort or filter"}
+		}
+	}
+	return q, nil
+}
+
+// Filter applies the conditions, e.g. for counting
+func (q *Query) Filter(db *gorm.DB) *gorm.DB {
+	for _, condition := range q.Conditions {
+		field := q.spec.Filters[condition.Field]
+
+		var sql string
+		if condition.Op == OpContains {
+			sql = field.Column + " ILIKE ?"
+		} else {
+			sql = field.Column + " " + operators[condition.Op] + " ?"
+		}
+		if field.Within != "" {
+			sql = fmt.Sprintf(field.Within, sql)
+		}
+		db = db.Where(sql, condition.Value)
+	}
+	return db
+}
+
+// Sort applies the orders, then the key
+func (q *Query) Sort(db *gorm.DB) *gorm.DB {
+	return q.sort(db, false)
+}
+
+func (q *Query) sort(db *gorm.DB, reverse bool) *gorm.DB {
+	for _, key := range q.keys() {
+		db = db.Order(key.expr + direction(key.desc != reverse))
+	}
+	return db
+}
+
+// sortKey is an expression the rows are ordered by
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// keys are the orders followed by the primary key
+func (q *Query) keys() []sortKey {
+	keys := make([]sortKey, 0, len(q.Orders)+1)
+	for _, order := range q.Orders {
+		keys = append(keys, sortKey{q.spec.Sorts[order.Field], order.Desc})
+	}
+	return append(keys, sortKey{q.spec.Key, len(q.Orders) > 0 && q.Orders[len(q.Orders)-1].Desc})
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+func parseSort(sort string, spec Spec) ([]Order, error) {
+	var orders []Order
+	if sort == "" {
+		return orders, nil
+	}
+
+	if len(spec.Sorts) == 0 {
+		return nil, &Error{"sort", "this list has a fixed order"}
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(sort, ",") {
+		order := Order{Field: strings.TrimSpace(key)}
+		if strings.HasPrefix(order.Field, "-") {
+			order.Field, order.Desc = order.Field[1:], true
+		}
+		if _, ok := spec.Sorts[order.Field]; !ok {
+			return nil, &Error{"sort", fmt.Sprintf("cannot sort by %q, use one of %s", order.Field, names(spec.Sorts))}
+		}
+		if seen[order.Field] {
+			return nil, &Error{"sort", fmt.Sprintf("%q is listed twice", order.Field)}
+		}
+		seen[order.Field] = true
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func parseCondition(param, raw string, spec Spec) (Condition, error) {
+	name, op := param, OpEq
+	if i := strings.IndexByte(param, '['); i >= 0 {
+		if !strings.HasSuffix(param, "]") {
+			return Condition{}, &Error{param, "filters look like field[op]"}
+		}
+		name, op = param[:i], param[i+1:len(param)-1]
+	}
+
+	field, ok := spec.Filters[name]
+	if !ok {
+		return Condition{}, &Error{param, fmt.Sprintf("cannot filter by %q, use one of %s", name, names(spec.Filters))}
+	}
+	if !slices.Contains(kindOperators[field.Kind], op) {
+		return Condition{}, &Error{param, fmt.Sprintf("operator %q is not supported, use one of %s", op, strings.Join(kindOperators[field.Kind], ", "))}
+	}
+
+	condition := Condition{Field: name, Op: op}
+	switch op {
+	case OpIn:
+		var values []any
+		for _, part := range strings.Split(raw, ",") {
+			value, err := parseValue(field.Kind, strings.TrimSpace(part))
+			if err != nil {
+				return Condition{}, &Error{param, err.Error()}
+			}
+			values = append(values, value)
+		}
+		condition.Value = values
+	case OpContains:
+		condition.Value = "%" + escapeLike(raw) + "%"
+	default:
+		value, err := parseValue(field.Kind, raw)
+		if err != nil {
+			return Condition{}, &Error{param, err.Error()}
+		}
+		condition.Value = value
+	}
+	return condition, nil
+}
+
+func parseValue(kind Kind, raw string) (any, error) {
+	switch kind {
+	case Int:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", raw)
+		}
+		return v, nil
+	case Float:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return v, nil
+	case Bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", raw)
+		}
+		return v, nil
+	case Time:
+		if v, err := time.Parse(time.RFC3339, raw); err == nil {
+			return v, nil
+		}
+		v, err := time.Parse(time.DateOnly, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an RFC 3339 time or a YYYY-MM-DD date", raw)
+		}
+		return v, nil
+	}
+	return raw, nil
+}
+
+func positiveInt(values url.Values, param string, fallback int) (int, error) {
+	raw := values.Get(param)
+	if raw == "" {
+		return fallback, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 {
+		return 0, &Error{param, "must be a positive integer"}
+	}
+	return v, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// hasPrefix reports whether param starts with one of prefixes
+func hasPrefix(param string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(param, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// names lists the keys of a whitelist for error messages
+func names[V any](m map[string]V) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, ", ")
+}
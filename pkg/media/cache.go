@@ -0,0 +1,167 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DiskCache keeps rendered image derivatives on local disk, grouped by the
+// blob they were rendered from so Prune can drop those of deleted images
+type DiskCache struct {
+	Dir string
+}
+
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{Dir: dir}
+}
+
+// Key names a derivative of a blob. Blob keys are never reused, so a key
+// stays valid for as long as the derivative is cached.
+func (c *DiskCache) Key(blobKey string, o Options) string {
+	sum := sha256.Sum256([]byte(blobKey + "?" + o.Query().Encode()))
+	return blobDir(blobKey) + "-" + hex.EncodeToString(sum[:16])
+}
+
+// Get returns a cached derivative, or ok false on a miss. Hits are marked
+// used so Prune evicts the least recently used derivatives first.
+func (c *DiskCache) Get(key string) (data []byte, ok bool, err error) {
+	name := c.path(key)
+	data, err = os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	os.Chtimes(name, now, now)
+	return data, true, nil
+}
+
+// Put stores a derivative. The file is renamed into place so concurrent
+// readers never see a partial write.
+func (c *DiskCache) Put(key string, data []byte) error {
+	name := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Prune deletes the derivatives of blobs that aren't in live, then the
+// least recently used ones until the cache is at most maxBytes. A maxBytes
+// of 0 doesn't limit the size. It returns how many files were deleted.
+func (c *DiskCache) Prune(live []string, maxBytes int64) (int, error) {
+	keep := make(map[string]bool, len(live))
+	for _, blobKey := range live {
+		keep[blobDir(blobKey)] = true
+	}
+
+	type entry struct {
+		name    string
+		size    int64
+		touched time.Time
+	}
+	var entries []entry
+	var total int64
+	deleted := 0
+
+	shards, err := os.ReadDir(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		blobs, err := os.ReadDir(filepath.Join(c.Dir, shard.Name()))
+		if err != nil {
+			return deleted, err
+		}
+		for _, blob := range blobs {
+			dir := filepath.Join(c.Dir, shard.Name(), blob.Name())
+			// Files directly in a shard predate grouping by blob
+			if !blob.IsDir() {
+				if err := os.Remove(dir); err != nil {
+					return deleted, err
+				}
+				deleted++
+				continue
+			}
+			if !keep[blob.Name()] {
+				files, _ := os.ReadDir(dir)
+				if err := os.RemoveAll(dir); err != nil {
+					return deleted, err
+				}
+				deleted += len(files)
+				continue
+			}
+
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				return deleted, err
+			}
+			for _, file := range files {
+				info, err := file.Info()
+				if err != nil || strings.HasPrefix(file.Name(), ".tmp-") {
+					continue
+				}
+				entries = append(entries, entry{filepath.Join(dir, file.Name()), info.Size(), info.ModTime()})
+				total += info.Size()
+			}
+		}
+	}
+
+	if maxBytes <= 0 || total <= maxBytes {
+		return deleted, nil
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return a.touched.Compare(b.touched) })
+	for _, e := range entries {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(e.name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return deleted, err
+		}
+		total -= e.size
+		deleted++
+	}
+	return deleted, nil
+}
+
+// path puts a derivative in its blob's directory, spread over shards by
+// the first two characters
+func (c *DiskCache) path(key string) string {
+	blob, derivative, _ := strings.Cut(key, "-")
+	return filepath.Join(c.Dir, blob[:2], blob, derivative)
+}
+
+// blobDir names the directory of a blob's derivatives
+func blobDir(blobKey string) string {
+	sum := sha256.Sum256([]byte(blobKey))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,98 @@
+package media
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"github.com/blanc42/ecms/pkg/storage"
+	"gorm.io/gorm"
+)
+
+// CleanupOrphans deletes product image blobs that no live image row points
+// at: leftovers of failed uploads, deleted images whose blob couldn't be
+// removed, and images of deleted products. Blobs younger than grace are
+// kept so uploads still being saved aren't caught.
+func CleanupOrphans(ctx context.Context, db *gorm.DB, store storage.BlobStore, grace time.Duration) (int, error) {
+	// Images of deleted products go first so their blobs become orphans
+	if err := db.Where("product_id IN (?)", db.Unscoped().Model(&models.Product{}).Select("id").Where("deleted_at IS NOT NULL")).
+		Delete(&models.ProductImage{}).Error; err != nil {
+		return 0, err
+	}
+
+	blobs, err := store.List(ctx, "products/")
+	if err != nil {
+		return 0, err
+	}
+
+	var keys []string
+	if err := db.Model(&models.ProductImage{}).Where("blob_key <> ''").Pluck("blob_key", &keys).Error; err != nil {
+		return 0, err
+	}
+	live := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		live[key] = true
+	}
+
+	cutoff := time.Now().Add(-grace)
+	deleted := 0
+	for _, blob := range blobs {
+		if live[blob.Key] || blob.LastModified.After(cutoff) {
+			continue
+		}
+		if err := store.Delete(ctx, blob.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// SweepOrphans runs CleanupOrphans every interval until ctx is done
+func SweepOrphans(ctx context.Context, db *gorm.DB, store storage.BlobStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := CleanupOrphans(ctx, db, store, time.Hour)
+			if err != nil {
+				log.Printf("failed to clean up orphaned images: %v", err)
+			} else if deleted > 0 {
+				log.Printf("deleted %d orphaned image blobs", deleted)
+			}
+		}
+	}
+}
+
+// SweepCache prunes the image derivative cache every interval until ctx is
+// done, dropping derivatives of deleted images and keeping it under
+// maxBytes
+func SweepCache(ctx context.Context, db *gorm.DB, cache *DiskCache, interval time.Duration, maxBytes int64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var live []string
+			if err := db.Model(&models.ProductImage{}).Where("blob_key <> ''").Pluck("blob_key", &live).Error; err != nil {
+				log.Printf("failed to look for live images: %v", err)
+				continue
+			}
+			deleted, err := cache.Prune(live, maxBytes)
+			if err != nil {
+				log.Printf("failed to prune the image cache: %v", err)
+			} else if deleted > 0 {
+				log.Printf("pruned %d cached image derivatives", deleted)
+			}
+		}
+	}
+}
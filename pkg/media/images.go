@@ -0,0 +1,71 @@
+package media
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+)
+
+var ErrUnsupportedType = errors.New("unsupported image type, upload a JPEG, PNG, GIF or WebP")
+
+// Extensions of the image types accepted for upload, by sniffed content type
+var Extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Upload is an image that passed validation
+type Upload struct {
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Inspect sniffs the content type from the first bytes of an upload rather
+// than trusting the client's header, and reads the pixel size of the types
+// the standard library can decode. r is left at its start.
+func Inspect(r io.ReadSeeker) (*Upload, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	upload := &Upload{ContentType: http.DetectContentType(head)}
+	if _, ok := Extensions[upload.ContentType]; !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if upload.ContentType != "image/webp" {
+		cfg, _, err := image.DecodeConfig(r)
+		if err != nil {
+			return nil, ErrUnsupportedType
+		}
+		upload.Width, upload.Height = cfg.Width, cfg.Height
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	return upload, nil
+}
+
+// ProductImageKey builds a fresh blob key for an image of a product
+func ProductImageKey(productID uint, contentType string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("products/%d/%s%s", productID, hex.EncodeToString(b), Extensions[contentType])
+}
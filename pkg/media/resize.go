@@ -0,0 +1,119 @@
+package media
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// resample scales the src rectangle of img to w x h with a triangle
+// (bilinear) filter whose support widens with the scale factor, so large
+// reductions average every source pixel instead of skipping most of them.
+// Work happens on premultiplied RGBA so transparent edges don't darken.
+func resample(img image.Image, src image.Rectangle, w, h int) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Dx(), src.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, src.Min, draw.Src)
+
+	sw, sh := src.Dx(), src.Dy()
+
+	// Horizontal pass: sh rows of w pixels
+	tmp := make([]float32, sh*w*4)
+	xWeights := filterWeights(sw, w)
+	for y := 0; y < sh; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x, ws := range xWeights {
+			var r, g, b, a float32
+			for _, wt := range ws {
+				p := row[wt.index*4:]
+				r += float32(p[0]) * wt.weight
+				g += float32(p[1]) * wt.weight
+				b += float32(p[2]) * wt.weight
+				a += float32(p[3]) * wt.weight
+			}
+			o := (y*w + x) * 4
+			tmp[o], tmp[o+1], tmp[o+2], tmp[o+3] = r, g, b, a
+		}
+	}
+
+	// Vertical pass into the destination
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	yWeights := filterWeights(sh, h)
+	for y, ws := range yWeights {
+		for x := 0; x < w; x++ {
+			var r, g, b, a float32
+			for _, wt := range ws {
+				o := (wt.index*w + x) * 4
+				r += tmp[o] * wt.weight
+				g += tmp[o+1] * wt.weight
+				b += tmp[o+2] * wt.weight
+				a += tmp[o+3] * wt.weight
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			p[0], p[1], p[2], p[3] = clamp8(r), clamp8(g), clamp8(b), clamp8(a)
+		}
+	}
+
+	return dst
+}
+
+type weight struct {
+	index  int
+	weight float32
+}
+
+// filterWeights lists, for every destination pixel, the source pixels that
+// contribute to it and how much, normalised to sum to one
+func filterWeights(srcSize, dstSize int) [][]weight {
+	scale := float64(srcSize) / float64(dstSize)
+	support := math.Max(1, scale)
+
+	weights := make([][]weight, dstSize)
+	for i := range weights {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Floor(center - support + 1))
+		end := int(math.Ceil(center + support - 1))
+
+		var ws []weight
+		var total float64
+		for j := start; j <= end; j++ {
+			wt := 1 - math.Abs(float64(j)-center)/support
+			if wt <= 0 {
+				continue
+			}
+			index := j
+			if index < 0 {
+				index = 0
+			} else if index >= srcSize {
+				index = srcSize - 1
+			}
+			ws = append(ws, weight{index: index, weight: float32(wt)})
+			total += wt
+		}
+		if total == 0 {
+			// Exact hit on a source pixel with no neighbours in range
+			index := int(math.Round(center))
+			if index < 0 {
+				index = 0
+			} else if index >= srcSize {
+				index = srcSize - 1
+			}
+			ws = []weight{{index: index, weight: 1}}
+			total = 1
+		}
+		for k := range ws {
+			ws[k].weight /= float32(total)
+		}
+		weights[i] = ws
+	}
+	return weights
+}
+
+func clamp8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
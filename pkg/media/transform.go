@@ -0,0 +1,236 @@
+package media
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+// Fit modes
+const (
+	FitCover   = "cover"   // fill the box exactly, cropping the overflow around the centre
+	FitContain = "contain" // fit inside the box, keeping the aspect ratio
+	FitFill    = "fill"    // stretch to the box
+)
+
+const (
+	maxDimension   = 4000
+	maxSourcePixel = 50_000_000
+)
+
+var (
+	ErrInvalidOptions   = errors.New("invalid image options")
+	ErrInvalidSignature = errors.New("invalid image signature")
+	// ErrWebPUnsupported is returned for WebP sources: the standard
+	// library has no WebP decoder. WebP isn't an output format either, it
+	// has no encoder.
+	ErrWebPUnsupported = errors.New("webp is not supported, use jpeg, png or gif")
+)
+
+// OutputTypes maps output formats to their content type
+var OutputTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// Options describe a derivative of an image. Zero width or height is
+// derived from the other and the source's aspect ratio. An empty format
+// keeps the source format; formats are jpeg, png and gif.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+}
+
+// ParseOptions reads w, h, fit, format and q from a query string
+func ParseOptions(query url.Values) (Options, error) {
+	opts := Options{Fit: query.Get("fit"), Format: query.Get("format")}
+
+	for name, dst := range map[string]*int{"w": &opts.Width, "h": &opts.Height, "q": &opts.Quality} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return opts, fmt.Errorf("%s: %w", name, ErrInvalidOptions)
+			}
+			*dst = n
+		}
+	}
+
+	return opts, opts.validate()
+}
+
+func (o *Options) validate() error {
+	if o.Width < 0 || o.Width > maxDimension || o.Height < 0 || o.Height > maxDimension {
+		return fmt.Errorf("width and height must be between 0 and %d: %w", maxDimension, ErrInvalidOptions)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100: %w", ErrInvalidOptions)
+	}
+
+	switch o.Fit {
+	case "":
+		o.Fit = FitCover
+	case FitCover, FitContain, FitFill:
+	default:
+		return fmt.Errorf("fit must be cover, contain or fill: %w", ErrInvalidOptions)
+	}
+
+	if o.Format != "" {
+		if _, ok := OutputTypes[o.Format]; !ok {
+			return fmt.Errorf("format must be jpeg, png or gif: %w", ErrInvalidOptions)
+		}
+	}
+	return nil
+}
+
+// Query encodes the options in a fixed order; it is what gets signed
+func (o Options) Query() url.Values {
+	query := url.Values{}
+	if o.Width > 0 {
+		query.Set("w", strconv.Itoa(o.Width))
+	}
+	if o.Height > 0 {
+		query.Set("h", strconv.Itoa(o.Height))
+	}
+	if o.Fit != "" && o.Fit != FitCover {
+		query.Set("fit", o.Fit)
+	}
+	if o.Format != "" {
+		query.Set("format", o.Format)
+	}
+	if o.Quality > 0 {
+		query.Set("q", strconv.Itoa(o.Quality))
+	}
+	return query
+}
+
+// Sign returns the signature of a derivative of an image
+func Sign(secret []byte, imageID uint, o Options) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d?%s", imageID, o.Query().Encode())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign
+func Verify(secret []byte, imageID uint, o Options, signature string) error {
+	if !hmac.Equal([]byte(Sign(secret, imageID, o)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Transform decodes an image, resizes it as asked and encodes it in the
+// requested format. It returns the encoded bytes and their content type.
+func Transform(r io.Reader, sourceType string, o Options) ([]byte, string, error) {
+	format := o.Format
+	if format == "" {
+		for f, contentType := range OutputTypes {
+			if contentType == sourceType {
+				format = f
+			}
+		}
+	}
+	if sourceType == "image/webp" {
+		return nil, "", ErrWebPUnsupported
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Refuse decompression bombs before allocating the pixels
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxSourcePixel {
+		return nil, "", fmt.Errorf("source image is too large: %w", ErrInvalidOptions)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	src, w, h := layout(img.Bounds(), o)
+	var out image.Image = img
+	if src != img.Bounds() || w != img.Bounds().Dx() || h != img.Bounds().Dy() {
+		out = resample(img, src, w, h)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		quality := o.Quality
+		if quality == 0 {
+			quality = 82
+		}
+		err = jpeg.Encode(&buf, out, &jpeg.Options{Quality: quality})
+	case "png":
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, out)
+	case "gif":
+		paletted := image.NewPaletted(out.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), out, out.Bounds().Min)
+		err = gif.Encode(&buf, paletted, nil)
+	default:
+		return nil, "", ErrInvalidOptions
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), OutputTypes[format], nil
+}
+
+// layout works out the part of the source to use and the output size
+func layout(bounds image.Rectangle, o Options) (image.Rectangle, int, int) {
+	sw, sh := bounds.Dx(), bounds.Dy()
+	w, h := o.Width, o.Height
+
+	switch {
+	case w == 0 && h == 0:
+		return bounds, sw, sh
+	case w == 0:
+		w = max(1, sw*h/sh)
+		return bounds, w, h
+	case h == 0:
+		h = max(1, sh*w/sw)
+		return bounds, w, h
+	}
+
+	switch o.Fit {
+	case FitFill:
+		return bounds, w, h
+	case FitContain:
+		if sw*h > sh*w {
+			return bounds, w, max(1, sh*w/sw)
+		}
+		return bounds, max(1, sw*h/sh), h
+	}
+
+	// Cover: crop the source to the box's aspect ratio around the centre
+	cw, ch := sw, sh
+	if sw*h > sh*w {
+		cw = max(1, sh*w/h)
+	} else {
+		ch = max(1, sw*h/w)
+	}
+	origin := bounds.Min.Add(image.Pt((sw-cw)/2, (sh-ch)/2))
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cw, ch))}, w, h
+}
@@ -0,0 +1,189 @@
+// Package metafields checks and edits the namespaced metafields kept on
+// stores, categories, products, product items and customers. Every
+// metafield declares a type its value has to match.
+package metafields
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+)
+
+// Metafield types
+const (
+	SingleLineText = "single_line_text"
+	MultiLineText  = "multi_line_text"
+	Integer        = "integer"
+	Decimal        = "decimal"
+	Boolean        = "boolean"
+	Date           = "date"
+	DateTime       = "date_time"
+	URL            = "url"
+	JSON           = "json"
+)
+
+var types = []string{SingleLineText, MultiLineText, Integer, Decimal, Boolean, Date, DateTime, URL, JSON}
+
+// MaxValueBytes caps the encoded size of a single value
+const MaxValueBytes = 64 << 10
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Error is a problem with a metafield meant to be shown to the client
+type Error struct {
+	Namespace string
+	Key       string
+	Message   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("metafield %s.%s: %s", e.Namespace, e.Key, e.Message)
+}
+
+// Check validates a metafield's names and that its value fits its type
+func Check(field models.Metafield) error {
+	fail := func(message string) error {
+		return &Error{field.Namespace, field.Key, message}
+	}
+	if !namePattern.MatchString(field.Namespace) || !namePattern.MatchString(field.Key) {
+		return fail("namespace and key must be 1 to 64 lowercase letters, digits, dashes or underscores")
+	}
+	if len(field.Value) == 0 || bytes.Equal(field.Value, []byte("null")) {
+		return fail("value is required")
+	}
+	if len(field.Value) > MaxValueBytes {
+		return fail(fmt.Sprintf("value must be at most %d bytes", MaxValueBytes))
+	}
+
+	switch field.Type {
+	case SingleLineText, MultiLineText:
+		var s string
+		if json.Unmarshal(field.Value, &s) != nil {
+			return fail("value must be a string")
+		}
+		if field.Type == SingleLineText && strings.ContainsAny(s, "\r\n") {
+			return fail("value must be a single line")
+		}
+	case Integer:
+		var n json.Number
+		if json.Unmarshal(field.Value, &n) != nil {
+			return fail("value must be an integer")
+		}
+		if _, err := n.Int64(); err != nil {
+			return fail("value must be an integer")
+		}
+	case Decimal:
+		var f float64
+		if json.Unmarshal(field.Value, &f) != nil {
+			return fail("value must be a number")
+		}
+	case Boolean:
+		var b bool
+		if json.Unmarshal(field.Value, &b) != nil {
+			return fail("value must be true or false")
+		}
+	case Date, DateTime:
+		var s string
+		if json.Unmarshal(field.Value, &s) != nil {
+			return fail("value must be a string")
+		}
+		layout := time.DateOnly
+		if field.Type == DateTime {
+			layout = time.RFC3339
+		}
+		if _, err := time.Parse(layout, s); err != nil {
+			return fail("value must be formatted as " + layout)
+		}
+	case URL:
+		var s string
+		if json.Unmarshal(field.Value, &s) != nil {
+			return fail("value must be a string")
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fail("value must be an http or https URL")
+		}
+	case JSON:
+		if !json.Valid(field.Value) {
+			return fail("value must be valid JSON")
+		}
+	default:
+		return fail(fmt.Sprintf("type must be one of %s", strings.Join(types, ", ")))
+	}
+	return nil
+}
+
+// Set checks fields and writes them over those with the same namespace and
+// key, keeping the rest
+func Set(current models.Metafields, fields []models.Metafield) (models.Metafields, error) {
+	updated := slices.Clone(current)
+	for _, field := range fields {
+		if err := Check(field); err != nil {
+			return nil, err
+		}
+		field.Value = compact(field.Value)
+		i := slices.IndexFunc(updated, matches(field.Namespace, field.Key))
+		if i >= 0 {
+			updated[i] = field
+		} else {
+			updated = append(updated, field)
+		}
+	}
+	sortFields(updated)
+	return updated, nil
+}
+
+// Remove drops a metafield, reporting whether it was there
+func Remove(current models.Metafields, namespace, key string) (models.Metafields, bool) {
+	i := slices.IndexFunc(current, matches(namespace, key))
+	if i < 0 {
+		return current, false
+	}
+	return slices.Delete(slices.Clone(current), i, i+1), true
+}
+
+// Filter keeps the metafields of one namespace, all of them when it's
+// empty. With storefront only the exposed ones are kept.
+func Filter(current models.Metafields, namespace string, storefront bool) models.Metafields {
+	filtered := models.Metafields{}
+	for _, field := range current {
+		if namespace != "" && field.Namespace != namespace {
+			continue
+		}
+		if storefront && !field.Storefront {
+			continue
+		}
+		filtered = append(filtered, field)
+	}
+	return filtered
+}
+
+func matches(namespace, key string) func(models.Metafield) bool {
+	return func(field models.Metafield) bool {
+		return field.Namespace == namespace && field.Key == key
+	}
+}
+
+func sortFields(fields models.Metafields) {
+	slices.SortFunc(fields, func(a, b models.Metafield) int {
+		if c := strings.Compare(a.Namespace, b.Namespace); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+}
+
+func compact(value json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, value); err != nil {
+		return value
+	}
+	return buf.Bytes()
+}
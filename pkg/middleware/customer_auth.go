@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/blanc42/ecms/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// CustomerAuthMiddleware only lets through customers of the store in the
+// store_id param
+func CustomerAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := c.Cookie("customer-token")
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization cookie is required"})
+			c.Abort()
+			return
+		}
+
+		customerID, storeID, err := utils.VerifyCustomerToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		if c.Param("store_id") != strconv.FormatUint(uint64(storeID), 10) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is not valid for this store"})
+			c.Abort()
+			return
+		}
+
+		// Add customer ID to the context
+		c.Set("customer_id", customerID)
+
+		c.Next()
+	}
+}
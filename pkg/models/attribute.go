@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Attribute model
+// A descriptive property of products like material or warranty. Unlike
+// variants, attributes don't make SKUs. Code identifies the attribute in
+// filters and is unique within the store.
+type Attribute struct {
+	gorm.Model
+	StoreID uint   `gorm:"not null;uniqueIndex:idx_attribute_code" json:"store_id"`
+	Store   *Store `gorm:"foreignKey:StoreID" json:"-"`
+	Code    string `gorm:"type:varchar(100);not null;uniqueIndex:idx_attribute_code" json:"code"`
+	Name    string `gorm:"type:varchar(255);not null" json:"name"`
+	// Type is one of text, number, boolean, enum or date
+	Type string `gorm:"type:varchar(20);not null" json:"type"`
+	// Unit labels number values, e.g. "kg"
+	Unit string `gorm:"type:varchar(50)" json:"unit"`
+	// Options are the values an enum attribute allows
+	Options    []string `gorm:"type:jsonb;serializer:json" json:"options"`
+	Filterable bool     `gorm:"not null;default:false" json:"filterable"`
+}
+
+// AttributeSet model
+// A named group of attributes assigned to categories together
+type AttributeSet struct {
+	gorm.Model
+	StoreID    uint                    `gorm:"not null;index" json:"store_id"`
+	Store      *Store                  `gorm:"foreignKey:StoreID" json:"-"`
+	Name       string                  `gorm:"type:varchar(255);not null" json:"name"`
+	Attributes []AttributeSetAttribute `json:"attributes"`
+	Categories []Category              `gorm:"many2many:category_attribute_sets" json:"-"`
+}
+
+// AttributeSetAttribute model
+type AttributeSetAttribute struct {
+	gorm.Model
+	AttributeSetID uint       `gorm:"not null;uniqueIndex:idx_attribute_set_attribute" json:"attribute_set_id"`
+	AttributeID    uint       `gorm:"not null;uniqueIndex:idx_attribute_set_attribute" json:"attribute_id"`
+	Attribute      *Attribute `gorm:"foreignKey:AttributeID" json:"attribute,omitempty"`
+	Required       bool       `gorm:"not null;default:false" json:"required"`
+	Position       int        `gorm:"not null;default:0" json:"position"`
+}
+
+// ProductAttributeValue model
+// The column matching the attribute's type holds the value, enum values
+// are kept as text
+type ProductAttributeValue struct {
+	gorm.Model
+	ProductID   uint       `gorm:"not null;uniqueIndex:idx_product_attribute" json:"product_id"`
+	AttributeID uint       `gorm:"not null;uniqueIndex:idx_product_attribute;index" json:"attribute_id"`
+	Attribute   *Attribute `gorm:"foreignKey:AttributeID" json:"attribute,omitempty"`
+	Text        *string    `gorm:"type:text" json:"text,omitempty"`
+	Number      *float64   `json:"number,omitempty"`
+	Bool        *bool      `json:"bool,omitempty"`
+	Date        *time.Time `gorm:"type:date" json:"date,omitempty"`
+}
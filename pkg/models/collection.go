@@ -0,0 +1,47 @@
+package models
+
+import "gorm.io/gorm"
+
+// How a collection gets its products
+const (
+	CollectionManual    = "manual"
+	CollectionAutomatic = "automatic"
+)
+
+// Collection model
+// A store's hand-picked or rule-based group of products, e.g. "Summer
+// Sale". Manual collections hold the products an admin puts in them, in
+// their order. Automatic collections hold the products matching all of
+// their rules, or any of them with MatchAny.
+type Collection struct {
+	gorm.Model
+	StoreID     uint             `gorm:"not null;index" json:"store_id"`
+	Name        string           `gorm:"type:varchar(255);not null" json:"name"`
+	Description string           `gorm:"type:text" json:"description"`
+	Type        string           `gorm:"type:varchar(20);not null" json:"type"`
+	Rules       []CollectionRule `gorm:"type:jsonb;not null;default:'[]';serializer:json" json:"rules"`
+	MatchAny    bool             `gorm:"not null;default:false" json:"match_any"`
+	IsPublished bool             `gorm:"not null;default:false;index" json:"is_published"`
+	Slug        string           `gorm:"type:varchar(255);not null;default:''" json:"slug"`
+}
+
+// CollectionRule is one condition of an automatic collection. Which of
+// the other fields it reads depends on Type, see the collections package.
+type CollectionRule struct {
+	Type        string  `json:"type"`
+	CategoryIDs []uint  `json:"category_ids,omitempty"`
+	Price       float64 `json:"price,omitempty"`
+	Tag         string  `json:"tag,omitempty"`
+	Days        int     `json:"days,omitempty"`
+}
+
+// CollectionProduct model
+// A product in a collection. Position orders manual collections, the
+// products of automatic ones are all at 0.
+type CollectionProduct struct {
+	gorm.Model
+	CollectionID uint     `gorm:"not null;uniqueIndex:idx_collection_product" json:"collection_id"`
+	ProductID    uint     `gorm:"not null;uniqueIndex:idx_collection_product;index" json:"product_id"`
+	Product      *Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Position     int      `gorm:"not null;default:0" json:"position"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FeedState model
+// When a store's catalog last changed and when its feeds were last built.
+// Feeds are stale while ChangedAt is after GeneratedAt.
+type FeedState struct {
+	gorm.Model
+	StoreID     uint       `gorm:"not null;uniqueIndex" json:"store_id"`
+	ChangedAt   time.Time  `gorm:"not null" json:"changed_at"`
+	GeneratedAt *time.Time `json:"generated_at"`
+}
+
+// FeedFile model
+// A generated feed document kept in blob storage, served under Name, e.g.
+// "sitemap.xml" or "merchant.xml"
+type FeedFile struct {
+	gorm.Model
+	StoreID     uint      `gorm:"not null;uniqueIndex:idx_feed_file" json:"store_id"`
+	Name        string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_feed_file" json:"name"`
+	BlobKey     string    `gorm:"type:varchar(255);not null" json:"-"`
+	ContentType string    `gorm:"type:varchar(100);not null" json:"content_type"`
+	ETag        string    `gorm:"column:etag;type:varchar(100);not null" json:"etag"`
+	SizeBytes   int64     `gorm:"not null" json:"size_bytes"`
+	GeneratedAt time.Time `gorm:"not null" json:"generated_at"`
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Stock movement types
+const (
+	MovementReceipt    = "receipt"
+	MovementSale       = "sale"
+	MovementReturn     = "return"
+	MovementAdjustment = "adjustment"
+	MovementTransfer   = "transfer"
+)
+
+// Stock movement actors
+const (
+	ActorAdmin    = "admin"
+	ActorCustomer = "customer"
+	ActorSystem   = "system"
+)
+
+// StockMovement model
+// The ledger is append-only: ProductItem.Quantity is the running balance of
+// these movements and is only ever changed together with a new entry.
+type StockMovement struct {
+	gorm.Model
+	ProductItemID   uint         `gorm:"not null;index" json:"product_item_id"`
+	ProductItem     *ProductItem `gorm:"foreignKey:ProductItemID" json:"-"`
+	StockLocationID *uint        `gorm:"index" json:"stock_location_id,omitempty"`
+	Type            string       `gorm:"type:varchar(50);not null;index" json:"type"`
+	Quantity        int          `gorm:"not null" json:"quantity"`
+	BalanceAfter    int          `gorm:"not null" json:"balance_after"`
+	Reason          string       `gorm:"type:varchar(255);not null" json:"reason"`
+	ActorType       string       `gorm:"type:varchar(50);not null" json:"actor_type"`
+	ActorID         *uint        `json:"actor_id,omitempty"`
+	Reference       string       `gorm:"type:varchar(255);index" json:"reference,omitempty"`
+}
+
+// Allocation strategies
+const (
+	AllocateByPriority  = "priority"
+	AllocateByProximity = "proximity"
+)
+
+// StockLocation model
+type StockLocation struct {
+	gorm.Model
+	Name      string   `gorm:"type:varchar(255);not null" json:"name"`
+	Code      string   `gorm:"type:varchar(50);not null;uniqueIndex:idx_stock_location_code" json:"code"`
+	StoreID   uint     `gorm:"not null;index;uniqueIndex:idx_stock_location_code" json:"store_id"`
+	Store     *Store   `gorm:"foreignKey:StoreID" json:"-"`
+	Priority  int      `gorm:"not null;default:0" json:"priority"`
+	IsActive  bool     `gorm:"not null" json:"is_active"`
+	City      string   `gorm:"type:varchar(255)" json:"city"`
+	Pincode   string   `gorm:"type:varchar(255)" json:"pincode"`
+	CountryID *uint    `gorm:"index" json:"country_id,omitempty"`
+	Country   *Country `gorm:"foreignKey:CountryID" json:"-"`
+}
+
+// StockLevel model
+// In stores with locations ProductItem.Quantity is the total over all
+// locations: movements without a location are routed to them.
+type StockLevel struct {
+	gorm.Model
+	StockLocationID uint           `gorm:"not null;uniqueIndex:idx_stock_level" json:"stock_location_id"`
+	StockLocation   *StockLocation `gorm:"foreignKey:StockLocationID" json:"stock_location,omitempty"`
+	ProductItemID   uint           `gorm:"not null;uniqueIndex:idx_stock_level;index" json:"product_item_id"`
+	Quantity        int            `gorm:"not null;default:0" json:"quantity"`
+}
+
+// OrderAllocation model
+type OrderAllocation struct {
+	gorm.Model
+	OrderItemID     uint           `gorm:"not null;index" json:"order_item_id"`
+	StockLocationID uint           `gorm:"not null;index" json:"stock_location_id"`
+	StockLocation   *StockLocation `gorm:"foreignKey:StockLocationID" json:"stock_location,omitempty"`
+	Quantity        int            `gorm:"not null" json:"quantity"`
+}
+
+// Stock policies decide what happens when a SKU runs out
+const (
+	StockPolicyDeny      = "deny"
+	StockPolicyBackorder = "backorder"
+	StockPolicyPreorder  = "preorder"
+)
+
+// Stock reservation statuses
+const (
+	ReservationActive    = "active"
+	ReservationReleased  = "released"
+	ReservationCommitted = "committed"
+)
+
+// StockReservation model
+// Active, unexpired reservations hold units of a SKU for an order between
+// checkout and payment.
+type StockReservation struct {
+	gorm.Model
+	ProductItemID uint      `gorm:"not null;index" json:"product_item_id"`
+	OrderID       uint      `gorm:"not null;index" json:"order_id"`
+	Quantity      int       `gorm:"not null" json:"quantity"`
+	Status        string    `gorm:"type:varchar(50);not null;index" json:"status"`
+	ExpiresAt     time.Time `gorm:"not null;index" json:"expires_at"`
+}
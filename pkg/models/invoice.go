@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invoice document types
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+// InvoiceSequence model
+// One row per store, document type and year (0 when numbering never resets).
+// The row is locked while a number is taken so numbering stays gap-free.
+type InvoiceSequence struct {
+	gorm.Model
+	StoreID    uint   `gorm:"not null;uniqueIndex:idx_invoice_sequence" json:"store_id"`
+	Type       string `gorm:"type:varchar(50);not null;uniqueIndex:idx_invoice_sequence" json:"type"`
+	Year       int    `gorm:"not null;uniqueIndex:idx_invoice_sequence" json:"year"`
+	LastNumber int    `gorm:"not null;default:0" json:"last_number"`
+}
+
+// Invoice model
+// Credit notes are invoices of type credit_note that point at the invoice
+// they correct.
+type Invoice struct {
+	gorm.Model
+	StoreID          uint          `gorm:"not null;index;uniqueIndex:idx_invoice_number" json:"store_id"`
+	Store            *Store        `gorm:"foreignKey:StoreID" json:"-"`
+	OrderID          uint          `gorm:"not null;index;uniqueIndex:idx_order_invoice,where:type = 'invoice' AND deleted_at IS NULL" json:"order_id"`
+	Order            *Order        `gorm:"foreignKey:OrderID" json:"-"`
+	Type             string        `gorm:"type:varchar(50);not null;index;uniqueIndex:idx_order_invoice" json:"type"`
+	Number           string        `gorm:"type:varchar(255);not null;uniqueIndex:idx_invoice_number" json:"number"`
+	IssuedAt         time.Time     `gorm:"not null" json:"issued_at"`
+	Total            float64       `gorm:"type:float;not null" json:"total"`
+	Currency         string        `gorm:"type:varchar(3);not null" json:"currency"`
+	RelatedInvoiceID *uint         `gorm:"index" json:"related_invoice_id,omitempty"`
+	Lines            []InvoiceLine `json:"lines"`
+}
+
+// InvoiceLine model
+type InvoiceLine struct {
+	gorm.Model
+	InvoiceID   uint    `gorm:"not null;index" json:"invoice_id"`
+	OrderItemID *uint   `gorm:"index" json:"order_item_id,omitempty"`
+	Description string  `gorm:"type:varchar(255);not null" json:"description"`
+	Quantity    int     `gorm:"not null" json:"quantity"`
+	UnitPrice   float64 `gorm:"type:float;not null" json:"unit_price"`
+	Amount      float64 `gorm:"type:float;not null" json:"amount"`
+}
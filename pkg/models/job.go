@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Job types
+const (
+	JobCatalogImport = "catalog_import"
+	JobCatalogExport = "catalog_export"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Job model
+// Jobs track work that runs in the background after the request that
+// started it returns. Processed out of Total is the progress to poll.
+type Job struct {
+	gorm.Model
+	StoreID    uint       `gorm:"not null;index" json:"store_id"`
+	AdminID    *uint      `gorm:"index" json:"admin_id,omitempty"`
+	Type       string     `gorm:"type:varchar(50);not null;index" json:"type"`
+	Status     string     `gorm:"type:varchar(50);not null;index" json:"status"`
+	Total      int        `gorm:"not null;default:0" json:"total"`
+	Processed  int        `gorm:"not null;default:0" json:"processed"`
+	Result     string     `gorm:"type:jsonb" json:"result,omitempty"`
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
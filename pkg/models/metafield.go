@@ -0,0 +1,43 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Metafield is a piece of extra data an integration keeps on a record,
+// like an ERP ID. Namespace and key identify it on its owner, Type
+// declares what Value holds.
+type Metafield struct {
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Type      string          `json:"type"`
+	Value     json.RawMessage `json:"value"`
+	// Storefront exposes the metafield through the public storefront API
+	Storefront bool `json:"storefront"`
+}
+
+// Metafields are stored as a JSONB array on their owner
+type Metafields []Metafield
+
+func (m Metafields) Value() (driver.Value, error) {
+	if m == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+func (m *Metafields) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	}
+	return fmt.Errorf("models: cannot scan %T into Metafields", src)
+}
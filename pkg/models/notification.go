@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	NotificationLowStock     = "low_stock"
+	NotificationRefundFailed = "refund_failed"
+	NotificationOversold     = "oversold"
+)
+
+// Notification channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Notification delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Notification model
+// Notifications are the in-app feed of a store. Email and webhook copies are
+// tracked as deliveries and sent after the notification is committed.
+type Notification struct {
+	gorm.Model
+	StoreID    uint                   `gorm:"not null;index" json:"store_id"`
+	Type       string                 `gorm:"type:varchar(50);not null;index" json:"type"`
+	Title      string                 `gorm:"type:varchar(255);not null" json:"title"`
+	Body       string                 `gorm:"type:text" json:"body"`
+	Data       string                 `gorm:"type:jsonb" json:"data,omitempty"`
+	ReadAt     *time.Time             `gorm:"index" json:"read_at,omitempty"`
+	Deliveries []NotificationDelivery `json:"deliveries,omitempty"`
+}
+
+// NotificationDelivery model
+type NotificationDelivery struct {
+	gorm.Model
+	NotificationID uint          `gorm:"not null;index" json:"notification_id"`
+	Notification   *Notification `gorm:"foreignKey:NotificationID" json:"-"`
+	Channel        string        `gorm:"type:varchar(50);not null" json:"channel"`
+	Status         string        `gorm:"type:varchar(50);not null;index" json:"status"`
+	Attempts       int           `gorm:"not null;default:0" json:"attempts"`
+	LastError      string        `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time    `json:"delivered_at,omitempty"`
+}
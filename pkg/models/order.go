@@ -1 +1,8 @@
 package models
+
+// Order statuses
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusConfirmed = "confirmed"
+	OrderStatusCanceled  = "canceled"
+)
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Order payment statuses
+const (
+	PaymentStatusPending        = "pending"
+	PaymentStatusRequiresAction = "requires_action"
+	PaymentStatusAuthorized     = "authorized"
+	PaymentStatusPaid           = "paid"
+	PaymentStatusFailed         = "failed"
+	PaymentStatusPartialRefund  = "partially_refunded"
+	PaymentStatusRefunded       = "refunded"
+)
+
+// PaymentAttempt model
+type PaymentAttempt struct {
+	gorm.Model
+	OrderID          uint    `gorm:"not null;index" json:"order_id"`
+	Order            *Order  `gorm:"foreignKey:OrderID" json:"-"`
+	Provider         string  `gorm:"type:varchar(50);not null" json:"provider"`
+	ProviderIntentID string  `gorm:"type:varchar(255);not null;uniqueIndex" json:"provider_intent_id"`
+	Amount           float64 `gorm:"type:float;not null" json:"amount"`
+	CapturedAmount   float64 `gorm:"type:float;not null;default:0" json:"captured_amount"`
+	RefundedAmount   float64 `gorm:"type:float;not null;default:0" json:"refunded_amount"`
+	Currency         string  `gorm:"type:varchar(3);not null" json:"currency"`
+	Status           string  `gorm:"type:varchar(50);not null;index" json:"status"`
+	FailureReason    string  `gorm:"type:varchar(255)" json:"failure_reason,omitempty"`
+}
+
+// PaymentWebhookEvent model
+// Every processed provider event is recorded so redeliveries are no-ops.
+type PaymentWebhookEvent struct {
+	gorm.Model
+	Provider string `gorm:"type:varchar(50);not null;uniqueIndex:idx_payment_webhook_event" json:"provider"`
+	EventID  string `gorm:"type:varchar(255);not null;uniqueIndex:idx_payment_webhook_event" json:"event_id"`
+	Type     string `gorm:"type:varchar(100);not null" json:"type"`
+	Payload  string `gorm:"type:text" json:"-"`
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -16,14 +18,23 @@ type Admin struct {
 // Store model
 type Store struct {
 	gorm.Model
-	Name        string `gorm:"type:varchar(255);not null;index" json:"name"`
-	Description string `gorm:"type:text" json:"description"`
-	AdminID     uint   `gorm:"not null;index" json:"admin_id"`
-	Admin       *Admin `gorm:"foreignKey:AdminID"`
-	Categories  []Category
-	Products    []Product  `json:"-"`
-	Customers   []Customer `json:"-"`
-	Orders      []Order    `json:"-"`
+	Name                    string `gorm:"type:varchar(255);not null;index" json:"name"`
+	Description             string `gorm:"type:text" json:"description"`
+	PostalAddress           string `gorm:"type:text" json:"postal_address"`
+	InvoicePrefix           string `gorm:"type:varchar(50);not null;default:'INV-'" json:"invoice_prefix"`
+	CreditNotePrefix        string `gorm:"type:varchar(50);not null;default:'CN-'" json:"credit_note_prefix"`
+	InvoiceYearlyReset      bool   `gorm:"not null;default:false" json:"invoice_yearly_reset"`
+	DefaultReorderThreshold int    `gorm:"not null;default:0" json:"default_reorder_threshold"`
+	AlertEmail              string `gorm:"type:varchar(255)" json:"alert_email"`
+	AlertWebhookURL         string `gorm:"type:varchar(255)" json:"alert_webhook_url"`
+	StorefrontURL           string `gorm:"type:varchar(255)" json:"storefront_url"`
+	AdminID                 uint   `gorm:"not null;index" json:"admin_id"`
+	Admin                   *Admin `gorm:"foreignKey:AdminID"`
+	Categories              []Category
+	Products                []Product  `json:"-"`
+	Customers               []Customer `json:"-"`
+	Orders                  []Order    `json:"-"`
+	Metafields              Metafields `gorm:"type:jsonb;not null;default:'[]'" json:"-"`
 }
 
 // Category model
@@ -35,9 +46,23 @@ type Category struct {
 	Store            *Store      `gorm:"foreignKey:StoreID"`
 	ParentCategoryID *uint       `gorm:"index" json:"parent_category_id,omitempty"`
 	ParentCategory   *Category   `gorm:"foreignKey:ParentCategoryID"`
+	Position         int         `gorm:"not null;default:0" json:"position"`
+	Path             string      `gorm:"type:text;not null;default:''" json:"path"`
+	Depth            int         `gorm:"not null;default:0" json:"depth"`
 	Subcategories    []*Category `gorm:"-"`
 	Products         []Product   `json:"-"`
 	Variants         []Variant   `json:"-"`
+	Metafields       Metafields  `gorm:"type:jsonb;not null;default:'[]'" json:"-"`
+	// Slug names the category in storefront URLs, the SEO fields override
+	// what its pages show search engines
+	Slug            string `gorm:"type:varchar(255);not null;default:''" json:"slug"`
+	SEOTitle        string `gorm:"type:varchar(255)" json:"seo_title"`
+	MetaDescription string `gorm:"type:varchar(500)" json:"meta_description"`
+	CanonicalURL    string `gorm:"type:varchar(2048)" json:"canonical_url"`
+	// Product counts are filled in for the category tree, the total
+	// includes the products of subcategories
+	ProductCount      *int64 `gorm:"-" json:"product_count,omitempty"`
+	TotalProductCount *int64 `gorm:"-" json:"total_product_count,omitempty"`
 }
 
 // Product model
@@ -55,6 +80,18 @@ type Product struct {
 	Store       *Store    `gorm:"foreignKey:StoreID"`
 	Items       []ProductItem
 	Images      []ProductImage
+	Attributes  []ProductAttributeValue `json:"attributes,omitempty"`
+	Metafields  Metafields              `gorm:"type:jsonb;not null;default:'[]'" json:"-"`
+	// Slug names the product in storefront URLs, the SEO fields override
+	// what its pages show search engines
+	Slug            string `gorm:"type:varchar(255);not null;default:''" json:"slug"`
+	SEOTitle        string `gorm:"type:varchar(255)" json:"seo_title"`
+	MetaDescription string `gorm:"type:varchar(500)" json:"meta_description"`
+	CanonicalURL    string `gorm:"type:varchar(2048)" json:"canonical_url"`
+	// Tags are lowercase labels automatic collections can match on
+	Tags []string `gorm:"type:jsonb;not null;default:'[]';serializer:json" json:"tags"`
+	// SearchVector is maintained by the search package and never loaded
+	SearchVector string `gorm:"type:tsvector;->:false;<-:false" json:"-"`
 }
 
 // Variant model
@@ -76,25 +113,44 @@ type VariantOption struct {
 	Weight      int      `json:"weight"`
 	VariantID   uint     `gorm:"not null;index" json:"variant_id"`
 	Variant     *Variant `gorm:"foreignKey:VariantID"`
+	// CategoryID is set on options a subcategory added to an inherited
+	// variant, they only apply below that category
+	CategoryID *uint `gorm:"index" json:"category_id,omitempty"`
 }
 
 // ProductItem model
 type ProductItem struct {
 	gorm.Model
-	ProductID       uint     `gorm:"not null;index" json:"product_id"`
-	Product         *Product `gorm:"foreignKey:ProductID"`
-	SKU             string   `gorm:"type:varchar(255);not null;uniqueIndex" json:"sku"`
-	Quantity        int      `gorm:"not null" json:"quantity"`
-	Price           float64  `gorm:"type:float;not null;index" json:"price"`
-	DiscountedPrice float64  `gorm:"type:float;index" json:"discounted_price,omitempty"`
+	ProductID         uint         `gorm:"not null;index" json:"product_id"`
+	Product           *Product     `gorm:"foreignKey:ProductID"`
+	SKU               string       `gorm:"type:varchar(255);not null;uniqueIndex" json:"sku"`
+	Quantity          int          `gorm:"not null" json:"quantity"`
+	Price             float64      `gorm:"type:float;not null;index" json:"price"`
+	DiscountedPrice   float64      `gorm:"type:float;index" json:"discounted_price,omitempty"`
+	WeightGrams       int          `gorm:"not null;default:0" json:"weight_grams"`
+	ReorderThreshold  *int         `json:"reorder_threshold,omitempty"`
+	StockPolicy       string       `gorm:"type:varchar(50);not null;default:'deny'" json:"stock_policy"`
+	BackorderLimit    *int         `json:"backorder_limit"`
+	ReleaseDate       *time.Time   `json:"release_date,omitempty"`
+	StockLevels       []StockLevel `json:"stock_levels,omitempty"`
+	AvailableQuantity int          `gorm:"-" json:"available_quantity"`
+	Metafields        Metafields   `gorm:"type:jsonb;not null;default:'[]'" json:"-"`
 }
 
 // ProductImage model
 type ProductImage struct {
 	gorm.Model
-	ProductID uint     `gorm:"not null;index" json:"product_id"`
-	Product   *Product `gorm:"foreignKey:ProductID"`
-	ImageURL  string   `gorm:"type:varchar(255);not null" json:"image_url"`
+	ProductID   uint     `gorm:"not null;index" json:"product_id"`
+	Product     *Product `gorm:"foreignKey:ProductID"`
+	ImageURL    string   `gorm:"type:varchar(255);not null" json:"image_url"`
+	BlobKey     string   `gorm:"type:varchar(255);index" json:"blob_key"`
+	ContentType string   `gorm:"type:varchar(100)" json:"content_type"`
+	SizeBytes   int64    `gorm:"not null;default:0" json:"size_bytes"`
+	Width       int      `gorm:"not null;default:0" json:"width"`
+	Height      int      `gorm:"not null;default:0" json:"height"`
+	Position    int      `gorm:"not null;default:0" json:"position"`
+	AltText     string   `gorm:"type:varchar(255)" json:"alt_text"`
+	IsPrimary   bool     `gorm:"not null;default:false" json:"is_primary"`
 }
 
 // Customer model
@@ -109,6 +165,8 @@ type Customer struct {
 	Address   *Address `gorm:"foreignKey:AddressID"`
 	Carts     []Cart
 	Orders    []Order
+	// Metafields are served by their own endpoints only
+	Metafields Metafields `gorm:"type:jsonb;not null;default:'[]'" json:"-"`
 }
 
 // Order model
@@ -117,6 +175,8 @@ type Order struct {
 	OrderNumber   string    `gorm:"type:varchar(255);not null;uniqueIndex" json:"order_number"`
 	PaymentStatus string    `gorm:"type:varchar(50);not null;index" json:"payment_status"`
 	OrderStatus   string    `gorm:"type:varchar(50);not null;index" json:"order_status"`
+	TotalAmount   float64   `gorm:"type:float;not null;default:0" json:"total_amount"`
+	Currency      string    `gorm:"type:varchar(3);not null;default:'INR'" json:"currency"`
 	StoreID       uint      `gorm:"not null;index" json:"store_id"`
 	Store         *Store    `gorm:"foreignKey:StoreID"`
 	CustomerID    uint      `gorm:"not null;index" json:"customer_id"`
@@ -127,11 +187,16 @@ type Order struct {
 // OrderItem model
 type OrderItem struct {
 	gorm.Model
-	ProductItemID uint         `gorm:"not null;index" json:"product_item_id"`
-	ProductItem   *ProductItem `gorm:"foreignKey:ProductItemID"`
-	Quantity      int          `gorm:"not null" json:"quantity"`
-	OrderID       uint         `gorm:"not null;index" json:"order_id"`
-	Order         *Order       `gorm:"foreignKey:OrderID"`
+	ProductItemID       uint         `gorm:"not null;index" json:"product_item_id"`
+	ProductItem         *ProductItem `gorm:"foreignKey:ProductItemID"`
+	Quantity            int          `gorm:"not null" json:"quantity"`
+	UnitPrice           float64      `gorm:"type:float;not null;default:0" json:"unit_price"`
+	ReturnedQuantity    int          `gorm:"not null;default:0" json:"returned_quantity"`
+	BackorderedQuantity int          `gorm:"not null;default:0" json:"backordered_quantity"`
+	IsPreorder          bool         `gorm:"not null;default:false" json:"is_preorder"`
+	RefundedAmount      float64      `gorm:"type:float;not null;default:0" json:"refunded_amount"`
+	OrderID             uint         `gorm:"not null;index" json:"order_id"`
+	Order               *Order       `gorm:"foreignKey:OrderID"`
 }
 
 // Cart model
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Return request statuses
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusRefunded  = "refunded"
+)
+
+// Return reasons
+const (
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonChangedMind    = "changed_mind"
+	ReturnReasonOther          = "other"
+)
+
+// Return item inspection states
+const (
+	InspectionPending = "pending"
+	InspectionPassed  = "passed"
+	InspectionFailed  = "failed"
+)
+
+// ReturnRequest model
+type ReturnRequest struct {
+	gorm.Model
+	OrderID      uint         `gorm:"not null;index" json:"order_id"`
+	Order        *Order       `gorm:"foreignKey:OrderID" json:"-"`
+	Status       string       `gorm:"type:varchar(50);not null;index" json:"status"`
+	Note         string       `gorm:"type:text" json:"note"`
+	Restocked    bool         `gorm:"not null;default:false" json:"restocked"`
+	RefundAmount float64      `gorm:"type:float;not null;default:0" json:"refund_amount"`
+	Items        []ReturnItem `json:"items"`
+}
+
+// ReturnItem model
+type ReturnItem struct {
+	gorm.Model
+	ReturnRequestID uint       `gorm:"not null;index" json:"return_request_id"`
+	OrderItemID     uint       `gorm:"not null;index" json:"order_item_id"`
+	OrderItem       *OrderItem `gorm:"foreignKey:OrderItemID" json:"-"`
+	Quantity        int        `gorm:"not null" json:"quantity"`
+	Reason          string     `gorm:"type:varchar(50);not null" json:"reason"`
+	Note            string     `gorm:"type:text" json:"note"`
+	InspectionState string     `gorm:"type:varchar(50);not null" json:"inspection_state"`
+	RefundedAmount  float64    `gorm:"type:float;not null;default:0" json:"refunded_amount"`
+}
+
+// Payment refund statuses
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// PaymentRefund model
+// Refunds are recorded as pending before the provider is asked, so money
+// never leaves without a local record. ProviderRefundID is set once the
+// provider accepted the refund.
+type PaymentRefund struct {
+	gorm.Model
+	PaymentAttemptID uint    `gorm:"not null;index" json:"payment_attempt_id"`
+	ReturnRequestID  *uint   `gorm:"index" json:"return_request_id,omitempty"`
+	ProviderRefundID *string `gorm:"type:varchar(255);uniqueIndex" json:"provider_refund_id"`
+	Amount           float64 `gorm:"type:float;not null" json:"amount"`
+	Status           string  `gorm:"type:varchar(50);not null;index" json:"status"`
+	FailureReason    string  `gorm:"type:varchar(255)" json:"failure_reason,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "gorm.io/gorm"
+
+// SearchSynonym model
+// Terms are words or phrases a store treats as equivalent in product
+// search: a query for any of them also matches the others.
+type SearchSynonym struct {
+	gorm.Model
+	StoreID uint     `gorm:"not null;index" json:"store_id"`
+	Store   *Store   `gorm:"foreignKey:StoreID" json:"-"`
+	Terms   []string `gorm:"type:jsonb;serializer:json;not null" json:"terms"`
+}
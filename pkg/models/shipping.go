@@ -0,0 +1,59 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Shipping method types
+const (
+	ShippingMethodFlat      = "flat"
+	ShippingMethodWeight    = "weight"
+	ShippingMethodPriceTier = "price_tier"
+	ShippingMethodFreeOver  = "free_over"
+	ShippingMethodCarrier   = "carrier"
+)
+
+// ShippingZone model
+type ShippingZone struct {
+	gorm.Model
+	Name      string                `gorm:"type:varchar(255);not null;index" json:"name"`
+	StoreID   uint                  `gorm:"not null;index" json:"store_id"`
+	Store     *Store                `gorm:"foreignKey:StoreID" json:"-"`
+	Priority  int                   `gorm:"not null;default:0;index" json:"priority"`
+	Countries []Country             `gorm:"many2many:shipping_zone_countries" json:"countries"`
+	Pincodes  []ShippingZonePincode `gorm:"foreignKey:ShippingZoneID" json:"pincodes"`
+	Methods   []ShippingMethod      `gorm:"foreignKey:ShippingZoneID" json:"methods"`
+}
+
+// ShippingZonePincode model
+// A zone without pincodes serves every pincode of its countries.
+type ShippingZonePincode struct {
+	gorm.Model
+	Pincode        string `gorm:"type:varchar(255);not null;index" json:"pincode"`
+	ShippingZoneID uint   `gorm:"not null;index" json:"shipping_zone_id"`
+}
+
+// ShippingMethod model
+type ShippingMethod struct {
+	gorm.Model
+	Name           string         `gorm:"type:varchar(255);not null" json:"name"`
+	Type           string         `gorm:"type:varchar(50);not null" json:"type"`
+	IsActive       bool           `gorm:"not null" json:"is_active"`
+	FlatRate       float64        `gorm:"type:float" json:"flat_rate"`
+	FreeOver       float64        `gorm:"type:float" json:"free_over,omitempty"`
+	Carrier        string         `gorm:"type:varchar(255)" json:"carrier,omitempty"`
+	ShippingZoneID uint           `gorm:"not null;index" json:"shipping_zone_id"`
+	ShippingZone   *ShippingZone  `gorm:"foreignKey:ShippingZoneID" json:"-"`
+	Rates          []ShippingRate `json:"rates"`
+}
+
+// ShippingRate model
+// Rates are the tiers of weight and price_tier methods; Min is inclusive
+// and Max exclusive, with a Max of 0 meaning unbounded.
+type ShippingRate struct {
+	gorm.Model
+	Min              float64 `gorm:"type:float;not null" json:"min"`
+	Max              float64 `gorm:"type:float" json:"max"`
+	Price            float64 `gorm:"type:float;not null" json:"price"`
+	ShippingMethodID uint    `gorm:"not null;index" json:"shipping_method_id"`
+}
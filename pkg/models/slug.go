@@ -0,0 +1,14 @@
+package models
+
+import "gorm.io/gorm"
+
+// SlugRedirect model
+// An old slug of a product or category, pointing at the record that had
+// it. Kind is the table of the record.
+type SlugRedirect struct {
+	gorm.Model
+	StoreID  uint   `gorm:"not null;uniqueIndex:idx_slug_redirect" json:"store_id"`
+	Kind     string `gorm:"type:varchar(50);not null;uniqueIndex:idx_slug_redirect" json:"kind"`
+	Slug     string `gorm:"type:varchar(255);not null;uniqueIndex:idx_slug_redirect" json:"slug"`
+	TargetID uint   `gorm:"not null;index" json:"target_id"`
+}
@@ -1 +1,17 @@
 package models
+
+import "gorm.io/gorm"
+
+// VariantOverride model
+// Adjusts a variant a category inherits from one of its ancestors, for the
+// category and everything below it. The override nearest to a category
+// wins; hidden options are those of that override alone.
+type VariantOverride struct {
+	gorm.Model
+	CategoryID      uint      `gorm:"not null;uniqueIndex:idx_variant_override" json:"category_id"`
+	Category        *Category `gorm:"foreignKey:CategoryID" json:"-"`
+	VariantID       uint      `gorm:"not null;uniqueIndex:idx_variant_override" json:"variant_id"`
+	Variant         *Variant  `gorm:"foreignKey:VariantID" json:"-"`
+	Hidden          bool      `gorm:"not null;default:false" json:"hidden"`
+	HiddenOptionIDs []uint    `gorm:"type:jsonb;serializer:json" json:"hidden_option_ids"`
+}
@@ -0,0 +1,118 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+)
+
+var errChannelNotConfigured = errors.New("notification channel is not configured")
+
+// EmailChannel sends notifications to the store's alert email over SMTP
+type EmailChannel struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (e *EmailChannel) Name() string {
+	return models.ChannelEmail
+}
+
+func (e *EmailChannel) Send(ctx context.Context, store models.Store, n models.Notification) error {
+	if store.AlertEmail == "" {
+		return errChannelNotConfigured
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		host, _, err := net.SplitHostPort(e.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", store.AlertEmail)
+	fmt.Fprintf(&msg, "Subject: [%s] %s\r\n", store.Name, n.Title)
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(n.Body)
+
+	return smtp.SendMail(e.Addr, auth, e.From, []string{store.AlertEmail}, []byte(msg.String()))
+}
+
+// WebhookSignatureHeader carries the hex HMAC-SHA256 of the request body
+const WebhookSignatureHeader = "X-Ecms-Signature"
+
+// WebhookChannel posts notifications as JSON to the store's alert webhook.
+// Bodies are signed with Secret so receivers can check where they came from.
+type WebhookChannel struct {
+	Secret string
+	Client *http.Client
+}
+
+func NewWebhookChannel(secret string) *WebhookChannel {
+	return &WebhookChannel{Secret: secret, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *WebhookChannel) Name() string {
+	return models.ChannelWebhook
+}
+
+func (w *WebhookChannel) Send(ctx context.Context, store models.Store, n models.Notification) error {
+	if store.AlertWebhookURL == "" {
+		return errChannelNotConfigured
+	}
+
+	payload := map[string]any{
+		"id":         n.ID,
+		"store_id":   n.StoreID,
+		"type":       n.Type,
+		"title":      n.Title,
+		"body":       n.Body,
+		"created_at": n.CreatedAt,
+	}
+	if n.Data != "" {
+		payload["data"] = json.RawMessage(n.Data)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, store.AlertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	req.Header.Set(WebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
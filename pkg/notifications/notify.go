@@ -0,0 +1,132 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+const maxAttempts = 5
+
+// Channel delivers notifications outside the app
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, store models.Store, n models.Notification) error
+}
+
+// Emit adds a notification to the store's feed and queues a delivery on
+// every channel the store has configured. It is meant to run inside the
+// transaction that caused the notification, so nothing is sent for writes
+// that roll back.
+func Emit(tx *gorm.DB, storeID uint, notificationType, title, body string, data any) (*models.Notification, error) {
+	var store models.Store
+	if err := tx.First(&store, storeID).Error; err != nil {
+		return nil, err
+	}
+
+	n := models.Notification{
+		StoreID: storeID,
+		Type:    notificationType,
+		Title:   title,
+		Body:    body,
+	}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		n.Data = string(encoded)
+	}
+
+	if store.AlertEmail != "" {
+		n.Deliveries = append(n.Deliveries, models.NotificationDelivery{Channel: models.ChannelEmail, Status: models.DeliveryPending})
+	}
+	if store.AlertWebhookURL != "" {
+		n.Deliveries = append(n.Deliveries, models.NotificationDelivery{Channel: models.ChannelWebhook, Status: models.DeliveryPending})
+	}
+
+	if err := tx.Create(&n).Error; err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// Dispatcher sends queued deliveries through the registered channels
+type Dispatcher struct {
+	DB       *gorm.DB
+	Channels map[string]Channel
+}
+
+func NewDispatcher(db *gorm.DB, channels ...Channel) *Dispatcher {
+	d := &Dispatcher{DB: db, Channels: make(map[string]Channel)}
+	for _, ch := range channels {
+		d.Channels[ch.Name()] = ch
+	}
+	return d
+}
+
+// Run delivers pending notifications every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.DeliverPending(ctx); err != nil {
+				log.Printf("failed to deliver notifications: %v", err)
+			}
+		}
+	}
+}
+
+// DeliverPending makes one attempt at every pending delivery. Deliveries
+// that keep failing are given up on after a few attempts.
+func (d *Dispatcher) DeliverPending(ctx context.Context) error {
+	var deliveries []models.NotificationDelivery
+	if err := d.DB.Where("status = ?", models.DeliveryPending).Preload("Notification").Order("id").Limit(100).Find(&deliveries).Error; err != nil {
+		return err
+	}
+
+	for _, delivery := range deliveries {
+		if delivery.Notification == nil {
+			continue
+		}
+
+		var store models.Store
+		if err := d.DB.First(&store, delivery.Notification.StoreID).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]any{"attempts": delivery.Attempts + 1}
+		err := errChannelNotConfigured
+		if ch, ok := d.Channels[delivery.Channel]; ok {
+			err = ch.Send(ctx, store, *delivery.Notification)
+		}
+
+		if err == nil {
+			now := time.Now()
+			updates["status"] = models.DeliveryDelivered
+			updates["delivered_at"] = &now
+			updates["last_error"] = ""
+		} else {
+			updates["last_error"] = err.Error()
+			if errors.Is(err, errChannelNotConfigured) || delivery.Attempts+1 >= maxAttempts {
+				updates["status"] = models.DeliveryFailed
+			}
+		}
+
+		if err := d.DB.Model(&delivery).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
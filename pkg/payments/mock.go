@@ -0,0 +1,169 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Payment methods understood by the mock provider
+const (
+	MockMethodSuccess = "mock_success"
+	MockMethodFailure = "mock_failure"
+	MockMethod3DS     = "mock_3ds"
+)
+
+const MockSignatureHeader = "X-Mock-Signature"
+
+// MockProvider simulates a payment processor in memory. The payment method
+// decides the outcome: mock_failure is declined, mock_3ds requires a 3-D
+// Secure step that is completed by a signed webhook, anything else succeeds.
+type MockProvider struct {
+	secret  []byte
+	mu      sync.Mutex
+	intents map[string]*Intent
+	refunds map[string]float64
+	// keys holds refunds by idempotency key
+	keys map[string]*Refund
+}
+
+func NewMockProvider(secret string) *MockProvider {
+	return &MockProvider{
+		secret:  []byte(secret),
+		intents: make(map[string]*Intent),
+		refunds: make(map[string]float64),
+		keys:    make(map[string]*Refund),
+	}
+}
+
+func (m *MockProvider) Name() string {
+	return "mock"
+}
+
+func (m *MockProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	intent := &Intent{
+		ID:           "pi_mock_" + randomID(),
+		Amount:       req.Amount,
+		ClientSecret: "secret_" + randomID(),
+	}
+
+	switch req.PaymentMethod {
+	case MockMethodFailure:
+		intent.Status = IntentFailed
+		intent.FailureReason = "card_declined"
+	case MockMethod3DS:
+		intent.Status = IntentRequiresAction
+		intent.NextActionURL = "https://mock.payments.local/3ds/" + intent.ID
+	default:
+		if req.CaptureMethod == CaptureManual {
+			intent.Status = IntentRequiresCapture
+		} else {
+			intent.Status = IntentSucceeded
+			intent.CapturedAmount = req.Amount
+		}
+	}
+
+	m.mu.Lock()
+	m.intents[intent.ID] = intent
+	m.mu.Unlock()
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (m *MockProvider) Capture(ctx context.Context, intentID string, amount float64) (*Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	if intent.Status != IntentRequiresCapture || amount > intent.Amount {
+		return nil, ErrInvalidState
+	}
+
+	intent.Status = IntentSucceeded
+	intent.CapturedAmount = amount
+
+	copied := *intent
+	return &copied, nil
+}
+
+func (m *MockProvider) Refund(ctx context.Context, intentID string, amount float64, idempotencyKey string) (*Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if refund, ok := m.keys[idempotencyKey]; ok {
+		return refund, nil
+	}
+
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	if intent.Status != IntentSucceeded || m.refunds[intentID]+amount > intent.CapturedAmount {
+		return nil, ErrInvalidState
+	}
+
+	m.refunds[intentID] += amount
+	refund := &Refund{ID: "re_mock_" + randomID(), IntentID: intentID, Amount: amount, Status: IntentSucceeded}
+	m.keys[idempotencyKey] = refund
+	return refund, nil
+}
+
+// VerifyWebhook checks the hex HMAC-SHA256 of the payload in X-Mock-Signature.
+// Events for intents this process created also update the in-memory intent,
+// which is how a 3-D Secure challenge gets resolved.
+func (m *MockProvider) VerifyWebhook(payload []byte, header http.Header) (*WebhookEvent, error) {
+	if len(m.secret) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	signature, err := hex.DecodeString(header.Get(MockSignatureHeader))
+	if err != nil || !hmac.Equal(signature, m.sign(payload)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	if event.ID == "" || event.IntentID == "" {
+		return nil, fmt.Errorf("invalid webhook payload: id and intent_id are required")
+	}
+
+	m.mu.Lock()
+	if intent, ok := m.intents[event.IntentID]; ok && event.Status != "" {
+		intent.Status = event.Status
+		if event.Status == IntentSucceeded {
+			intent.CapturedAmount = intent.Amount
+		}
+	}
+	m.mu.Unlock()
+
+	return &event, nil
+}
+
+// Sign returns the signature header value the mock expects for a payload
+func (m *MockProvider) Sign(payload []byte) string {
+	return hex.EncodeToString(m.sign(payload))
+}
+
+func (m *MockProvider) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,110 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Intent statuses as reported by a provider
+const (
+	IntentRequiresAction  = "requires_action"
+	IntentRequiresCapture = "requires_capture"
+	IntentSucceeded       = "succeeded"
+	IntentFailed          = "failed"
+	IntentCanceled        = "canceled"
+)
+
+// ValidStatus reports whether status is one of the intent statuses
+func ValidStatus(status string) bool {
+	switch status {
+	case IntentRequiresAction, IntentRequiresCapture, IntentSucceeded, IntentFailed, IntentCanceled:
+		return true
+	}
+	return false
+}
+
+// Final reports whether an intent has settled: succeeded, failed and
+// canceled intents don't change any more
+func Final(status string) bool {
+	switch status {
+	case IntentSucceeded, IntentFailed, IntentCanceled:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether an intent may go from one status to
+// another. Final intents stay as they are; an event saying otherwise
+// arrived out of order.
+func CanTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+	if Final(from) {
+		return false
+	}
+	return from != IntentRequiresCapture || to != IntentRequiresAction
+}
+
+// Capture methods
+const (
+	CaptureAutomatic = "automatic"
+	CaptureManual    = "manual"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrIntentNotFound   = errors.New("payment intent not found")
+	ErrInvalidState     = errors.New("payment intent is not in a valid state for this operation")
+)
+
+// IntentRequest asks a provider to start collecting a payment
+type IntentRequest struct {
+	Amount        float64
+	Currency      string
+	Reference     string
+	PaymentMethod string
+	CaptureMethod string
+}
+
+// Intent is a provider-side payment
+type Intent struct {
+	ID             string  `json:"id"`
+	Status         string  `json:"status"`
+	Amount         float64 `json:"amount"`
+	CapturedAmount float64 `json:"captured_amount"`
+	ClientSecret   string  `json:"client_secret,omitempty"`
+	NextActionURL  string  `json:"next_action_url,omitempty"`
+	FailureReason  string  `json:"failure_reason,omitempty"`
+}
+
+// Refund is a provider-side refund of a captured intent
+type Refund struct {
+	ID       string  `json:"id"`
+	IntentID string  `json:"intent_id"`
+	Amount   float64 `json:"amount"`
+	Status   string  `json:"status"`
+}
+
+// WebhookEvent is a verified notification from a provider about an intent
+type WebhookEvent struct {
+	ID            string  `json:"id"`
+	Type          string  `json:"type"`
+	IntentID      string  `json:"intent_id"`
+	Status        string  `json:"status"`
+	Amount        float64 `json:"amount"`
+	FailureReason string  `json:"failure_reason,omitempty"`
+}
+
+// Provider is implemented by every payment processor integration.
+// Checkout code only talks to this interface. Refund is retried with the
+// same idempotency key until it gets an answer, providers must refund a
+// key at most once.
+type Provider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	Capture(ctx context.Context, intentID string, amount float64) (*Intent, error)
+	Refund(ctx context.Context, intentID string, amount float64, idempotencyKey string) (*Refund, error)
+	VerifyWebhook(payload []byte, header http.Header) (*WebhookEvent, error)
+}
@@ -0,0 +1,119 @@
+// Package pdf writes simple text-only PDF documents using the standard
+// Helvetica fonts, which every PDF reader ships with.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+const (
+	FontRegular = "F1"
+	FontBold    = "F2"
+)
+
+type textRun struct {
+	x, y float64
+	size float64
+	font string
+	text string
+}
+
+// Document is a PDF under construction
+type Document struct {
+	pages [][]textRun
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage starts a new page; text is always drawn on the last page
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, nil)
+}
+
+// Text draws a line of text with its baseline at (x, y), measured in points
+// from the top-left corner of the page
+func (d *Document) Text(x, y, size float64, font, text string) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	last := len(d.pages) - 1
+	d.pages[last] = append(d.pages[last], textRun{x: x, y: y, size: size, font: font, text: text})
+}
+
+// WriteTo serialises the document
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes
+	// two objects, the page and its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range d.pages {
+		var content bytes.Buffer
+		for _, run := range page {
+			fmt.Fprintf(&content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", run.font, run.size, run.x, PageHeight-run.y, escape(run.text))
+		}
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 6+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// escape makes text safe for a PDF string literal. Characters outside
+// Latin-1 can't be shown by the standard fonts and are replaced.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r > 255:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
@@ -1,9 +1,13 @@
 package routes
 
 import (
+	"os"
+	"time"
+
 	"github.com/blanc42/ecms/pkg/handlers"
 	"github.com/blanc42/ecms/pkg/initializers"
 	"github.com/blanc42/ecms/pkg/middleware"
+	"github.com/blanc42/ecms/pkg/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -51,6 +55,9 @@ func SetupRouter(re *gin.Engine) {
 		storeGroup.GET("/:store_id/categories/:category_id", categoryHandler.GetCategory)
 		storeGroup.PUT("/:store_id/categories/:category_id", categoryHandler.UpdateCategory)
 		storeGroup.DELETE("/:store_id/categories/:category_id", categoryHandler.DeleteCategory)
+		storeGroup.POST("/:store_id/categories/:category_id/move", categoryHandler.MoveCategory)
+		storeGroup.GET("/:store_id/categories/:category_id/subtree", categoryHandler.GetSubtree)
+		storeGroup.GET("/:store_id/categories/:category_id/ancestors", categoryHandler.GetAncestors)
 	}
 
 	variantHandler := handlers.NewVariantHandler(initializers.DB)
@@ -60,6 +67,11 @@ func SetupRouter(re *gin.Engine) {
 	storeGroup.GET("/:store_id/categories/:category_id/variants/:variant_id", variantHandler.GetVariant)
 	storeGroup.PUT("/:store_id/categories/:category_id/variants/:variant_id", variantHandler.UpdateVariant)
 	storeGroup.DELETE("/:store_id/categories/:category_id/variants/:variant_id", variantHandler.DeleteVariant)
+	storeGroup.POST("/:store_id/categories/:category_id/variants/:variant_id/options", variantHandler.AddVariantOption)
+	storeGroup.PUT("/:store_id/categories/:category_id/variants/:variant_id/override", variantHandler.SetVariantOverride)
+	storeGroup.DELETE("/:store_id/categories/:category_id/variants/:variant_id/override", variantHandler.DeleteVariantOverride)
+	storeGroup.GET("/:store_id/categories/:category_id/resolved-variants", variantHandler.ResolveVariants)
+	storeGroup.GET("/:store_id/products/:product_id/variants", variantHandler.ListProductVariants)
 
 	productHandler := handlers.NewProductHandler(initializers.DB)
 
@@ -68,5 +80,183 @@ func SetupRouter(re *gin.Engine) {
 	storeGroup.GET("/:store_id/products/:product_id", productHandler.GetProduct)
 	storeGroup.PUT("/:store_id/products/:product_id", productHandler.UpdateProduct)
 	storeGroup.DELETE("/:store_id/products/:product_id", productHandler.DeleteProduct)
+	storeGroup.GET("/:store_id/categories/:category_id/products", productHandler.ListCategoryProducts)
+
+	attributeHandler := handlers.NewAttributeHandler(initializers.DB)
+
+	storeGroup.GET("/:store_id/attributes", attributeHandler.ListAttributes)
+	storeGroup.POST("/:store_id/attributes", attributeHandler.CreateAttribute)
+	storeGroup.GET("/:store_id/attributes/:attribute_id", attributeHandler.GetAttribute)
+	storeGroup.PUT("/:store_id/attributes/:attribute_id", attributeHandler.UpdateAttribute)
+	storeGroup.DELETE("/:store_id/attributes/:attribute_id", attributeHandler.DeleteAttribute)
+	storeGroup.GET("/:store_id/attribute-sets", attributeHandler.ListAttributeSets)
+	storeGroup.POST("/:store_id/attribute-sets", attributeHandler.CreateAttributeSet)
+	storeGroup.GET("/:store_id/attribute-sets/:attribute_set_id", attributeHandler.GetAttributeSet)
+	storeGroup.PUT("/:store_id/attribute-sets/:attribute_set_id", attributeHandler.UpdateAttributeSet)
+	storeGroup.DELETE("/:store_id/attribute-sets/:attribute_set_id", attributeHandler.DeleteAttributeSet)
+	storeGroup.GET("/:store_id/categories/:category_id/attributes", attributeHandler.GetCategoryAttributes)
+	storeGroup.PUT("/:store_id/categories/:category_id/attribute-sets", attributeHandler.SetCategoryAttributeSets)
+	storeGroup.GET("/:store_id/products/:product_id/attributes", attributeHandler.GetProductAttributes)
+	storeGroup.PUT("/:store_id/products/:product_id/attributes", attributeHandler.SetProductAttributes)
+
+	collectionHandler := handlers.NewCollectionHandler(initializers.DB)
+
+	storeGroup.GET("/:store_id/collections", collectionHandler.ListCollections)
+	storeGroup.POST("/:store_id/collections", collectionHandler.CreateCollection)
+	storeGroup.GET("/:store_id/collections/:collection_id", collectionHandler.GetCollection)
+	storeGroup.PUT("/:store_id/collections/:collection_id", collectionHandler.UpdateCollection)
+	storeGroup.DELETE("/:store_id/collections/:collection_id", collectionHandler.DeleteCollection)
+	storeGroup.GET("/:store_id/collections/:collection_id/products", collectionHandler.ListCollectionProducts)
+	storeGroup.PUT("/:store_id/collections/:collection_id/products", collectionHandler.SetCollectionProducts)
+
+	metafieldHandler := handlers.NewMetafieldHandler(initializers.DB)
+	metafieldOwners := map[string]string{
+		handlers.MetafieldsOfStore:       "/:store_id",
+		handlers.MetafieldsOfCategory:    "/:store_id/categories/:category_id",
+		handlers.MetafieldsOfProduct:     "/:store_id/products/:product_id",
+		handlers.MetafieldsOfProductItem: "/:store_id/products/:product_id/items/:item_id",
+		handlers.MetafieldsOfCustomer:    "/:store_id/customers/:customer_id",
+	}
+	for owner, path := range metafieldOwners {
+		storeGroup.GET(path+"/metafields", metafieldHandler.ListMetafields(owner))
+		storeGroup.PUT(path+"/metafields", metafieldHandler.SetMetafields(owner))
+		storeGroup.DELETE(path+"/metafields/:namespace/:key", metafieldHandler.DeleteMetafield(owner))
+	}
+
+	searchHandler := handlers.NewSearchHandler(initializers.DB)
+
+	storeGroup.GET("/:store_id/search", searchHandler.SearchProducts)
+	storeGroup.GET("/:store_id/search/suggest", searchHandler.SuggestProducts)
+	storeGroup.GET("/:store_id/search/synonyms", searchHandler.ListSynonyms)
+	storeGroup.POST("/:store_id/search/synonyms", searchHandler.CreateSynonym)
+	storeGroup.PUT("/:store_id/search/synonyms/:synonym_id", searchHandler.UpdateSynonym)
+	storeGroup.DELETE("/:store_id/search/synonyms/:synonym_id", searchHandler.DeleteSynonym)
+
+	blobStore := initializers.BlobStore()
+	if local, ok := blobStore.(*storage.LocalStore); ok {
+		re.Static(initializers.MediaURLPath, local.Root)
+	}
+	imageHandler := handlers.NewProductImageHandler(initializers.DB, blobStore, initializers.Int64Env("MEDIA_MAX_UPLOAD_BYTES", 10<<20))
+
+	storeGroup.POST("/:store_id/products/:product_id/images", imageHandler.UploadImage)
+	storeGroup.GET("/:store_id/products/:product_id/images", imageHandler.ListImages)
+	storeGroup.PUT("/:store_id/products/:product_id/images", imageHandler.ReorderImages)
+	storeGroup.PATCH("/:store_id/products/:product_id/images/:image_id", imageHandler.UpdateImage)
+	storeGroup.DELETE("/:store_id/products/:product_id/images/:image_id", imageHandler.DeleteImage)
+
+	imageVariantHandler := handlers.NewImageVariantHandler(initializers.DB, blobStore, initializers.ImageCache(), os.Getenv("IMAGE_SIGNING_SECRET"))
+
+	storeGroup.GET("/:store_id/products/:product_id/images/:image_id/url", imageVariantHandler.SignImageURL)
+	r.GET("/images/:image_id", imageVariantHandler.ServeImage)
+
+	catalogHandler := handlers.NewCatalogHandler(initializers.DB, blobStore, initializers.Int64Env("CATALOG_MAX_UPLOAD_BYTES", 50<<20))
+	jobHandler := handlers.NewJobHandler(initializers.DB)
+
+	storeGroup.POST("/:store_id/imports", catalogHandler.Import)
+	storeGroup.POST("/:store_id/exports", catalogHandler.Export)
+	storeGroup.GET("/:store_id/exports/:job_id/download", catalogHandler.DownloadExport)
+	storeGroup.GET("/:store_id/jobs", jobHandler.ListJobs)
+	storeGroup.GET("/:store_id/jobs/:job_id", jobHandler.GetJob)
+
+	inventoryHandler := handlers.NewInventoryHandler(initializers.DB)
+
+	storeGroup.GET("/:store_id/products/:product_id/items/:item_id/movements", inventoryHandler.ListMovements)
+	storeGroup.POST("/:store_id/products/:product_id/items/:item_id/movements", inventoryHandler.CreateMovement)
+	storeGroup.POST("/:store_id/products/:product_id/items/:item_id/reconcile", inventoryHandler.Reconcile)
+
+	locationHandler := handlers.NewLocationHandler(initializers.DB)
+
+	storeGroup.POST("/:store_id/locations", locationHandler.CreateLocation)
+	storeGroup.GET("/:store_id/locations", locationHandler.ListLocations)
+	storeGroup.PUT("/:store_id/locations/:location_id", locationHandler.UpdateLocation)
+	storeGroup.DELETE("/:store_id/locations/:location_id", locationHandler.DeleteLocation)
+	storeGroup.GET("/:store_id/locations/:location_id/stock", locationHandler.ListLocationStock)
+	storeGroup.POST("/:store_id/stock-transfers", locationHandler.TransferStock)
+	storeGroup.GET("/:store_id/reports/low-stock", inventoryHandler.LowStockReport)
+
+	notificationHandler := handlers.NewNotificationHandler(initializers.DB)
+
+	storeGroup.GET("/:store_id/notifications", notificationHandler.ListNotifications)
+	storeGroup.POST("/:store_id/notifications/:notification_id/read", notificationHandler.MarkRead)
+
+	shippingHandler := handlers.NewShippingHandler(initializers.DB)
+
+	storeGroup.POST("/:store_id/shipping/zones", shippingHandler.CreateZone)
+	storeGroup.GET("/:store_id/shipping/zones", shippingHandler.ListZones)
+	storeGroup.GET("/:store_id/shipping/zones/:zone_id", shippingHandler.GetZone)
+	storeGroup.PUT("/:store_id/shipping/zones/:zone_id", shippingHandler.UpdateZone)
+	storeGroup.DELETE("/:store_id/shipping/zones/:zone_id", shippingHandler.DeleteZone)
+	storeGroup.PUT("/:store_id/shipping/zones/:zone_id/pincodes", shippingHandler.SetZonePincodes)
+	storeGroup.POST("/:store_id/shipping/zones/:zone_id/methods", shippingHandler.CreateMethod)
+	storeGroup.PUT("/:store_id/shipping/zones/:zone_id/methods/:method_id", shippingHandler.UpdateMethod)
+	storeGroup.DELETE("/:store_id/shipping/zones/:zone_id/methods/:method_id", shippingHandler.DeleteMethod)
+	storeGroup.POST("/:store_id/shipping/quote", shippingHandler.Quote)
+
+	paymentHandler := handlers.NewPaymentHandler(initializers.DB, initializers.PaymentProviders()...)
+
+	storeGroup.POST("/:store_id/orders/:order_id/payments", paymentHandler.CreatePayment)
+	storeGroup.GET("/:store_id/orders/:order_id/payments", paymentHandler.ListPayments)
+	storeGroup.POST("/:store_id/orders/:order_id/payments/:attempt_id/capture", paymentHandler.CapturePayment)
+	storeGroup.POST("/:store_id/orders/:order_id/refunds", paymentHandler.Refund)
+	storeGroup.POST("/:store_id/orders/:order_id/allocate", locationHandler.AllocateOrder)
+	storeGroup.GET("/:store_id/orders/:order_id/allocations", locationHandler.ListAllocations)
+	r.POST("/webhooks/payments/:provider", paymentHandler.Webhook)
+
+	returnHandler := handlers.NewReturnHandler(initializers.DB, paymentHandler)
+
+	storeGroup.POST("/:store_id/orders/:order_id/returns", returnHandler.CreateReturn)
+	storeGroup.GET("/:store_id/orders/:order_id/returns", returnHandler.ListReturns)
+	storeGroup.GET("/:store_id/orders/:order_id/returns/:return_id", returnHandler.GetReturn)
+	storeGroup.POST("/:store_id/orders/:order_id/returns/:return_id/approve", returnHandler.ApproveReturn)
+	storeGroup.POST("/:store_id/orders/:order_id/returns/:return_id/reject", returnHandler.RejectReturn)
+	storeGroup.PUT("/:store_id/orders/:order_id/returns/:return_id/items/:item_id/inspection", returnHandler.InspectReturnItem)
+	storeGroup.POST("/:store_id/orders/:order_id/returns/:return_id/refund", returnHandler.RefundReturn)
+
+	invoiceHandler := handlers.NewInvoiceHandler(initializers.DB)
+
+	storeGroup.GET("/:store_id/orders/:order_id/invoices", invoiceHandler.ListOrderInvoices)
+	storeGroup.GET("/:store_id/invoices/:invoice_id/pdf", invoiceHandler.DownloadInvoice)
+
+	feedHandler := handlers.NewFeedHandler(initializers.DB, blobStore)
+
+	storeGroup.GET("/:store_id/feeds", feedHandler.ListFeeds)
+	storeGroup.POST("/:store_id/feeds/regenerate", feedHandler.RegenerateFeeds)
+
+	customerHandler := handlers.NewCustomerHandler(initializers.DB)
+
+	storefront := r.Group("/storefront/:store_id")
+	storefront.POST("/signup", customerHandler.Signup)
+	storefront.POST("/login", customerHandler.Login)
+	storefront.GET("/products", productHandler.StorefrontListProducts)
+	storefront.GET("/products/by-slug/:slug", productHandler.StorefrontProductBySlug)
+	storefront.GET("/categories/by-slug/:slug", categoryHandler.StorefrontCategoryBySlug)
+	storefront.GET("/collections", collectionHandler.StorefrontListCollections)
+	storefront.GET("/collections/by-slug/:slug", collectionHandler.StorefrontCollectionBySlug)
+	storefront.GET("/collections/:collection_id/products", collectionHandler.StorefrontCollectionProducts)
+	storefront.GET("/search", searchHandler.StorefrontSearch)
+	storefront.GET("/search/suggest", searchHandler.StorefrontSuggest)
+	storefront.GET("/metafields", metafieldHandler.StorefrontMetafields(handlers.MetafieldsOfStore))
+	storefront.GET("/categories/:category_id/metafields", metafieldHandler.StorefrontMetafields(handlers.MetafieldsOfCategory))
+	storefront.GET("/products/:product_id/metafields", metafieldHandler.StorefrontMetafields(handlers.MetafieldsOfProduct))
+	storefront.GET("/products/:product_id/items/:item_id/metafields", metafieldHandler.StorefrontMetafields(handlers.MetafieldsOfProductItem))
+	storefront.GET("/feeds/:name", feedHandler.StorefrontFeed)
+
+	customerOnly := storefront.Group("/")
+	customerOnly.Use(middleware.CustomerAuthMiddleware())
+	customerOnly.GET("/orders/:order_id/invoices", invoiceHandler.ListCustomerInvoices)
+	customerOnly.GET("/invoices/:invoice_id/pdf", invoiceHandler.DownloadCustomerInvoice)
+	customerOnly.GET("/me/metafields", metafieldHandler.StorefrontMetafields(handlers.MetafieldsOfCustomer))
+
+	cartHandler := handlers.NewCartHandler(initializers.DB)
+	customerOnly.GET("/cart", cartHandler.GetCart)
+	customerOnly.POST("/cart/items", cartHandler.AddCartItem)
+	customerOnly.PATCH("/cart/items/:item_id", cartHandler.UpdateCartItem)
+	customerOnly.DELETE("/cart/items/:item_id", cartHandler.DeleteCartItem)
+
+	checkoutHandler := handlers.NewCheckoutHandler(initializers.DB, initializers.DurationEnv("RESERVATION_TTL", 15*time.Minute))
+
+	customerOnly.POST("/checkout", checkoutHandler.StartCheckout)
+	customerOnly.POST("/orders/:order_id/cancel", checkoutHandler.CancelCheckout)
+	customerOnly.POST("/orders/:order_id/payments", paymentHandler.CreateCustomerPayment)
 
 }
@@ -0,0 +1,54 @@
+// Package search ranks a store's products against free text. Products
+// carry a weighted tsvector of their name and SKUs (A), category name (B)
+// and description (C). Queries are expanded with the store's synonyms and
+// matched with full-text search first, falling back to pg_trgm similarity
+// when that finds nothing, which is what catches typos.
+package search
+
+import "gorm.io/gorm"
+
+// config is the text search configuration vectors and queries are built with
+const config = "'english'"
+
+const vectorSQL = `UPDATE products p SET search_vector =
+	setweight(to_tsvector(` + config + `, coalesce(p.name, '')), 'A') ||
+	setweight(to_tsvector(` + config + `, coalesce((SELECT string_agg(i.sku, ' ') FROM product_items i WHERE i.product_id = p.id AND i.deleted_at IS NULL), '')), 'A') ||
+	setweight(to_tsvector(` + config + `, coalesce((SELECT c.name FROM categories c WHERE c.id = p.category_id), '')), 'B') ||
+	setweight(to_tsvector(` + config + `, coalesce(p.description, '')), 'C')
+WHERE p.deleted_at IS NULL`
+
+// EnsureSchema sets up what search needs beyond the tables: the pg_trgm
+// extension, the search_vector column and the indexes behind both kinds of
+// matching. It is safe to run on every start and indexes any product that
+// has no vector yet.
+func EnsureSchema(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+		`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+		`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+		`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`,
+		`CREATE INDEX IF NOT EXISTS idx_product_items_sku_trgm ON product_items USING GIN (sku gin_trgm_ops)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return db.Exec(vectorSQL + " AND p.search_vector IS NULL").Error
+}
+
+// IndexProducts rebuilds the search vectors of products. It has to run
+// whenever a product, its SKUs or its category change, in the same
+// transaction as the change.
+func IndexProducts(db *gorm.DB, productIDs ...uint) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+	return db.Exec(vectorSQL+" AND p.id IN ?", productIDs).Error
+}
+
+// IndexCategory rebuilds the search vectors of a category's products, e.g.
+// after it was renamed
+func IndexCategory(db *gorm.DB, categoryID uint) error {
+	return db.Exec(vectorSQL+" AND p.category_id = ?", categoryID).Error
+}
@@ -0,0 +1,103 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Synonyms expands query terms with the equivalent terms a store
+// configured. Terms may be phrases; the longest match wins.
+type Synonyms struct {
+	groups  map[string][][]string
+	longest int
+}
+
+// NewSynonyms indexes groups of equivalent terms
+func NewSynonyms(groups [][]string) *Synonyms {
+	s := &Synonyms{groups: make(map[string][][]string)}
+	for _, group := range groups {
+		var terms [][]string
+		for _, term := range group {
+			if words := Tokens(term); len(words) > 0 {
+				terms = append(terms, words)
+			}
+		}
+		for _, words := range terms {
+			s.groups[strings.Join(words, " ")] = terms
+			s.longest = max(s.longest, len(words))
+		}
+	}
+	return s
+}
+
+// LoadSynonyms reads a store's synonym groups
+func LoadSynonyms(db *gorm.DB, storeID uint) (*Synonyms, error) {
+	var synonyms []models.SearchSynonym
+	if err := db.Where("store_id = ?", storeID).Find(&synonyms).Error; err != nil {
+		return nil, err
+	}
+	groups := make([][]string, len(synonyms))
+	for i, synonym := range synonyms {
+		groups[i] = synonym.Terms
+	}
+	return NewSynonyms(groups), nil
+}
+
+// Tokens lowercases text and splits it into words of letters and digits.
+// Nothing else survives, so tokens are safe to put into a tsquery.
+func Tokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// TSQuery builds a to_tsquery expression that needs every word of text to
+// match, each through any of its synonyms. With prefix the last word also
+// matches words it is the start of, for search as you type. It is empty
+// when text has no words.
+func (s *Synonyms) TSQuery(text string, prefix bool) string {
+	words := Tokens(text)
+
+	var parts []string
+	for i := 0; i < len(words); {
+		n, alternatives := s.match(words[i:])
+		last := i+n == len(words)
+
+		options := make([]string, len(alternatives))
+		for j, alternative := range alternatives {
+			phrase := strings.Join(alternative, " <-> ")
+			// Only the words actually typed can be unfinished
+			if prefix && last && j == 0 {
+				phrase += ":*"
+			}
+			options[j] = "(" + phrase + ")"
+		}
+		parts = append(parts, "("+strings.Join(options, " | ")+")")
+		i += n
+	}
+	return strings.Join(parts, " & ")
+}
+
+// match finds the longest synonym term words start with. It returns how
+// many words it covers and the alternatives to search for, the words
+// themselves first.
+func (s *Synonyms) match(words []string) (int, [][]string) {
+	for n := min(s.longest, len(words)); n > 0; n-- {
+		key := strings.Join(words[:n], " ")
+		group, ok := s.groups[key]
+		if !ok {
+			continue
+		}
+		alternatives := [][]string{words[:n]}
+		for _, term := range group {
+			if strings.Join(term, " ") != key {
+				alternatives = append(alternatives, term)
+			}
+		}
+		return n, alternatives
+	}
+	return 1, [][]string{words[:1]}
+}
@@ -0,0 +1,327 @@
+package search
+
+import (
+	"strings"
+	"time"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// How results were matched
+const (
+	ModeFullText = "fulltext"
+	ModeFuzzy    = "fuzzy"
+	ModeBrowse   = "browse"
+)
+
+// effectivePrice is what a SKU sells for
+const effectivePrice = "CASE WHEN product_items.discounted_price > 0 THEN product_items.discounted_price ELSE product_items.price END"
+
+// Filter narrows results down. Unset fields don't filter.
+type Filter struct {
+	CategoryIDs []uint
+	Featured    *bool
+	Archived    *bool
+	// MinPrice and MaxPrice keep products with a SKU selling in the range
+	MinPrice *float64
+	MaxPrice *float64
+	// Attributes keeps products whose attribute values pass every condition
+	Attributes []AttributeCondition
+}
+
+// AttributeCondition compares the value of one attribute. Column is the
+// value column of the attribute's type, Op one of eq, in, gte and lte.
+type AttributeCondition struct {
+	AttributeID uint
+	Column      string
+	Op          string
+	Value       any
+}
+
+// Request is a search of one store's products
+type Request struct {
+	StoreID uint
+	Text    string
+	Prefix  bool
+	Filter  Filter
+	Offset  int
+	Limit   int
+	// Facets asks for counts to refine the results by
+	Facets bool
+}
+
+type CategoryFacet struct {
+	CategoryID uint   `json:"category_id"`
+	Name       string `json:"name"`
+	Count      int64  `json:"count"`
+}
+
+// AttributeFacet counts the values of a filterable attribute. Text, enum
+// and boolean attributes list their values, number and date attributes
+// give their range.
+type AttributeFacet struct {
+	Code   string           `json:"code"`
+	Name   string           `json:"name"`
+	Type   string           `json:"type"`
+	Unit   string           `json:"unit,omitempty"`
+	Values []AttributeCount `json:"values,omitempty"`
+	Min    any              `json:"min,omitempty"`
+	Max    any              `json:"max,omitempty"`
+}
+
+type AttributeCount struct {
+	Value any   `json:"value"`
+	Count int64 `json:"count"`
+}
+
+// Facets describe all matching products. Category counts ignore the
+// category filter and each attribute's counts ignore its own conditions,
+// so other values can still be offered.
+type Facets struct {
+	Categories []CategoryFacet  `json:"categories"`
+	MinPrice   *float64         `json:"min_price"`
+	MaxPrice   *float64         `json:"max_price"`
+	Attributes []AttributeFacet `json:"attributes"`
+}
+
+// Result holds one page of matching product IDs, best match first
+type Result struct {
+	ProductIDs []uint  `json:"-"`
+	Total      int64   `json:"total"`
+	Mode       string  `json:"mode"`
+	Facets     *Facets `json:"facets,omitempty"`
+}
+
+// matcher is one way of matching and ranking products
+type matcher struct {
+	mode     string
+	where    string
+	whereArg []any
+	rank     string
+	rankArg  []any
+}
+
+// Search ranks a store's products against the request's text. Full-text
+// matches come first; only when there are none is the text compared by
+// trigram similarity, which tolerates misspellings. Without text the
+// filtered products are listed newest first.
+func Search(db *gorm.DB, req Request) (*Result, error) {
+	synonyms, err := LoadSynonyms(db, req.StoreID)
+	if err != nil {
+		return nil, err
+	}
+
+	tsquery := synonyms.TSQuery(req.Text, req.Prefix)
+	if tsquery == "" {
+		return run(db, req, matcher{mode: ModeBrowse, rank: "0"})
+	}
+
+	result, err := run(db, req, matcher{
+		mode:     ModeFullText,
+		where:    "products.search_vector @@ to_tsquery(" + config + ", ?)",
+		whereArg: []any{tsquery},
+		rank:     "ts_rank_cd(products.search_vector, to_tsquery(" + config + ", ?))",
+		rankArg:  []any{tsquery},
+	})
+	if err != nil || result.Total > 0 {
+		return result, err
+	}
+
+	text := strings.Join(Tokens(req.Text), " ")
+	return run(db, req, matcher{
+		mode: ModeFuzzy,
+		where: "(? <% products.name OR EXISTS (SELECT 1 FROM product_items i " +
+			"WHERE i.product_id = products.id AND i.deleted_at IS NULL AND i.sku % ?))",
+		whereArg: []any{text, text},
+		rank: "GREATEST(word_similarity(?, products.name), coalesce((SELECT max(similarity(i.sku, ?)) FROM product_items i " +
+			"WHERE i.product_id = products.id AND i.deleted_at IS NULL), 0))",
+		rankArg: []any{text, text},
+	})
+}
+
+func run(db *gorm.DB, req Request, m matcher) (*Result, error) {
+	result := &Result{Mode: m.mode}
+	if err := m.scope(db, req, true, 0).Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+
+	if result.Total > 0 {
+		var hits []struct{ ID uint }
+		err := m.scope(db, req, true, 0).
+			Select("products.id, "+m.rank+" AS rank", m.rankArg...).
+			Order("rank DESC, products.id DESC").
+			Offset(req.Offset).Limit(req.Limit).
+			Scan(&hits).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range hits {
+			result.ProductIDs = append(result.ProductIDs, hit.ID)
+		}
+	}
+
+	if req.Facets {
+		facets, err := m.facets(db, req)
+		if err != nil {
+			return nil, err
+		}
+		result.Facets = facets
+	}
+	return result, nil
+}
+
+func (m matcher) facets(db *gorm.DB, req Request) (*Facets, error) {
+	facets := &Facets{Categories: []CategoryFacet{}}
+
+	err := m.scope(db, req, false, 0).
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Select("products.category_id, categories.name, count(*) AS count").
+		Group("products.category_id, categories.name").
+		Order("count DESC, categories.name").
+		Scan(&facets.Categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var prices struct {
+		MinPrice *float64
+		MaxPrice *float64
+	}
+	err = db.Model(&models.ProductItem{}).
+		Select("min("+effectivePrice+") AS min_price, max("+effectivePrice+") AS max_price").
+		Where("product_items.product_id IN (?)", m.scope(db, req, true, 0).Select("products.id")).
+		Scan(&prices).Error
+	if err != nil {
+		return nil, err
+	}
+	facets.MinPrice, facets.MaxPrice = prices.MinPrice, prices.MaxPrice
+
+	facets.Attributes, err = m.attributeFacets(db, req)
+	if err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
+
+// attributeFacets counts the values of the store's filterable attributes.
+// An attribute the request filters by is counted without its conditions.
+func (m matcher) attributeFacets(db *gorm.DB, req Request) ([]AttributeFacet, error) {
+	facets := []AttributeFacet{}
+
+	var attributes []models.Attribute
+	if err := db.Where("store_id = ? AND filterable", req.StoreID).Order("name, id").Find(&attributes).Error; err != nil {
+		return nil, err
+	}
+
+	for _, attribute := range attributes {
+		except := uint(0)
+		for _, condition := range req.Filter.Attributes {
+			if condition.AttributeID == attribute.ID {
+				except = attribute.ID
+			}
+		}
+		values := db.Model(&models.ProductAttributeValue{}).
+			Where("product_attribute_values.attribute_id = ?", attribute.ID).
+			Where("product_attribute_values.product_id IN (?)", m.scope(db, req, true, except).Select("products.id"))
+
+		facet := AttributeFacet{Code: attribute.Code, Name: attribute.Name, Type: attribute.Type, Unit: attribute.Unit}
+		column := ValueColumn(attribute.Type)
+		switch column {
+		case "number", "date":
+			var bounds struct {
+				Min, Max any
+			}
+			if err := values.Select("min(" + column + ") AS min, max(" + column + ") AS max").Scan(&bounds).Error; err != nil {
+				return nil, err
+			}
+			if bounds.Min == nil {
+				continue
+			}
+			facet.Min, facet.Max = facetValue(bounds.Min), facetValue(bounds.Max)
+		default:
+			var counts []struct {
+				Value any
+				Count int64
+			}
+			err := values.Select(column + " AS value, count(*) AS count").
+				Group(column).Order("count DESC, value").Scan(&counts).Error
+			if err != nil {
+				return nil, err
+			}
+			if len(counts) == 0 {
+				continue
+			}
+			for _, count := range counts {
+				facet.Values = append(facet.Values, AttributeCount{Value: count.Value, Count: count.Count})
+			}
+		}
+		facets = append(facets, facet)
+	}
+	return facets, nil
+}
+
+// facetValue shows dates without their time
+func facetValue(value any) any {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.DateOnly)
+	}
+	return value
+}
+
+// ValueColumn is the product_attribute_values column holding values of
+// an attribute type
+func ValueColumn(attributeType string) string {
+	switch attributeType {
+	case "number":
+		return "number"
+	case "boolean":
+		return "bool"
+	case "date":
+		return "date"
+	}
+	return "text"
+}
+
+// scope selects the store's matching products that pass the filter,
+// leaving out the category filter or one attribute's conditions for facet
+// counts
+func (m matcher) scope(db *gorm.DB, req Request, byCategory bool, exceptAttribute uint) *gorm.DB {
+	query := db.Model(&models.Product{}).Where("products.store_id = ?", req.StoreID)
+	if m.where != "" {
+		query = query.Where(m.where, m.whereArg...)
+	}
+
+	filter := req.Filter
+	if byCategory && len(filter.CategoryIDs) > 0 {
+		query = query.Where("products.category_id IN ?", filter.CategoryIDs)
+	}
+	if filter.Featured != nil {
+		query = query.Where("products.is_featured = ?", *filter.Featured)
+	}
+	if filter.Archived != nil {
+		query = query.Where("products.is_archived = ?", *filter.Archived)
+	}
+	if filter.MinPrice != nil || filter.MaxPrice != nil {
+		items := db.Model(&models.ProductItem{}).Select("1").Where("product_items.product_id = products.id")
+		if filter.MinPrice != nil {
+			items = items.Where(effectivePrice+" >= ?", *filter.MinPrice)
+		}
+		if filter.MaxPrice != nil {
+			items = items.Where(effectivePrice+" <= ?", *filter.MaxPrice)
+		}
+		query = query.Where("EXISTS (?)", items)
+	}
+	for _, condition := range filter.Attributes {
+		if condition.AttributeID == exceptAttribute {
+			continue
+		}
+		values := db.Model(&models.ProductAttributeValue{}).Select("1").
+			Where("product_attribute_values.product_id = products.id AND product_attribute_values.attribute_id = ?", condition.AttributeID).
+			Where("product_attribute_values."+condition.Column+" "+attributeOperators[condition.Op]+" ?", condition.Value)
+		query = query.Where("EXISTS (?)", values)
+	}
+	return query
+}
+
+var attributeOperators = map[string]string{"eq": "=", "in": "IN", "gte": ">=", "lte": "<="}
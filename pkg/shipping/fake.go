@@ -0,0 +1,26 @@
+package shipping
+
+import (
+	"context"
+	"errors"
+)
+
+// FakeCarrier is a local CarrierRateProvider for development and testing.
+// It charges a base price plus a price per started kilogram.
+type FakeCarrier struct {
+	Base       float64
+	PerKg      float64
+	Unservable map[string]bool
+}
+
+func NewFakeCarrier() *FakeCarrier {
+	return &FakeCarrier{Base: 5, PerKg: 1.5, Unservable: map[string]bool{}}
+}
+
+func (f *FakeCarrier) Rate(ctx context.Context, dest Destination, parcel Parcel) (float64, error) {
+	if f.Unservable[dest.Pincode] {
+		return 0, errors.New("fake carrier does not serve this pincode")
+	}
+	kgs := (parcel.WeightGrams + 999) / 1000
+	return f.Base + float64(kgs)*f.PerKg, nil
+}
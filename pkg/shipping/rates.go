@@ -0,0 +1,125 @@
+package shipping
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/blanc42/ecms/pkg/models"
+)
+
+var ErrNoMatchingRate = errors.New("no rate matches the parcel")
+
+// Parcel is what is being shipped, summarised from a cart
+type Parcel struct {
+	WeightGrams int
+	Subtotal    float64
+}
+
+// Destination is where the parcel is shipped to
+type Destination struct {
+	CountryID uint
+	City      string
+	Pincode   string
+}
+
+// Quote is the price of shipping a parcel with one method
+type Quote struct {
+	MethodID uint    `json:"method_id"`
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Carrier  string  `json:"carrier,omitempty"`
+	Amount   float64 `json:"amount"`
+}
+
+// CarrierRateProvider looks up live rates from a carrier
+type CarrierRateProvider interface {
+	Rate(ctx context.Context, dest Destination, parcel Parcel) (float64, error)
+}
+
+// Calculate returns the shipping price of a parcel for a store-defined method.
+// Carrier methods are priced by the provider registered under method.Carrier.
+func Calculate(ctx context.Context, method models.ShippingMethod, dest Destination, parcel Parcel, carriers map[string]CarrierRateProvider) (float64, error) {
+	switch method.Type {
+	case models.ShippingMethodFlat:
+		return method.FlatRate, nil
+	case models.ShippingMethodFreeOver:
+		if parcel.Subtotal >= method.FreeOver {
+			return 0, nil
+		}
+		return method.FlatRate, nil
+	case models.ShippingMethodWeight:
+		return matchRate(method.Rates, float64(parcel.WeightGrams))
+	case models.ShippingMethodPriceTier:
+		return matchRate(method.Rates, parcel.Subtotal)
+	case models.ShippingMethodCarrier:
+		provider, ok := carriers[method.Carrier]
+		if !ok {
+			return 0, fmt.Errorf("unknown carrier %q", method.Carrier)
+		}
+		return provider.Rate(ctx, dest, parcel)
+	}
+	return 0, fmt.Errorf("unknown shipping method type %q", method.Type)
+}
+
+func matchRate(rates []models.ShippingRate, value float64) (float64, error) {
+	for _, rate := range rates {
+		if value >= rate.Min && (rate.Max == 0 || value < rate.Max) {
+			return rate.Price, nil
+		}
+	}
+	return 0, ErrNoMatchingRate
+}
+
+// CheckRates makes sure rate tiers are well formed. A tier covers min up
+// to, but not including, max; a max of 0 leaves the top tier open. Tiers
+// must not overlap, so every value matches at most one.
+func CheckRates(rates []models.ShippingRate) error {
+	sorted := slices.Clone(rates)
+	slices.SortFunc(sorted, func(a, b models.ShippingRate) int { return cmp.Compare(a.Min, b.Min) })
+	for i, rate := range sorted {
+		if rate.Max != 0 && rate.Max <= rate.Min {
+			return fmt.Errorf("the rate from %g needs a max above its min, or 0 for no upper bound", rate.Min)
+		}
+		if i > 0 && (sorted[i-1].Max == 0 || rate.Min < sorted[i-1].Max) {
+			return fmt.Errorf("the rates from %g and from %g overlap", sorted[i-1].Min, rate.Min)
+		}
+	}
+	return nil
+}
+
+// ValidMethodType reports whether t is a known shipping method type
+func ValidMethodType(t string) bool {
+	switch t {
+	case models.ShippingMethodFlat, models.ShippingMethodFreeOver, models.ShippingMethodWeight,
+		models.ShippingMethodPriceTier, models.ShippingMethodCarrier:
+		return true
+	}
+	return false
+}
+
+// ZoneServes reports whether a zone ships to the destination
+func ZoneServes(zone models.ShippingZone, dest Destination) bool {
+	countryMatch := false
+	for _, country := range zone.Countries {
+		if country.ID == dest.CountryID {
+			countryMatch = true
+			break
+		}
+	}
+	if !countryMatch {
+		return false
+	}
+
+	if len(zone.Pincodes) == 0 {
+		return true
+	}
+	for _, p := range zone.Pincodes {
+		if p.Pincode == dest.Pincode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+// Package slugs gives products, categories and collections readable URL
+// slugs, unique within their store. A slug is made from the name unless
+// one is given. Slugs a record used to have are kept as redirects so old
+// URLs keep resolving.
+package slugs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/blanc42/ecms/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Kinds of records with slugs, the tables they live in
+const (
+	Product    = "products"
+	Category   = "categories"
+	Collection = "collections"
+)
+
+// MaxLength caps slugs, leaving room for a numeric suffix
+const MaxLength = 200
+
+var (
+	ErrInvalid = errors.New("slug may only contain lowercase letters, digits and single dashes between them")
+	ErrTaken   = errors.New("slug is already used in this store")
+)
+
+var slugPattern = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}]+(-[\p{Ll}\p{Lo}\p{N}]+)*$`)
+
+// EnsureSchema adds the per-store unique indexes on slugs and gives every
+// record without a slug one made from its name
+func EnsureSchema(db *gorm.DB) error {
+	for _, kind := range []string{Product, Category, Collection} {
+		err := db.Exec(fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS idx_%s_store_slug ON %s (store_id, slug) WHERE deleted_at IS NULL AND slug <> ''`, kind, kind)).Error
+		if err != nil {
+			return err
+		}
+
+		var rows []struct {
+			ID      uint
+			StoreID uint
+			Name    string
+		}
+		if err := db.Table(kind).Select("id, store_id, name").Where("slug = '' AND deleted_at IS NULL").Order("id").Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			slug, err := Unique(db, kind, row.StoreID, row.ID, Make(row.Name))
+			if err != nil {
+				return err
+			}
+			if err := db.Table(kind).Where("id = ?", row.ID).Update("slug", slug).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Make turns a name into a slug: lowercase letters and digits with dashes
+// for everything in between
+func Make(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	slug := b.String()
+	if len(slug) > MaxLength {
+		slug = strings.TrimRight(truncate(slug, MaxLength), "-")
+	}
+	return slug
+}
+
+// Check validates a slug given by hand
+func Check(slug string) error {
+	if len(slug) > MaxLength || !slugPattern.MatchString(slug) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Unique finds a slug like base no other record of kind in the store uses,
+// numbering it if needed. id is the record the slug is for, 0 when new.
+func Unique(tx *gorm.DB, kind string, storeID, id uint, base string) (string, error) {
+	if base == "" {
+		base = strings.TrimSuffix(kind, "s")
+	}
+	for n := 1; ; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken, err := taken(tx, kind, storeID, id, slug)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+	}
+}
+
+// Assign settles the slug of a record. A requested slug is checked and
+// must be free, otherwise a new record gets one made from its name and an
+// existing one keeps its own. The slug the record had before is kept as a
+// redirect to it.
+func Assign(tx *gorm.DB, kind string, storeID, id uint, current, requested, name string) (string, error) {
+	slug := current
+	switch {
+	case requested != "":
+		if err := Check(requested); err != nil {
+			return "", err
+		}
+		isTaken, err := taken(tx, kind, storeID, id, requested)
+		if err != nil {
+			return "", err
+		}
+		if isTaken {
+			return "", ErrTaken
+		}
+		slug = requested
+	case current == "":
+		var err error
+		if slug, err = Unique(tx, kind, storeID, id, Make(name)); err != nil {
+			return "", err
+		}
+	}
+	if slug == current {
+		return slug, nil
+	}
+
+	// A live slug wins over a redirect of the same name
+	if err := tx.Unscoped().Where("store_id = ? AND kind = ? AND slug = ?", storeID, kind, slug).Delete(&models.SlugRedirect{}).Error; err != nil {
+		return "", err
+	}
+	if current != "" {
+		redirect := models.SlugRedirect{StoreID: storeID, Kind: kind, Slug: current, TargetID: id}
+		if err := tx.Create(&redirect).Error; err != nil {
+			return "", err
+		}
+	}
+	return slug, nil
+}
+
+// Resolve finds the record of kind a slug names. If the slug is an old
+// one, moved is set and the record's current slug returned.
+func Resolve(tx *gorm.DB, kind string, storeID uint, slug string) (id uint, current string, moved bool, err error) {
+	var row struct {
+		ID   uint
+		Slug string
+	}
+	err = tx.Table(kind).Select("id, slug").Where("store_id = ? AND slug = ? AND deleted_at IS NULL", storeID, slug).Limit(1).Scan(&row).Error
+	if err != nil || row.ID != 0 {
+		return row.ID, row.Slug, false, err
+	}
+
+	var redirect models.SlugRedirect
+	err = tx.Where("store_id = ? AND kind = ? AND slug = ?", storeID, kind, slug).Limit(1).Find(&redirect).Error
+	if err != nil || redirect.ID == 0 {
+		return 0, "", false, err
+	}
+	err = tx.Table(kind).Select("id, slug").Where("id = ? AND deleted_at IS NULL", redirect.TargetID).Limit(1).Scan(&row).Error
+	if err != nil || row.ID == 0 {
+		return 0, "", false, err
+	}
+	return row.ID, row.Slug, true, nil
+}
+
+// DeleteRedirects drops the redirects to deleted records, given as IDs or
+// a query selecting them
+func DeleteRedirects(tx *gorm.DB, kind string, ids any) error {
+	return tx.Unscoped().Where("kind = ? AND target_id IN (?)", kind, ids).Delete(&models.SlugRedirect{}).Error
+}
+
+func taken(tx *gorm.DB, kind string, storeID, id uint, slug string) (bool, error) {
+	var count int64
+	err := tx.Table(kind).Where("store_id = ? AND slug = ? AND id <> ? AND deleted_at IS NULL", storeID, slug, id).Count(&count).Error
+	return count > 0, err
+}
+
+// truncate cuts s to at most n bytes without splitting a rune
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := 0
+	for i := range s {
+		if i > n {
+			break
+		}
+		cut = i
+	}
+	return s[:cut]
+}
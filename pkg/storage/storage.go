@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Blob describes a stored object
+type Blob struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// BlobStore keeps binary objects such as product images under string keys.
+// Keys use forward slashes, e.g. "products/12/abc.jpg".
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]Blob, error)
+	// URL is where clients can fetch the blob
+	URL(key string) string
+}
@@ -32,9 +32,51 @@ func VerifyToken(tokenString string) (uint, error) {
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		adminID := uint(claims["admin_id"].(float64))
-		return adminID, nil
+		adminID, ok := claims["admin_id"].(float64)
+		if !ok {
+			return 0, jwt.ErrTokenInvalidClaims
+		}
+		return uint(adminID), nil
 	}
 
 	return 0, jwt.ErrSignatureInvalid
 }
+
+// GenerateCustomerToken generates a new JWT token for a storefront customer
+func GenerateCustomerToken(customer models.Customer) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["customer_id"] = customer.ID
+	claims["store_id"] = customer.StoreID
+	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// VerifyCustomerToken returns the customer and store IDs of a customer token
+func VerifyCustomerToken(tokenString string) (uint, uint, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		customerID, ok := claims["customer_id"].(float64)
+		if !ok {
+			return 0, 0, jwt.ErrTokenInvalidClaims
+		}
+		storeID, ok := claims["store_id"].(float64)
+		if !ok {
+			return 0, 0, jwt.ErrTokenInvalidClaims
+		}
+		return uint(customerID), uint(storeID), nil
+	}
+
+	return 0, 0, jwt.ErrSignatureInvalid
+}
@@ -0,0 +1,183 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Reader reads the cell text of a workbook's sheets. Shared strings,
+// inline strings, numbers and booleans are understood; formulas read as
+// their cached value and styles, including date formats, are ignored.
+type Reader struct {
+	zr      *zip.Reader
+	names   []string
+	paths   map[string]string
+	strings []string
+}
+
+// NewReader opens a workbook of the given size
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.New("xlsx: not a workbook")
+	}
+	x := &Reader{zr: zr, paths: make(map[string]string)}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := x.decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := x.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+	for _, sheet := range workbook.Sheets {
+		if target, ok := targets[sheet.ID]; ok {
+			x.names = append(x.names, sheet.Name)
+			x.paths[sheet.Name] = target
+		}
+	}
+	if len(x.names) == 0 {
+		return nil, errors.New("xlsx: the workbook has no sheets")
+	}
+
+	var shared struct {
+		Items []inlineString `xml:"si"`
+	}
+	if err := x.decode("xl/sharedStrings.xml", &shared); err != nil && !errors.Is(err, errMissingPart) {
+		return nil, err
+	}
+	for _, item := range shared.Items {
+		x.strings = append(x.strings, item.text())
+	}
+	return x, nil
+}
+
+// Sheets names the workbook's sheets in order
+func (x *Reader) Sheets() []string {
+	return x.names
+}
+
+// ReadSheet returns the text of a sheet's cells, one slice per row. Empty
+// rows are left out and empty cells are "".
+func (x *Reader) ReadSheet(name string) ([][]string, error) {
+	part, ok := x.paths[name]
+	if !ok {
+		return nil, fmt.Errorf("xlsx: no sheet named %q", name)
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string       `xml:"r,attr"`
+				Type   string       `xml:"t,attr"`
+				Value  string       `xml:"v"`
+				Inline inlineString `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := x.decode(part, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		var cells []string
+		for i, cell := range row.Cells {
+			column := i
+			if cell.Ref != "" {
+				column = columnIndex(cell.Ref)
+			}
+			if column < len(cells) {
+				column = len(cells)
+			}
+
+			var text string
+			switch cell.Type {
+			case "s":
+				var n int
+				if _, err := fmt.Sscan(cell.Value, &n); err != nil || n < 0 || n >= len(x.strings) {
+					return nil, fmt.Errorf("xlsx: cell %s refers to a missing shared string", cell.Ref)
+				}
+				text = x.strings[n]
+			case "inlineStr":
+				text = cell.Inline.text()
+			case "b":
+				text = map[string]string{"0": "false", "1": "true"}[cell.Value]
+			default:
+				text = cell.Value
+			}
+
+			for len(cells) < column {
+				cells = append(cells, "")
+			}
+			cells = append(cells, text)
+		}
+		if strings.Join(cells, "") != "" {
+			rows = append(rows, cells)
+		}
+	}
+	return rows, nil
+}
+
+var errMissingPart = errors.New("xlsx: missing part")
+
+func (x *Reader) decode(name string, v any) error {
+	f, err := x.zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w %s", errMissingPart, name)
+	}
+	defer f.Close()
+
+	if err := xml.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("xlsx: %s: %w", name, err)
+	}
+	return nil
+}
+
+// inlineString is plain text or a run of formatted pieces
+type inlineString struct {
+	Text string   `xml:"t"`
+	Runs []string `xml:"r>t"`
+}
+
+func (s inlineString) text() string {
+	return s.Text + strings.Join(s.Runs, "")
+}
+
+// columnIndex turns a cell reference like "AB12" into a zero-based column
+// index, the inverse of columnName
+func columnIndex(ref string) int {
+	i := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		i = i*26 + int(r-'A') + 1
+	}
+	return i - 1
+}
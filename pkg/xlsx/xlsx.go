@@ -0,0 +1,172 @@
+// Package xlsx streams simple spreadsheets in the Office Open XML format
+// and reads the cell text back. Written cells hold inline strings, numbers
+// or booleans; there is no styling. Rows are written straight into the zip
+// archive, so sheets of any size take constant memory.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Writer writes a workbook one sheet at a time
+type Writer struct {
+	zw     *zip.Writer
+	sheets []string
+	sheet  *bufio.Writer
+	row    int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// AddSheet finishes the current sheet and starts a new one
+func (w *Writer) AddSheet(name string) error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+
+	w.sheets = append(w.sheets, name)
+	f, err := w.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)))
+	if err != nil {
+		return err
+	}
+	w.sheet = bufio.NewWriter(f)
+	w.row = 0
+
+	_, err = w.sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return err
+}
+
+// WriteRow appends a row to the current sheet. Values may be strings,
+// integers, floats, booleans, times or nil for an empty cell.
+func (w *Writer) WriteRow(values ...any) error {
+	if w.sheet == nil {
+		return errors.New("xlsx: no sheet to write to")
+	}
+
+	w.row++
+	fmt.Fprintf(w.sheet, `<row r="%d">`, w.row)
+	for i, value := range values {
+		ref := columnName(i) + strconv.Itoa(w.row)
+		switch v := value.(type) {
+		case nil:
+			continue
+		case string:
+			fmt.Fprintf(w.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+			xml.EscapeText(w.sheet, []byte(v))
+			w.sheet.WriteString(`</t></is></c>`)
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			fmt.Fprintf(w.sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		case int:
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case int64:
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case uint:
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case float64:
+			fmt.Fprintf(w.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+		case time.Time:
+			fmt.Fprintf(w.sheet, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, v.Format(time.RFC3339))
+		default:
+			return fmt.Errorf("xlsx: unsupported cell type %T", value)
+		}
+	}
+	_, err := w.sheet.WriteString(`</row>`)
+	return err
+}
+
+// Close finishes the last sheet and writes the workbook parts
+func (w *Writer) Close() error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	if len(w.sheets) == 0 {
+		return errors.New("xlsx: a workbook needs at least one sheet")
+	}
+
+	parts := []struct {
+		name string
+		body func(io.Writer)
+	}{
+		{"[Content_Types].xml", w.contentTypes},
+		{"_rels/.rels", func(out io.Writer) {
+			io.WriteString(out, xml.Header+`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`+
+				`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`+
+				`</Relationships>`)
+		}},
+		{"xl/workbook.xml", w.workbook},
+		{"xl/_rels/workbook.xml.rels", w.workbookRels},
+	}
+	for _, part := range parts {
+		f, err := w.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		part.body(f)
+	}
+
+	return w.zw.Close()
+}
+
+func (w *Writer) endSheet() error {
+	if w.sheet == nil {
+		return nil
+	}
+	if _, err := w.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	err := w.sheet.Flush()
+	w.sheet = nil
+	return err
+}
+
+func (w *Writer) contentTypes(out io.Writer) {
+	io.WriteString(out, xml.Header+`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`+
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`+
+		`<Default Extension="xml" ContentType="application/xml"/>`+
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	for i := range w.sheets {
+		fmt.Fprintf(out, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	io.WriteString(out, `</Types>`)
+}
+
+func (w *Writer) workbook(out io.Writer) {
+	io.WriteString(out, xml.Header+`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" `+
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, name := range w.sheets {
+		io.WriteString(out, `<sheet name="`)
+		xml.EscapeText(out, []byte(name))
+		fmt.Fprintf(out, `" sheetId="%d" r:id="rId%d"/>`, i+1, i+1)
+	}
+	io.WriteString(out, `</sheets></workbook>`)
+}
+
+func (w *Writer) workbookRels(out io.Writer) {
+	io.WriteString(out, xml.Header+`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := range w.sheets {
+		fmt.Fprintf(out, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	io.WriteString(out, `</Relationships>`)
+}
+
+// columnName turns a zero-based column index into A, B, ..., Z, AA, ...
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}